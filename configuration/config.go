@@ -1,14 +1,23 @@
 package configuration
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // Config representa la configuración de la aplicación
 type Config struct {
+	//Ruta del fichero del que se cargó la configuración
+	Path string `json:"-"`
+
 	//Configuración del servidor
 	Port string `json:"port"`
 
@@ -21,15 +30,258 @@ type Config struct {
 	MaxConnsPerIP      int   `json:"max_conns_per_ip"`
 	MaxRequestsPerConn int   `json:"max_requests_per_conn"`
 
+	//Segundo nivel en disco para las claves que no caben en memoria
+	DiskTier DiskTierConfig `json:"disk_tier"`
+
 	//Configuración de los nodos y sincronización
 	Peers                 []string `json:"peers"`
 	RetriesToDisabledNode int      `json:"max_retries_to_disabled_node"`
 	HeartBeatInterval     int      `json:"heart_beat_interval_in_seconds"`
+	MaxClockSkew          int      `json:"max_clock_skew_in_seconds"`
+	QuorumTimeout         int      `json:"quorum_timeout_in_ms"`
+
+	//Transporte entre nodos: conexiones persistentes con lotes binarios en pipeline
+	//("binary") o una petición JSON a /sync por cambio ("http", por defecto; también "json")
+	SyncTransport     string `json:"sync_transport"`
+	SyncBatchSize     int    `json:"sync_batch_size"`
+	SyncFlushInterval int    `json:"sync_flush_interval_in_ms"`
+	SyncPipelineDepth int    `json:"sync_pipeline_depth"`
+
+	//Dirección con la que el resto de nodos ven a este nodo (p.e. http://10.0.0.1:8080)
+	AdvertiseAddress string `json:"advertise_address"`
+
+	//Rol del nodo: "member" (por defecto) u "observer" (réplica de solo lectura)
+	Role string `json:"role"`
+
+	//Escrituras de clientes recibidas por un observer
+	Observer ObserverConfig `json:"observer"`
+
+	//Permitir recuperar la caché desde un observer si no hay otra fuente
+	RecoverFromObservers bool `json:"recover_from_observers"`
+
+	//Reenvío de las escrituras al nodo propietario de la clave o al primario
+	Forwarding ForwardingConfig `json:"forwarding"`
+
+	//Topología multi-región: 'peers' son los nodos de la región local y 'regions'
+	//las regiones remotas, a las que se replica en lotes a través de su gateway
+	Region  string         `json:"region"`
+	Regions []RegionConfig `json:"regions"`
+
+	//Membresía dinámica por gossip
+	Gossip GossipConfig `json:"gossip"`
+
+	//Cambios pendientes (hints) para los peers caídos, que se reenvían al volver
+	HintedHandoff HintedHandoffConfig `json:"hinted_handoff"`
+
+	//Compresión de los valores grandes dentro de la caché
+	Compression CompressionConfig `json:"compression"`
+
+	//Cifrado de los snapshots, el WAL y las exportaciones
+	Encryption EncryptionConfig `json:"encryption"`
+
+	//Snapshots de la caché en disco para arrancar aunque no quede ningún peer vivo
+	Snapshot SnapshotConfig `json:"snapshot"`
+
+	//Backups periódicos con retención, que se pueden restaurar desde /admin/restore
+	Backup BackupConfig `json:"backup"`
+
+	//Directorio de los ficheros de /admin/export y /admin/import ('path' es relativo a él)
+	DumpDir string `json:"dump_dir"`
+
+	//Almacén de origen de las claves por prefijo (write-through de /set)
+	Origins []OriginConfig `json:"origins"`
+
+	//Almacenes externos (ficheros, webhooks) a los que se envían los cambios en diferido
+	Sinks []SinkConfig `json:"sinks"`
+
+	//Precarga de las claves más usadas al arrancar, antes de atender a los clientes
+	Warmup WarmupConfig `json:"warmup"`
+
+	//Log de escrituras en disco (WAL) para no perder los cambios posteriores al último snapshot
+	WAL WALConfig `json:"wal"`
+
+	//Detección de particiones de red
+	Partition PartitionConfig `json:"partition"`
+
+	//Modo de replicación por defecto y por prefijo de clave (local, async, sync)
+	Replication ReplicationConfig `json:"replication"`
+
+	//Modo de consistencia fuerte (Raft) para prefijos de claves
+	Raft RaftConfig `json:"raft"`
 
 	//Fichero de configuración de la whitelist de los nodos.
 	WhiteListFilePath string `json:"white_list_file_path"`
 }
 
+// GossipConfig configura el protocolo de membresía estilo SWIM
+type GossipConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Seed           string `json:"seed"`
+	ProbeInterval  int    `json:"probe_interval_in_ms"`
+	ProbeTimeout   int    `json:"probe_timeout_in_ms"`
+	IndirectProbes int    `json:"indirect_probes"`
+	SuspectTimeout int    `json:"suspect_timeout_in_seconds"`
+}
+
+// DiskTierConfig configura el nivel en disco de la caché
+type DiskTierConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Dir       string `json:"dir"`
+	MaxSizeMB int    `json:"max_size_in_mb"`
+	MinTTL    int    `json:"min_ttl_in_seconds"` // TTL restante mínimo para bajar una clave a disco
+	MaxTTL    int    `json:"max_ttl_in_seconds"` // Tiempo máximo en disco (0: hasta que caduque)
+}
+
+// CompressionConfig configura la compresión de los valores
+type CompressionConfig struct {
+	Enabled bool   `json:"enabled"`
+	Codec   string `json:"codec"`             // gzip, zstd, snappy o auto (por defecto)
+	MinSize int    `json:"min_size_in_bytes"` // Los valores más pequeños no se comprimen
+}
+
+// EncryptionConfig configura el cifrado en reposo. Las claves se leen de 'key_file' y
+// de la variable de entorno 'key_env', una por línea (o separadas por comas) con el
+// formato <id>:<clave en base64>
+type EncryptionConfig struct {
+	Enabled   bool   `json:"enabled"`
+	KeyFile   string `json:"key_file"`
+	KeyEnv    string `json:"key_env"`    // Por defecto PHOENIX_ENCRYPTION_KEYS
+	ActiveKey string `json:"active_key"` // ID de la clave con la que se cifra (por defecto la primera)
+}
+
+// HintedHandoffConfig configura los hints que se guardan para los peers inactivos
+type HintedHandoffConfig struct {
+	Enabled  bool   `json:"enabled"`
+	MaxHints int    `json:"max_hints_per_peer"`
+	MaxAge   int    `json:"max_age_in_seconds"`
+	Dir      string `json:"dir"` // Vacío: solo en memoria
+}
+
+// SnapshotConfig configura los snapshots de la caché en disco
+type SnapshotConfig struct {
+	Enabled          bool   `json:"enabled"`
+	Path             string `json:"path"`
+	Interval         int    `json:"interval_in_seconds"` // Cada cuánto se guarda
+	SkipPeerRecovery bool   `json:"skip_peer_recovery"`  // No recuperar de los peers si se cargó el snapshot
+}
+
+// BackupConfig configura los backups programados
+type BackupConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Dir       string `json:"dir"`
+	Schedule  string `json:"schedule"`  // "@every 6h", "@hourly", "@daily" o "@daily 03:30"
+	Retention int    `json:"retention"` // Backups que se conservan
+}
+
+// WALConfig configura el log de escrituras
+type WALConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	Fsync     string `json:"fsync"`          // always, everysec (por defecto) o never
+	MaxSizeMB int    `json:"max_size_in_mb"` // Tamaño a partir del cual se compacta en un snapshot
+}
+
+// OriginConfig describe el almacén de origen de las claves con un prefijo. Es la
+// definición común para escribir en él (write-through) y para cargar de él
+type OriginConfig struct {
+	Prefix       string            `json:"prefix"`
+	URL          string            `json:"url"` // {key} se sustituye por la clave; si no aparece se añade ?key=
+	Headers      map[string]string `json:"headers"`
+	Timeout      int               `json:"timeout_in_ms"`
+	WriteThrough bool              `json:"write_through"`       // /set solo guarda la clave si el origen la acepta
+	WriteMethod  string            `json:"write_method"`        // POST (por defecto) o PUT
+	ReadThrough  bool              `json:"read_through"`        // El warm-up carga de aquí las claves que no tiene ningún peer
+	LoadTTL      int               `json:"load_ttl_in_seconds"` // TTL de las claves cargadas si el origen no manda X-Phoenix-TTL
+}
+
+// WarmupConfig configura la precarga de claves al arrancar. La lista tiene una clave
+// por línea; las que acaban en '*' son prefijos
+type WarmupConfig struct {
+	Enabled   bool   `json:"enabled"`
+	KeysFile  string `json:"keys_file"`
+	KeysURL   string `json:"keys_url"` // Endpoint que devuelve la lista (texto o array JSON)
+	Timeout   int    `json:"timeout_in_seconds"`
+	BatchSize int    `json:"batch_size"` // Claves por petición a /getKeys
+}
+
+// SinkConfig configura un almacén externo que recibe los cambios en lotes (write-behind)
+type SinkConfig struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`    // file o webhook
+	Path          string            `json:"path"`    // Fichero JSONL (file)
+	URL           string            `json:"url"`     // URL a la que se hace el POST (webhook)
+	Headers       map[string]string `json:"headers"` // Cabeceras de cada petición (webhook)
+	Timeout       int               `json:"timeout_in_ms"`
+	Prefixes      []string          `json:"prefixes"` // Vacío: se envían todas las claves
+	BatchSize     int               `json:"batch_size"`
+	FlushInterval int               `json:"flush_interval_in_ms"`
+	MaxQueue      int               `json:"max_queue"`
+	OnFull        string            `json:"on_full"` // block (por defecto) o drop
+	BlockTimeout  int               `json:"block_timeout_in_ms"`
+	RetryBackoff  int               `json:"retry_backoff_in_ms"` // Espera tras el primer fallo, se duplica en cada uno
+	MaxRetries    int               `json:"max_retries"`         // 0: se reintenta hasta que funcione
+}
+
+// RegionConfig describe una región remota
+type RegionConfig struct {
+	Name          string   `json:"name"`
+	Gateway       string   `json:"gateway"`
+	Prefixes      []string `json:"prefixes"` // Vacío: se replican todas las claves
+	BatchSize     int      `json:"batch_size"`
+	FlushInterval int      `json:"flush_interval_in_ms"`
+	MaxQueue      int      `json:"max_queue"`
+}
+
+// ObserverConfig configura un nodo observer
+type ObserverConfig struct {
+	ForwardWrites bool `json:"forward_writes"` // Reenviar las escrituras a un member en lugar de rechazarlas
+}
+
+// ForwardingConfig configura a qué nodo se reenvían las escrituras de los clientes
+type ForwardingConfig struct {
+	Mode    string `json:"mode"`    // none (por defecto), primary u owner
+	Primary string `json:"primary"` // Nodo primario en modo primary (vacío: el member activo con menor dirección)
+}
+
+// PartitionConfig configura la respuesta del nodo ante una partición de red
+type PartitionConfig struct {
+	RejectMinorityWrites bool `json:"reject_writes_in_minority"` // Rechazar escrituras sin mayoría
+}
+
+// ReplicationConfig configura cómo se replican las escrituras
+type ReplicationConfig struct {
+	Default string            `json:"default"`
+	Rules   []ReplicationRule `json:"rules"`
+}
+
+// ReplicationRule fija el modo de replicación de las claves con un prefijo. Con
+// 'pinned' el modo no se puede cambiar por petición
+type ReplicationRule struct {
+	Prefix string `json:"prefix"`
+	Mode   string `json:"mode"`
+	Pinned bool   `json:"pinned"`
+}
+
+// RaftConfig configura el grupo Raft para las claves con consistencia fuerte
+type RaftConfig struct {
+	Enabled           bool     `json:"enabled"`
+	Prefixes          []string `json:"prefixes"`
+	Peers             []string `json:"peers"` // Por defecto, los peers del nodo
+	HeartbeatInterval int      `json:"heartbeat_interval_in_ms"`
+	ElectionTimeout   int      `json:"election_timeout_in_ms"`
+	RequestTimeout    int      `json:"request_timeout_in_ms"`
+	SnapshotThreshold int      `json:"snapshot_threshold"`
+	StatePath         string   `json:"state_path"` // Término, voto, log y snapshot en disco
+}
+
+func replicationModes(rules []ReplicationRule) []string {
+	modes := make([]string, len(rules))
+	for i, rule := range rules {
+		modes[i] = rule.Mode
+	}
+	return modes
+}
+
 // LoadConfig carga la configuración desde un archivo JSON
 func LoadConfig(path string) Config {
 	file, err := os.Open(path)
@@ -47,15 +299,332 @@ func LoadConfig(path string) Config {
 	if err := json.Unmarshal(bytes, &config); err != nil {
 		log.Fatalf("❌ Error al parsear JSON de configuración: %v", err)
 	}
+	config.Path = path
 
 	//Si no vienen seteadas o vienen a 0
 	// le metemos datos por defecto...
+	if config.DiskTier.Dir == "" {
+		config.DiskTier.Dir = "tier"
+	}
+	if config.DiskTier.MaxSizeMB == 0 {
+		config.DiskTier.MaxSizeMB = 1024
+	}
+	if config.Compression.Codec == "" {
+		config.Compression.Codec = "auto"
+	}
+	switch config.Compression.Codec {
+	case "gzip", "zstd", "snappy", "auto":
+	default:
+		log.Fatalf("❌ Códec de compresión no válido: %q (gzip, zstd, snappy o auto)", config.Compression.Codec)
+	}
+	if config.Compression.MinSize == 0 {
+		config.Compression.MinSize = 1024
+	}
+	if config.Encryption.KeyEnv == "" {
+		config.Encryption.KeyEnv = "PHOENIX_ENCRYPTION_KEYS"
+	}
 	if config.HeartBeatInterval == 0 {
 		config.HeartBeatInterval = 5
 	}
 	if config.RetriesToDisabledNode == 0 {
 		config.RetriesToDisabledNode = 3
 	}
+	if config.MaxClockSkew == 0 {
+		config.MaxClockSkew = 5
+	}
+	if config.QuorumTimeout == 0 {
+		config.QuorumTimeout = 2000
+	}
+	if config.SyncTransport == "" {
+		config.SyncTransport = "http"
+	}
+	if config.SyncBatchSize == 0 {
+		config.SyncBatchSize = 256
+	}
+	if config.SyncFlushInterval == 0 {
+		config.SyncFlushInterval = 2
+	}
+	if config.SyncPipelineDepth == 0 {
+		config.SyncPipelineDepth = 16
+	}
+	if config.AdvertiseAddress == "" && strings.HasPrefix(config.Port, ":") {
+		config.AdvertiseAddress = "http://localhost" + config.Port
+	}
+	if config.Gossip.ProbeInterval == 0 {
+		config.Gossip.ProbeInterval = 1000
+	}
+	if config.Gossip.ProbeTimeout == 0 {
+		config.Gossip.ProbeTimeout = 500
+	}
+	if config.Gossip.IndirectProbes == 0 {
+		config.Gossip.IndirectProbes = 3
+	}
+	if config.Gossip.SuspectTimeout == 0 {
+		config.Gossip.SuspectTimeout = 5
+	}
+	if config.Snapshot.Path == "" {
+		config.Snapshot.Path = "snapshot.phx"
+	}
+	if config.Snapshot.Interval == 0 {
+		config.Snapshot.Interval = 300
+	}
+	if config.Backup.Dir == "" {
+		config.Backup.Dir = "backups"
+	}
+	if config.DumpDir == "" {
+		config.DumpDir = "dumps"
+	}
+	if config.Backup.Schedule == "" {
+		config.Backup.Schedule = "@daily"
+	}
+	if config.Backup.Retention == 0 {
+		config.Backup.Retention = 7
+	}
+	if config.WAL.Enabled {
+		// El WAL se compacta en el snapshot, así que necesita los snapshots
+		config.Snapshot.Enabled = true
+	}
+	if config.WAL.Path == "" {
+		config.WAL.Path = "wal.log"
+	}
+	if config.WAL.Fsync == "" {
+		config.WAL.Fsync = "everysec"
+	}
+	if config.WAL.Fsync != "always" && config.WAL.Fsync != "everysec" && config.WAL.Fsync != "never" {
+		log.Fatalf("❌ Modo de fsync del WAL no válido: %q (always, everysec o never)", config.WAL.Fsync)
+	}
+	if config.WAL.MaxSizeMB == 0 {
+		config.WAL.MaxSizeMB = 64
+	}
+	if config.HintedHandoff.MaxHints == 0 {
+		config.HintedHandoff.MaxHints = 10000
+	}
+	if config.HintedHandoff.MaxAge == 0 {
+		config.HintedHandoff.MaxAge = 3600
+	}
+	for i := range config.Regions {
+		region := &config.Regions[i]
+		if region.BatchSize == 0 {
+			region.BatchSize = 500
+		}
+		if region.FlushInterval == 0 {
+			region.FlushInterval = 500
+		}
+		if region.MaxQueue == 0 {
+			region.MaxQueue = 100000
+		}
+	}
+	for i := range config.Sinks {
+		sink := &config.Sinks[i]
+		if sink.Name == "" {
+			sink.Name = fmt.Sprintf("%s-%d", sink.Type, i)
+		}
+		switch sink.Type {
+		case "file":
+			if sink.Path == "" {
+				log.Fatalf("❌ El sink %s necesita 'path'", sink.Name)
+			}
+		case "webhook":
+			if sink.URL == "" {
+				log.Fatalf("❌ El sink %s necesita 'url'", sink.Name)
+			}
+		default:
+			log.Fatalf("❌ Tipo de sink no válido: %q (file o webhook)", sink.Type)
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 5000
+		}
+		if sink.BatchSize == 0 {
+			sink.BatchSize = 500
+		}
+		if sink.FlushInterval == 0 {
+			sink.FlushInterval = 1000
+		}
+		if sink.MaxQueue == 0 {
+			sink.MaxQueue = 100000
+		}
+		if sink.OnFull == "" {
+			sink.OnFull = "block"
+		}
+		if sink.OnFull != "block" && sink.OnFull != "drop" {
+			log.Fatalf("❌ on_full no válido en el sink %s: %q (block o drop)", sink.Name, sink.OnFull)
+		}
+		if sink.BlockTimeout == 0 {
+			sink.BlockTimeout = 1000
+		}
+		if sink.RetryBackoff == 0 {
+			sink.RetryBackoff = 500
+		}
+	}
+	for i := range config.Origins {
+		origin := &config.Origins[i]
+		if origin.URL == "" {
+			log.Fatalf("❌ El origen de '%s*' necesita 'url'", origin.Prefix)
+		}
+		if origin.Timeout == 0 {
+			origin.Timeout = 5000
+		}
+		origin.WriteMethod = strings.ToUpper(origin.WriteMethod)
+		if origin.WriteMethod == "" {
+			origin.WriteMethod = "POST"
+		}
+		if origin.WriteMethod != "POST" && origin.WriteMethod != "PUT" {
+			log.Fatalf("❌ write_method no válido en el origen de '%s*': %q (POST o PUT)", origin.Prefix, origin.WriteMethod)
+		}
+		if origin.LoadTTL == 0 {
+			origin.LoadTTL = 3600
+		}
+	}
+	if config.Warmup.Enabled && config.Warmup.KeysFile == "" && config.Warmup.KeysURL == "" {
+		log.Fatalf("❌ El warm-up necesita 'keys_file' o 'keys_url'")
+	}
+	if config.Warmup.Timeout == 0 {
+		config.Warmup.Timeout = 30
+	}
+	if config.Warmup.BatchSize == 0 {
+		config.Warmup.BatchSize = 500
+	}
+	if config.Role == "" {
+		config.Role = "member"
+	}
+	if config.Role != "member" && config.Role != "observer" {
+		log.Fatalf("❌ Rol de nodo no válido: %q (member u observer)", config.Role)
+	}
+	if config.Forwarding.Mode == "" {
+		config.Forwarding.Mode = "none"
+	}
+	if config.Forwarding.Mode != "none" && config.Forwarding.Mode != "primary" && config.Forwarding.Mode != "owner" {
+		log.Fatalf("❌ Modo de reenvío no válido: %q (none, primary u owner)", config.Forwarding.Mode)
+	}
+	if config.Replication.Default == "" {
+		config.Replication.Default = "async"
+	}
+	for _, mode := range append([]string{config.Replication.Default}, replicationModes(config.Replication.Rules)...) {
+		if mode != "local" && mode != "async" && mode != "sync" {
+			log.Fatalf("❌ Modo de replicación no válido: %q (local, async o sync)", mode)
+		}
+	}
+	if config.Raft.HeartbeatInterval == 0 {
+		config.Raft.HeartbeatInterval = 100
+	}
+	if config.Raft.ElectionTimeout == 0 {
+		config.Raft.ElectionTimeout = 1000
+	}
+	if config.Raft.RequestTimeout == 0 {
+		config.Raft.RequestTimeout = 3000
+	}
+	if config.Raft.SnapshotThreshold == 0 {
+		config.Raft.SnapshotThreshold = 1000
+	}
+	if config.Raft.StatePath == "" {
+		config.Raft.StatePath = "raft.state"
+	}
 
 	return config
 }
+
+// Serializa los cambios de la lista de peers y su escritura en el fichero
+var saveMu sync.Mutex
+
+// SavePeers cambia la lista de peers de la configuración por la que devuelve 'peers'
+// y la guarda en el fichero del que se cargó. La lista se obtiene con el cerrojo
+// tomado, para que dos guardados seguidos no escriban una lista antigua. Solo se
+// reescribe el campo 'peers': el resto del fichero queda como lo dejó el operador,
+// sin los valores por defecto. Se escribe en un fichero temporal del mismo
+// directorio y se renombra para no dejarlo a medias
+func SavePeers(config *Config, peers func() []string) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	config.Peers = peers()
+	if config.Path == "" {
+		return errors.New("la configuración no tiene fichero asociado")
+	}
+
+	original, err := os.ReadFile(config.Path)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(config.Peers)
+	if err != nil {
+		return err
+	}
+	data, err := patchField(original, "peers", value)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(config.Path), filepath.Base(config.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(config.Path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), config.Path)
+}
+
+// patchField sustituye (o añade al final) un campo de primer nivel de un objeto JSON
+// conservando el orden y el valor del resto de campos
+func patchField(data []byte, field string, value json.RawMessage) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return nil, errors.New("la configuración no es un objeto JSON")
+	}
+
+	type member struct {
+		key   string
+		value json.RawMessage
+	}
+	var members []member
+	found := false
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if key == field {
+			raw = value
+			found = true
+		}
+		members = append(members, member{key, raw})
+	}
+	if !found {
+		members = append(members, member{field, value})
+	}
+
+	var out bytes.Buffer
+	out.WriteString("{\n")
+	for i, m := range members {
+		name, _ := json.Marshal(m.key)
+		out.WriteString("    ")
+		out.Write(name)
+		out.WriteString(": ")
+		if err := json.Indent(&out, m.value, "    ", "    "); err != nil {
+			return nil, err
+		}
+		if i < len(members)-1 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString("}\n")
+	return out.Bytes(), nil
+}
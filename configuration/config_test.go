@@ -0,0 +1,81 @@
+package configuration
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSavePeersOnlyRewritesPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := `{
+    "port": ":8080",
+    "peers": ["http://a:8080"],
+    "white_list_file_path": "whitelist.json"
+}`
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := LoadConfig(path)
+	if err := SavePeers(&config, func() []string { return []string{"http://a:8080", "http://b:8080"} }); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]json.RawMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("JSON no válido: %v\n%s", err, data)
+	}
+	if len(saved) != 3 {
+		t.Fatalf("se esperaban solo los 3 campos del operador, hay %d:\n%s", len(saved), data)
+	}
+	var peers []string
+	json.Unmarshal(saved["peers"], &peers)
+	if strings.Join(peers, ",") != "http://a:8080,http://b:8080" {
+		t.Fatalf("peers = %s", saved["peers"])
+	}
+	if strings.Index(string(data), `"port"`) > strings.Index(string(data), `"peers"`) {
+		t.Fatalf("no se conservó el orden de los campos:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("permisos = %v, se esperaba 0600", info.Mode().Perm())
+	}
+}
+
+func TestSavePeersConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"port": ":8080"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := LoadConfig(path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := SavePeers(&config, func() []string { return []string{"http://a:8080"} }); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("quedan ficheros temporales: %v", entries)
+	}
+	data, _ := os.ReadFile(path)
+	var saved map[string]json.RawMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("JSON no válido: %v\n%s", err, data)
+	}
+}
@@ -0,0 +1,98 @@
+package consensus
+
+import (
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+)
+
+// CacheStateMachine aplica los comandos Raft sobre la caché. Los snapshots usan el
+// mismo formato que /export, limitado a las claves gestionadas por Raft
+type CacheStateMachine struct {
+	cache    *internal.Cache
+	prefixes []string
+}
+
+// NewCacheStateMachine crea la máquina de estados sobre la caché
+func NewCacheStateMachine(cache *internal.Cache, prefixes []string) *CacheStateMachine {
+	return &CacheStateMachine{cache: cache, prefixes: prefixes}
+}
+
+func (sm *CacheStateMachine) Apply(cmd Command) {
+	switch cmd.Op {
+	case "set":
+		sm.cache.SetUntil(cmd.Key, cmd.Value, time.UnixMilli(cmd.ExpiresAt))
+	case "remove":
+		sm.cache.RemoveKey(cmd.Key)
+	}
+}
+
+func (sm *CacheStateMachine) Snapshot() ([]byte, error) {
+	var items []internal.CacheEntry
+	for _, entry := range sm.cache.GetAll(false) {
+		if hasPrefix(entry.Key, sm.prefixes) {
+			items = append(items, entry)
+		}
+	}
+	return distributed.EncodeEntries(items)
+}
+
+func (sm *CacheStateMachine) Restore(data []byte) error {
+	entries, err := distributed.DecodeEntries(data)
+	if err != nil {
+		return err
+	}
+
+	for key := range sm.cache.GetDiff() {
+		if hasPrefix(key, sm.prefixes) {
+			sm.cache.RemoveKey(key)
+		}
+	}
+	for _, entry := range entries {
+		sm.cache.SetVersioned(entry.Key, entry.Value, time.UnixMilli(entry.ExpiresAt), entry.Version)
+	}
+	return nil
+}
+
+// NewCacheNode crea el nodo Raft de la configuración sobre la caché (nil si no está activo).
+// Si no se indican peers de Raft se usan los peers del nodo
+func NewCacheNode(config *configuration.Config, cache *internal.Cache) *Node {
+	if !config.Raft.Enabled {
+		return nil
+	}
+
+	members := config.Raft.Peers
+	if len(members) == 0 {
+		members = config.Peers
+	}
+
+	// El propio nodo puede aparecer con otro alias (127.0.0.1 por localhost): si se
+	// colara como peer votaría dos veces y el quorum sería mayor
+	var peers []string
+	for _, peer := range members {
+		if !distributed.IsSelf(peer) {
+			peers = append(peers, peer)
+		}
+	}
+
+	timeout := time.Duration(config.Raft.RequestTimeout) * time.Millisecond
+	opts := Options{
+		ID:                config.AdvertiseAddress,
+		Peers:             peers,
+		Prefixes:          config.Raft.Prefixes,
+		HeartbeatInterval: time.Duration(config.Raft.HeartbeatInterval) * time.Millisecond,
+		ElectionTimeout:   time.Duration(config.Raft.ElectionTimeout) * time.Millisecond,
+		RequestTimeout:    timeout,
+		SnapshotThreshold: uint64(config.Raft.SnapshotThreshold),
+		StatePath:         config.Raft.StatePath,
+	}
+
+	rpcTimeout := opts.HeartbeatInterval * 5
+	node := NewNode(opts, &HTTPTransport{Timeout: rpcTimeout}, NewCacheStateMachine(cache, config.Raft.Prefixes))
+
+	// Fuera del log (flush, sync, recuperación, replace, import) estas claves no se tocan
+	cache.ProtectKeys(node.Owns)
+	return node
+}
@@ -0,0 +1,858 @@
+package consensus
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles de un nodo Raft
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+)
+
+var (
+	ErrNotLeader      = errors.New("el nodo no es el líder")
+	ErrTimeout        = errors.New("tiempo de espera agotado esperando al quorum")
+	ErrLeadershipLost = errors.New("se perdió el liderazgo antes de confirmar la operación")
+)
+
+// Máximo de entradas por mensaje AppendEntries
+const maxEntriesPerAppend = 512
+
+// Command es la operación que se replica por el log
+type Command struct {
+	Op        string `json:"op"`
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// LogEntry es una entrada del log replicado
+type LogEntry struct {
+	Index   uint64  `json:"index"`
+	Term    uint64  `json:"term"`
+	Command Command `json:"command"`
+}
+
+// StateMachine es donde se aplican las entradas confirmadas
+type StateMachine interface {
+	Apply(cmd Command)
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Options configura un nodo Raft
+type Options struct {
+	ID                string   // Dirección del propio nodo
+	Peers             []string // Resto de miembros del grupo
+	Prefixes          []string // Prefijos de claves gestionados por Raft
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration // Mínimo; se aleatoriza hasta el doble
+	RequestTimeout    time.Duration // Espera máxima de las operaciones de cliente
+	SnapshotThreshold uint64        // Entradas aplicadas tras las que se compacta el log
+	StatePath         string        // Fichero del estado persistente (vacío: solo en memoria)
+}
+
+// Node es un miembro del grupo Raft
+type Node struct {
+	mu        sync.Mutex
+	opts      Options
+	transport Transport
+	sm        StateMachine
+
+	role        string
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+
+	log           []LogEntry // Entradas posteriores a snapshotIndex
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	snapshotData  []byte
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// Cambios pendientes de llevar a disco
+	dirty         bool
+	snapshotDirty bool
+
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	replicating map[string]bool
+
+	lastContact     time.Time
+	electionTimeout time.Duration
+
+	// Se cierra y se sustituye cada vez que cambia el estado para despertar a los que esperan
+	notify chan struct{}
+	stop   chan struct{}
+}
+
+// NewNode crea un nodo Raft como follower
+func NewNode(opts Options, transport Transport, sm StateMachine) *Node {
+	n := &Node{
+		opts:        opts,
+		transport:   transport,
+		sm:          sm,
+		role:        Follower,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		replicating: make(map[string]bool),
+		lastContact: time.Now(),
+		notify:      make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	n.electionTimeout = n.randomElectionTimeout()
+
+	// Sin su estado el nodo podría votar dos veces en un término u olvidar entradas confirmadas
+	if err := n.load(); err != nil {
+		log.Fatalf("❌ Error cargando el estado Raft de %s: %v", opts.StatePath, err)
+	}
+	return n
+}
+
+// Start arranca el ciclo de elecciones y heartbeats
+func (n *Node) Start() {
+	go n.run()
+}
+
+// Stop detiene el nodo
+func (n *Node) Stop() {
+	close(n.stop)
+}
+
+// Owns indica si una clave se gestiona con consistencia fuerte
+func (n *Node) Owns(key string) bool {
+	if n == nil {
+		return false
+	}
+	return hasPrefix(key, n.opts.Prefixes)
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestTimeout devuelve la espera máxima configurada para las operaciones de cliente
+func (n *Node) RequestTimeout() time.Duration {
+	return n.opts.RequestTimeout
+}
+
+// Leader devuelve la dirección del líder conocido (vacío si no hay)
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// IsLeader indica si este nodo es el líder
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+// NodeStatus resume el estado del nodo
+type NodeStatus struct {
+	ID          string `json:"id"`
+	Role        string `json:"role"`
+	Term        uint64 `json:"term"`
+	Leader      string `json:"leader"`
+	CommitIndex uint64 `json:"commit_index"`
+	LastApplied uint64 `json:"last_applied"`
+	LastIndex   uint64 `json:"last_index"`
+	Snapshot    uint64 `json:"snapshot_index"`
+}
+
+// Status devuelve el estado del nodo
+func (n *Node) Status() NodeStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return NodeStatus{
+		ID:          n.opts.ID,
+		Role:        n.role,
+		Term:        n.currentTerm,
+		Leader:      n.leaderID,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+		LastIndex:   n.lastIndex(),
+		Snapshot:    n.snapshotIndex,
+	}
+}
+
+//********************************************************************
+// Operaciones de cliente
+//********************************************************************
+
+// Propose añade un comando al log y espera a que se confirme por mayoría y se aplique
+func (n *Node) Propose(cmd Command, timeout time.Duration) error {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := n.currentTerm
+	index := n.lastIndex() + 1
+	n.log = append(n.log, LogEntry{Index: index, Term: term, Command: cmd})
+	n.dirty = true
+	if err := n.persist(); err != nil {
+		n.log = n.log[:len(n.log)-1]
+		n.mu.Unlock()
+		return err
+	}
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	n.broadcastAppend()
+
+	ok := n.waitFor(timeout, func() bool {
+		return n.lastApplied >= index || n.currentTerm != term
+	})
+	if !ok {
+		return ErrTimeout
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index > n.snapshotIndex && (index > n.lastIndex() || n.termAt(index) != term) {
+		return ErrLeadershipLost
+	}
+	if n.lastApplied < index {
+		return ErrLeadershipLost
+	}
+	return nil
+}
+
+// ReadBarrier garantiza una lectura linealizable: confirma que el nodo sigue siendo
+// líder con una ronda de heartbeats y espera a aplicar todo lo confirmado
+func (n *Node) ReadBarrier(timeout time.Duration) error {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := n.currentTerm
+	n.mu.Unlock()
+
+	// El líder necesita haber confirmado una entrada de su término (el noop de la elección)
+	if !n.waitFor(timeout, func() bool {
+		return n.currentTerm != term || n.termAt(n.commitIndex) == term
+	}) {
+		return ErrTimeout
+	}
+
+	n.mu.Lock()
+	if n.role != Leader || n.currentTerm != term {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	readIndex := n.commitIndex
+	n.mu.Unlock()
+
+	if !n.confirmLeadership(term) {
+		return ErrNotLeader
+	}
+
+	if !n.waitFor(timeout, func() bool { return n.lastApplied >= readIndex }) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// waitFor espera hasta que se cumpla cond (evaluada con el lock tomado) o venza el plazo
+func (n *Node) waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		n.mu.Lock()
+		if cond() {
+			n.mu.Unlock()
+			return true
+		}
+		ch := n.notify
+		n.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// signal despierta a los que esperan un cambio de estado (con el lock tomado)
+func (n *Node) signal() {
+	close(n.notify)
+	n.notify = make(chan struct{})
+}
+
+//********************************************************************
+// Elecciones y replicación
+//********************************************************************
+
+func (n *Node) run() {
+	ticker := time.NewTicker(n.opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		role := n.role
+		expired := time.Since(n.lastContact) > n.electionTimeout
+		n.mu.Unlock()
+
+		if role == Leader {
+			n.broadcastAppend()
+		} else if expired {
+			n.startElection()
+		}
+	}
+}
+
+func (n *Node) randomElectionTimeout() time.Duration {
+	return n.opts.ElectionTimeout + time.Duration(rand.Int63n(int64(n.opts.ElectionTimeout)))
+}
+
+func (n *Node) quorum() int {
+	return (len(n.opts.Peers)+1)/2 + 1
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.role = Candidate
+	n.currentTerm++
+	n.votedFor = n.opts.ID
+	n.leaderID = ""
+	n.lastContact = time.Now()
+	n.electionTimeout = n.randomElectionTimeout()
+	n.dirty = true
+	if err := n.persist(); err != nil {
+		log.Printf("⚠️ Error guardando el estado Raft, no se inicia la elección: %v", err)
+		n.role = Follower
+		n.mu.Unlock()
+		return
+	}
+	term := n.currentTerm
+	req := VoteRequest{
+		Term:         term,
+		CandidateID:  n.opts.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	n.signal()
+	n.mu.Unlock()
+
+	votes := 1
+	responses := make(chan VoteResponse, len(n.opts.Peers))
+	for _, peer := range n.opts.Peers {
+		go func(peer string) {
+			resp, err := n.transport.RequestVote(peer, req)
+			if err != nil {
+				resp = VoteResponse{}
+			}
+			responses <- resp
+		}(peer)
+	}
+
+	if votes >= n.quorum() {
+		n.becomeLeader(term)
+		return
+	}
+
+	for range n.opts.Peers {
+		resp := <-responses
+
+		n.mu.Lock()
+		if resp.Term > n.currentTerm {
+			n.stepDown(resp.Term)
+		}
+		stillCandidate := n.role == Candidate && n.currentTerm == term
+		n.mu.Unlock()
+
+		if !stillCandidate {
+			return
+		}
+		if resp.Granted {
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader(term)
+				return
+			}
+		}
+	}
+}
+
+func (n *Node) becomeLeader(term uint64) {
+	n.mu.Lock()
+	if n.role != Candidate || n.currentTerm != term {
+		n.mu.Unlock()
+		return
+	}
+
+	n.role = Leader
+	n.leaderID = n.opts.ID
+	for _, peer := range n.opts.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+
+	// Entrada vacía para poder confirmar lo heredado de términos anteriores
+	n.log = append(n.log, LogEntry{Index: n.lastIndex() + 1, Term: term, Command: Command{Op: "noop"}})
+	n.dirty = true
+	if err := n.persist(); err != nil {
+		log.Printf("⚠️ Error guardando el estado Raft, se renuncia al liderazgo: %v", err)
+		n.log = n.log[:len(n.log)-1]
+		n.leaderID = ""
+		n.stepDown(term)
+		n.mu.Unlock()
+		return
+	}
+	n.advanceCommit()
+	n.signal()
+	n.mu.Unlock()
+
+	log.Printf("👑 Elegido líder Raft en el término %d", term)
+	n.broadcastAppend()
+}
+
+// stepDown pasa a follower con un término mayor (con el lock tomado)
+func (n *Node) stepDown(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.dirty = true
+		n.persistOrLog()
+	}
+	if n.role != Follower {
+		n.role = Follower
+		n.electionTimeout = n.randomElectionTimeout()
+	}
+	n.signal()
+}
+
+func (n *Node) broadcastAppend() {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return
+	}
+	var peers []string
+	for _, peer := range n.opts.Peers {
+		if !n.replicating[peer] {
+			n.replicating[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, peer := range peers {
+		go n.replicate(peer)
+	}
+}
+
+// replicate envía al peer las entradas que le faltan hasta ponerlo al día
+func (n *Node) replicate(peer string) {
+	defer func() {
+		n.mu.Lock()
+		n.replicating[peer] = false
+		n.mu.Unlock()
+	}()
+
+	for {
+		n.mu.Lock()
+		if n.role != Leader {
+			n.mu.Unlock()
+			return
+		}
+		term := n.currentTerm
+
+		if n.nextIndex[peer] <= n.snapshotIndex {
+			req := SnapshotRequest{
+				Term:      term,
+				LeaderID:  n.opts.ID,
+				LastIndex: n.snapshotIndex,
+				LastTerm:  n.snapshotTerm,
+				Data:      n.snapshotData,
+			}
+			n.mu.Unlock()
+
+			resp, err := n.transport.InstallSnapshot(peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			if resp.Term > n.currentTerm {
+				n.stepDown(resp.Term)
+				n.mu.Unlock()
+				return
+			}
+			// Si no lo pudo instalar se reintenta en el siguiente heartbeat
+			if !resp.Success || n.role != Leader || n.currentTerm != term {
+				n.mu.Unlock()
+				return
+			}
+			if req.LastIndex > n.matchIndex[peer] {
+				n.matchIndex[peer] = req.LastIndex
+			}
+			n.nextIndex[peer] = req.LastIndex + 1
+			n.mu.Unlock()
+			continue
+		}
+
+		req := n.appendRequest(peer)
+		n.mu.Unlock()
+
+		resp, err := n.transport.AppendEntries(peer, req)
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if resp.Term > n.currentTerm {
+			n.stepDown(resp.Term)
+			n.mu.Unlock()
+			return
+		}
+		if n.role != Leader || n.currentTerm != term {
+			n.mu.Unlock()
+			return
+		}
+
+		if resp.Success {
+			match := req.PrevLogIndex + uint64(len(req.Entries))
+			if match > n.matchIndex[peer] {
+				n.matchIndex[peer] = match
+			}
+			n.nextIndex[peer] = match + 1
+			n.advanceCommit()
+		} else {
+			next := resp.ConflictIndex
+			if next < 1 {
+				next = 1
+			}
+			if next >= n.nextIndex[peer] {
+				next = n.nextIndex[peer] - 1
+			}
+			if next < 1 {
+				next = 1
+			}
+			n.nextIndex[peer] = next
+		}
+
+		pending := n.nextIndex[peer] <= n.lastIndex()
+		n.mu.Unlock()
+
+		if !pending {
+			return
+		}
+	}
+}
+
+// appendRequest construye el AppendEntries para un peer (con el lock tomado)
+func (n *Node) appendRequest(peer string) AppendRequest {
+	next := n.nextIndex[peer]
+	if next == 0 {
+		next = 1
+	}
+	prevIndex := next - 1
+
+	var entries []LogEntry
+	if next <= n.lastIndex() {
+		from := next - n.snapshotIndex - 1
+		to := uint64(len(n.log))
+		if to-from > maxEntriesPerAppend {
+			to = from + maxEntriesPerAppend
+		}
+		entries = append(entries, n.log[from:to]...)
+	}
+
+	return AppendRequest{
+		Term:         n.currentTerm,
+		LeaderID:     n.opts.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  n.termAt(prevIndex),
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// confirmLeadership envía un heartbeat a todos los peers y comprueba que una
+// mayoría sigue reconociendo el término
+func (n *Node) confirmLeadership(term uint64) bool {
+	if n.quorum() == 1 {
+		return true
+	}
+
+	acks := make(chan bool, len(n.opts.Peers))
+	for _, peer := range n.opts.Peers {
+		n.mu.Lock()
+		req := n.appendRequest(peer)
+		req.Entries = nil
+		n.mu.Unlock()
+
+		go func(peer string, req AppendRequest) {
+			resp, err := n.transport.AppendEntries(peer, req)
+			if err == nil && resp.Term > term {
+				n.mu.Lock()
+				n.stepDown(resp.Term)
+				n.mu.Unlock()
+			}
+			acks <- err == nil && resp.Term == term
+		}(peer, req)
+	}
+
+	confirmed := 1
+	for range n.opts.Peers {
+		if <-acks {
+			confirmed++
+			if confirmed >= n.quorum() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// advanceCommit avanza el commitIndex del líder a la mayor entrada de su término
+// replicada en una mayoría (con el lock tomado)
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && index > n.snapshotIndex; index-- {
+		if n.termAt(index) != n.currentTerm {
+			break
+		}
+
+		replicas := 1
+		for _, peer := range n.opts.Peers {
+			if n.matchIndex[peer] >= index {
+				replicas++
+			}
+		}
+		if replicas >= n.quorum() {
+			n.commitIndex = index
+			n.applyCommitted()
+			return
+		}
+	}
+}
+
+// applyCommitted aplica en la máquina de estados las entradas confirmadas (con el lock tomado)
+func (n *Node) applyCommitted() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entry := n.log[n.lastApplied-n.snapshotIndex-1]
+		if entry.Command.Op != "noop" {
+			n.sm.Apply(entry.Command)
+		}
+	}
+	n.maybeSnapshot()
+	n.signal()
+}
+
+// maybeSnapshot compacta el log cuando se supera el umbral (con el lock tomado)
+func (n *Node) maybeSnapshot() {
+	if n.opts.SnapshotThreshold == 0 || n.lastApplied-n.snapshotIndex < n.opts.SnapshotThreshold {
+		return
+	}
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		log.Printf("⚠️ Error generando el snapshot Raft: %v", err)
+		return
+	}
+
+	term := n.termAt(n.lastApplied)
+	n.log = append([]LogEntry(nil), n.log[n.lastApplied-n.snapshotIndex:]...)
+	n.snapshotIndex = n.lastApplied
+	n.snapshotTerm = term
+	n.snapshotData = data
+	n.snapshotDirty = true
+	n.persistOrLog()
+}
+
+//********************************************************************
+// Acceso al log (con el lock tomado)
+//********************************************************************
+
+func (n *Node) lastIndex() uint64 {
+	return n.snapshotIndex + uint64(len(n.log))
+}
+
+func (n *Node) termAt(index uint64) uint64 {
+	if index == n.snapshotIndex {
+		return n.snapshotTerm
+	}
+	if index < n.snapshotIndex || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.snapshotIndex-1].Term
+}
+
+//********************************************************************
+// RPCs recibidas
+//********************************************************************
+
+// RequestVote procesa una petición de voto. La respuesta solo se envía cuando el
+// término y el voto están en disco; si no se pueden guardar se devuelve el error
+func (n *Node) RequestVote(req VoteRequest) (VoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := n.vote(req)
+	return resp, n.persist()
+}
+
+// AppendEntries procesa la replicación (o el heartbeat) del líder. Las entradas
+// nuevas llegan a disco antes de responder
+func (n *Node) AppendEntries(req AppendRequest) (AppendResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := n.appendEntries(req)
+	return resp, n.persist()
+}
+
+// InstallSnapshot sustituye el estado local por el snapshot del líder
+func (n *Node) InstallSnapshot(req SnapshotRequest) (SnapshotResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := n.installSnapshot(req)
+	return resp, n.persist()
+}
+
+// vote decide el voto (con el lock tomado)
+func (n *Node) vote(req VoteRequest) VoteResponse {
+	if req.Term < n.currentTerm {
+		return VoteResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm {
+		n.stepDown(req.Term)
+	}
+
+	lastIndex := n.lastIndex()
+	lastTerm := n.termAt(lastIndex)
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.dirty = true
+		n.lastContact = time.Now()
+		return VoteResponse{Term: n.currentTerm, Granted: true}
+	}
+	return VoteResponse{Term: n.currentTerm}
+}
+
+// appendEntries aplica la replicación del líder (con el lock tomado)
+func (n *Node) appendEntries(req AppendRequest) AppendResponse {
+	if req.Term < n.currentTerm {
+		return AppendResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm || n.role != Follower {
+		n.stepDown(req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.lastContact = time.Now()
+
+	// Descartamos lo que ya esté incluido en nuestro snapshot
+	entries := req.Entries
+	prevIndex, prevTerm := req.PrevLogIndex, req.PrevLogTerm
+	if prevIndex < n.snapshotIndex {
+		skip := n.snapshotIndex - prevIndex
+		if skip > uint64(len(entries)) {
+			return AppendResponse{Term: n.currentTerm, Success: true}
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snapshotIndex, n.snapshotTerm
+	}
+
+	if prevIndex > n.lastIndex() {
+		return AppendResponse{Term: n.currentTerm, ConflictIndex: n.lastIndex() + 1}
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		return AppendResponse{Term: n.currentTerm, ConflictIndex: prevIndex}
+	}
+
+	for _, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.termAt(entry.Index) == entry.Term {
+				continue
+			}
+			// Conflicto: se descarta el resto del log local
+			n.log = n.log[:entry.Index-n.snapshotIndex-1]
+		}
+		n.log = append(n.log, entry)
+		n.dirty = true
+	}
+
+	// El commitIndex nunca retrocede, aunque llegue tarde un mensaje antiguo del líder
+	commit := req.LeaderCommit
+	if lastNew := prevIndex + uint64(len(entries)); lastNew < commit {
+		commit = lastNew
+	}
+	if commit > n.commitIndex {
+		n.commitIndex = commit
+		n.applyCommitted()
+	}
+
+	return AppendResponse{Term: n.currentTerm, Success: true}
+}
+
+// installSnapshot instala el snapshot del líder (con el lock tomado)
+func (n *Node) installSnapshot(req SnapshotRequest) SnapshotResponse {
+	if req.Term < n.currentTerm {
+		return SnapshotResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm || n.role != Follower {
+		n.stepDown(req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.lastContact = time.Now()
+
+	// Ya tenemos aplicado todo lo que incluye
+	if req.LastIndex <= n.snapshotIndex || req.LastIndex <= n.lastApplied {
+		return SnapshotResponse{Term: n.currentTerm, Success: true}
+	}
+
+	if err := n.sm.Restore(req.Data); err != nil {
+		log.Printf("⚠️ Error restaurando el snapshot Raft: %v", err)
+		return SnapshotResponse{Term: n.currentTerm}
+	}
+
+	// Conservamos las entradas posteriores al snapshot si coinciden con él
+	if req.LastIndex < n.lastIndex() && n.termAt(req.LastIndex) == req.LastTerm {
+		n.log = append([]LogEntry(nil), n.log[req.LastIndex-n.snapshotIndex:]...)
+	} else {
+		n.log = nil
+	}
+
+	n.snapshotIndex = req.LastIndex
+	n.snapshotTerm = req.LastTerm
+	n.snapshotData = req.Data
+	n.snapshotDirty = true
+	if n.commitIndex < req.LastIndex {
+		n.commitIndex = req.LastIndex
+	}
+	n.lastApplied = req.LastIndex
+	n.applyCommitted()
+
+	return SnapshotResponse{Term: n.currentTerm, Success: true}
+}
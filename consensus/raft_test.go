@@ -0,0 +1,354 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMachine es una máquina de estados clave/valor en memoria
+type memoryMachine struct {
+	mu         sync.Mutex
+	data       map[string]string
+	restoreErr error
+}
+
+func newMemoryMachine() *memoryMachine {
+	return &memoryMachine{data: make(map[string]string)}
+}
+
+func (m *memoryMachine) Apply(cmd Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch cmd.Op {
+	case "set":
+		m.data[cmd.Key] = cmd.Value
+	case "remove":
+		delete(m.data, cmd.Key)
+	}
+}
+
+func (m *memoryMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.data)
+}
+
+func (m *memoryMachine) Restore(data []byte) error {
+	if m.restoreErr != nil {
+		return m.restoreErr
+	}
+	restored := make(map[string]string)
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.data = restored
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryMachine) get(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.data[key]
+	return value, ok
+}
+
+// memoryCluster conecta los nodos en el mismo proceso. Un nodo aislado no envía ni
+// recibe RPCs
+type memoryCluster struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	isolated map[string]bool
+}
+
+type memoryTransport struct {
+	cluster *memoryCluster
+	from    string
+}
+
+var errUnreachable = errors.New("nodo inalcanzable")
+
+func (t *memoryTransport) target(peer string) (*Node, error) {
+	t.cluster.mu.Lock()
+	defer t.cluster.mu.Unlock()
+	node := t.cluster.nodes[peer]
+	if node == nil || t.cluster.isolated[peer] || t.cluster.isolated[t.from] {
+		return nil, errUnreachable
+	}
+	return node, nil
+}
+
+func (t *memoryTransport) RequestVote(peer string, req VoteRequest) (VoteResponse, error) {
+	node, err := t.target(peer)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	return node.RequestVote(req)
+}
+
+func (t *memoryTransport) AppendEntries(peer string, req AppendRequest) (AppendResponse, error) {
+	node, err := t.target(peer)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	return node.AppendEntries(req)
+}
+
+func (t *memoryTransport) InstallSnapshot(peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	node, err := t.target(peer)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+	return node.InstallSnapshot(req)
+}
+
+func testOptions(id string, peers []string) Options {
+	return Options{
+		ID:                id,
+		Peers:             peers,
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   80 * time.Millisecond,
+		RequestTimeout:    2 * time.Second,
+	}
+}
+
+// startCluster arranca size nodos conectados entre sí
+func startCluster(t *testing.T, size int, snapshotThreshold uint64) (*memoryCluster, map[string]*memoryMachine) {
+	cluster := &memoryCluster{nodes: make(map[string]*Node), isolated: make(map[string]bool)}
+	machines := make(map[string]*memoryMachine)
+
+	var ids []string
+	for i := 0; i < size; i++ {
+		ids = append(ids, fmt.Sprintf("node%d", i))
+	}
+	for _, id := range ids {
+		var peers []string
+		for _, other := range ids {
+			if other != id {
+				peers = append(peers, other)
+			}
+		}
+		opts := testOptions(id, peers)
+		opts.SnapshotThreshold = snapshotThreshold
+		machines[id] = newMemoryMachine()
+		cluster.nodes[id] = NewNode(opts, &memoryTransport{cluster: cluster, from: id}, machines[id])
+	}
+	for _, node := range cluster.nodes {
+		node.Start()
+	}
+	t.Cleanup(func() {
+		for _, node := range cluster.nodes {
+			node.Stop()
+		}
+	})
+	return cluster, machines
+}
+
+func (c *memoryCluster) setIsolated(id string, isolated bool) {
+	c.mu.Lock()
+	c.isolated[id] = isolated
+	c.mu.Unlock()
+}
+
+// waitLeader espera a que haya un único líder entre los nodos no aislados
+func (c *memoryCluster) waitLeader(t *testing.T, exclude string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		for id, node := range c.nodes {
+			if id != exclude && node.Status().Role == Leader {
+				leaders = append(leaders, id)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no se eligió un líder")
+	return ""
+}
+
+func waitApplied(t *testing.T, machines map[string]*memoryMachine, key, value string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, machine := range machines {
+			if got, _ := machine.get(key); got != value {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s=%s no llegó a todos los nodos", key, value)
+}
+
+func TestElectionAndReplication(t *testing.T) {
+	cluster, machines := startCluster(t, 3, 0)
+	leader := cluster.waitLeader(t, "")
+
+	for _, id := range []string{"node0", "node1", "node2"} {
+		if id == leader {
+			continue
+		}
+		if err := cluster.nodes[id].Propose(Command{Op: "set", Key: "k", Value: "v"}, time.Second); err != ErrNotLeader {
+			t.Fatalf("un follower aceptó la propuesta: %v", err)
+		}
+	}
+
+	if err := cluster.nodes[leader].Propose(Command{Op: "set", Key: "k", Value: "v"}, time.Second); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	waitApplied(t, machines, "k", "v")
+
+	if err := cluster.nodes[leader].ReadBarrier(time.Second); err != nil {
+		t.Fatalf("ReadBarrier: %v", err)
+	}
+}
+
+func TestLeaderFailover(t *testing.T) {
+	cluster, machines := startCluster(t, 3, 0)
+	oldLeader := cluster.waitLeader(t, "")
+	oldTerm := cluster.nodes[oldLeader].Status().Term
+
+	cluster.setIsolated(oldLeader, true)
+	leader := cluster.waitLeader(t, oldLeader)
+	if term := cluster.nodes[leader].Status().Term; term <= oldTerm {
+		t.Fatalf("el nuevo líder tiene el término %d, se esperaba mayor que %d", term, oldTerm)
+	}
+
+	// El líder aislado no consigue mayoría
+	if err := cluster.nodes[oldLeader].Propose(Command{Op: "set", Key: "k", Value: "perdido"}, 200*time.Millisecond); err == nil {
+		t.Fatalf("el líder aislado confirmó una escritura")
+	}
+	if err := cluster.nodes[leader].Propose(Command{Op: "set", Key: "k", Value: "nuevo"}, time.Second); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+
+	// Al volver, el antiguo líder descarta su entrada y se pone al día
+	cluster.setIsolated(oldLeader, false)
+	waitApplied(t, machines, "k", "nuevo")
+	if role := cluster.nodes[oldLeader].Status().Role; role != Follower {
+		t.Fatalf("el antiguo líder sigue como %s", role)
+	}
+}
+
+func TestLaggingFollowerReceivesSnapshot(t *testing.T) {
+	cluster, machines := startCluster(t, 3, 5)
+	leader := cluster.waitLeader(t, "")
+
+	var lagging string
+	for id := range cluster.nodes {
+		if id != leader {
+			lagging = id
+			break
+		}
+	}
+	cluster.setIsolated(lagging, true)
+
+	for i := 0; i < 20; i++ {
+		if err := cluster.nodes[leader].Propose(Command{Op: "set", Key: fmt.Sprintf("k%d", i), Value: "v"}, time.Second); err != nil {
+			t.Fatalf("Propose: %v", err)
+		}
+	}
+	if status := cluster.nodes[leader].Status(); status.Snapshot == 0 {
+		t.Fatalf("el líder no compactó el log: %+v", status)
+	}
+
+	cluster.setIsolated(lagging, false)
+	waitApplied(t, machines, "k19", "v")
+	waitApplied(t, machines, "k0", "v")
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	opts := testOptions("solo", nil)
+	opts.StatePath = filepath.Join(t.TempDir(), "raft.state")
+	opts.SnapshotThreshold = 3
+
+	machine := newMemoryMachine()
+	node := NewNode(opts, &memoryTransport{cluster: &memoryCluster{}}, machine)
+	node.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for node.Status().Role != Leader {
+		if time.Now().After(deadline) {
+			t.Fatalf("el nodo no se eligió líder")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		if err := node.Propose(Command{Op: "set", Key: fmt.Sprintf("k%d", i), Value: "v"}, time.Second); err != nil {
+			t.Fatalf("Propose: %v", err)
+		}
+	}
+	node.Stop()
+	before := node.Status()
+
+	restarted := newMemoryMachine()
+	node = NewNode(opts, &memoryTransport{cluster: &memoryCluster{}}, restarted)
+	after := node.Status()
+
+	if after.Term != before.Term || after.LastIndex != before.LastIndex || after.Snapshot != before.Snapshot {
+		t.Fatalf("estado tras reiniciar = %+v, antes = %+v", after, before)
+	}
+	if node.votedFor != "solo" {
+		t.Fatalf("votedFor = %q, se esperaba el propio nodo", node.votedFor)
+	}
+	// Lo compactado vuelve con el snapshot; el resto, al confirmarse de nuevo
+	if _, ok := restarted.get("k0"); !ok {
+		t.Fatalf("no se restauró el snapshot")
+	}
+	if resp, _ := node.RequestVote(VoteRequest{Term: before.Term, CandidateID: "otro", LastLogIndex: 100, LastLogTerm: before.Term}); resp.Granted {
+		t.Fatalf("votó dos veces en el término %d", before.Term)
+	}
+}
+
+func TestCommitIndexNeverMovesBack(t *testing.T) {
+	node := NewNode(testOptions("follower", []string{"leader"}), &memoryTransport{cluster: &memoryCluster{}}, newMemoryMachine())
+
+	entries := []LogEntry{
+		{Index: 1, Term: 1, Command: Command{Op: "set", Key: "a", Value: "1"}},
+		{Index: 2, Term: 1, Command: Command{Op: "set", Key: "b", Value: "2"}},
+	}
+	if resp, err := node.AppendEntries(AppendRequest{Term: 1, LeaderID: "leader", Entries: entries, LeaderCommit: 2}); err != nil || !resp.Success {
+		t.Fatalf("AppendEntries = %+v, %v", resp, err)
+	}
+
+	// Un mensaje retrasado con un commit anterior no hace retroceder el commitIndex
+	if resp, err := node.AppendEntries(AppendRequest{Term: 1, LeaderID: "leader", Entries: entries[:1], LeaderCommit: 1}); err != nil || !resp.Success {
+		t.Fatalf("AppendEntries = %+v, %v", resp, err)
+	}
+	if commit := node.Status().CommitIndex; commit != 2 {
+		t.Fatalf("commitIndex = %d, se esperaba 2", commit)
+	}
+}
+
+func TestInstallSnapshotReportsFailure(t *testing.T) {
+	machine := newMemoryMachine()
+	machine.restoreErr = errors.New("snapshot corrupto")
+	node := NewNode(testOptions("follower", []string{"leader"}), &memoryTransport{cluster: &memoryCluster{}}, machine)
+
+	resp, err := node.InstallSnapshot(SnapshotRequest{Term: 1, LeaderID: "leader", LastIndex: 10, LastTerm: 1, Data: []byte("{}")})
+	if err != nil {
+		t.Fatalf("InstallSnapshot: %v", err)
+	}
+	if resp.Success {
+		t.Fatalf("se confirmó un snapshot que no se pudo restaurar")
+	}
+
+	machine.restoreErr = nil
+	if resp, _ := node.InstallSnapshot(SnapshotRequest{Term: 1, LeaderID: "leader", LastIndex: 10, LastTerm: 1, Data: []byte("{}")}); !resp.Success {
+		t.Fatalf("no se confirmó un snapshot válido")
+	}
+}
@@ -0,0 +1,148 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// persistentState es lo que Raft tiene que conservar tras un reinicio para no votar
+// dos veces en un término ni perder entradas que ya contaron para una mayoría
+type persistentState struct {
+	Term     uint64     `json:"term"`
+	VotedFor string     `json:"voted_for,omitempty"`
+	Log      []LogEntry `json:"log"`
+}
+
+// persistentSnapshot va en su propio fichero porque solo cambia al compactar
+type persistentSnapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// persist guarda el estado en disco con fsync si ha cambiado desde la última vez
+// (con el lock tomado). Sin StatePath el estado solo vive en memoria
+func (n *Node) persist() error {
+	if n.opts.StatePath == "" {
+		n.dirty, n.snapshotDirty = false, false
+		return nil
+	}
+
+	// El snapshot primero: si se corta antes de escribir el estado, al cargar se
+	// descartan las entradas que ya incluye
+	if n.snapshotDirty {
+		data, err := json.Marshal(persistentSnapshot{Index: n.snapshotIndex, Term: n.snapshotTerm, Data: n.snapshotData})
+		if err != nil {
+			return err
+		}
+		if err := writeFileSync(n.opts.StatePath+".snap", data); err != nil {
+			return err
+		}
+		n.snapshotDirty = false
+		n.dirty = true
+	}
+	if !n.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(persistentState{Term: n.currentTerm, VotedFor: n.votedFor, Log: n.log})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(n.opts.StatePath, data); err != nil {
+		return err
+	}
+	n.dirty = false
+	return nil
+}
+
+// persistOrLog guarda el estado y solo deja constancia del error, para los cambios
+// que no responden a nadie (el siguiente persist lo vuelve a intentar)
+func (n *Node) persistOrLog() {
+	if err := n.persist(); err != nil {
+		log.Printf("⚠️ Error guardando el estado Raft: %v", err)
+	}
+}
+
+// load recupera el estado guardado y restaura el snapshot en la máquina de estados
+func (n *Node) load() error {
+	if n.opts.StatePath == "" {
+		return nil
+	}
+
+	if data, err := os.ReadFile(n.opts.StatePath + ".snap"); err == nil {
+		var snap persistentSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return err
+		}
+		if err := n.sm.Restore(snap.Data); err != nil {
+			return err
+		}
+		n.snapshotIndex, n.snapshotTerm, n.snapshotData = snap.Index, snap.Term, snap.Data
+		n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	data, err := os.ReadFile(n.opts.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state persistentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	n.currentTerm, n.votedFor = state.Term, state.VotedFor
+
+	// Las entradas ya incluidas en el snapshot sobran
+	for i, entry := range state.Log {
+		if entry.Index == n.snapshotIndex+1 {
+			n.log = state.Log[i:]
+			break
+		}
+	}
+
+	log.Printf("📂 Estado Raft cargado: término %d, snapshot %d, %d entradas en el log", n.currentTerm, n.snapshotIndex, len(n.log))
+	return nil
+}
+
+// writeFileSync sustituye el fichero de forma atómica y espera a que llegue al disco
+func writeFileSync(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// El renombrado solo es definitivo cuando se sincroniza el directorio
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
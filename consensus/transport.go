@@ -0,0 +1,192 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// VoteRequest es la petición de voto de un candidato
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest replica entradas del log (vacío es un heartbeat)
+type AppendRequest struct {
+	Term         uint64     `json:"term"`
+	LeaderID     string     `json:"leader_id"`
+	PrevLogIndex uint64     `json:"prev_log_index"`
+	PrevLogTerm  uint64     `json:"prev_log_term"`
+	Entries      []LogEntry `json:"entries,omitempty"`
+	LeaderCommit uint64     `json:"leader_commit"`
+}
+
+type AppendResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// SnapshotRequest envía el snapshot completo a un follower que se ha quedado atrás
+type SnapshotRequest struct {
+	Term      uint64 `json:"term"`
+	LeaderID  string `json:"leader_id"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+	Data      []byte `json:"data"`
+}
+
+// SnapshotResponse indica si el follower instaló el snapshot. Sin Success el líder no
+// avanza su matchIndex
+type SnapshotResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+}
+
+// Transport es el canal por el que se comunican los nodos Raft
+type Transport interface {
+	RequestVote(peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+// HTTPTransport implementa Transport sobre los endpoints /raft/* de cada nodo
+type HTTPTransport struct {
+	Timeout time.Duration
+}
+
+func (t *HTTPTransport) RequestVote(peer string, req VoteRequest) (VoteResponse, error) {
+	var resp VoteResponse
+	err := t.call(peer+"/raft/vote", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) AppendEntries(peer string, req AppendRequest) (AppendResponse, error) {
+	var resp AppendResponse
+	err := t.call(peer+"/raft/append", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	var resp SnapshotResponse
+	err := t.call(peer+"/raft/snapshot", req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) call(url string, payload interface{}, out interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(data)
+
+	if err := fasthttp.DoTimeout(req, resp, t.Timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("código de estado %d", resp.StatusCode())
+	}
+	return json.Unmarshal(resp.Body(), out)
+}
+
+//********************************************************************
+// Handlers de las RPCs Raft
+//********************************************************************
+
+// HandleRequestVote atiende /raft/vote
+func HandleRequestVote(node *Node, ctx *fasthttp.RequestCtx) {
+	var req VoteRequest
+	if !decodeRPC(node, ctx, &req) {
+		return
+	}
+	resp, err := node.RequestVote(req)
+	if err != nil {
+		rpcError(ctx, err)
+		return
+	}
+	writeRPC(ctx, resp)
+}
+
+// HandleAppendEntries atiende /raft/append
+func HandleAppendEntries(node *Node, ctx *fasthttp.RequestCtx) {
+	var req AppendRequest
+	if !decodeRPC(node, ctx, &req) {
+		return
+	}
+	resp, err := node.AppendEntries(req)
+	if err != nil {
+		rpcError(ctx, err)
+		return
+	}
+	writeRPC(ctx, resp)
+}
+
+// HandleInstallSnapshot atiende /raft/snapshot
+func HandleInstallSnapshot(node *Node, ctx *fasthttp.RequestCtx) {
+	var req SnapshotRequest
+	if !decodeRPC(node, ctx, &req) {
+		return
+	}
+	resp, err := node.InstallSnapshot(req)
+	if err != nil {
+		rpcError(ctx, err)
+		return
+	}
+	writeRPC(ctx, resp)
+}
+
+// HandleStatus devuelve el estado del nodo Raft
+func HandleStatus(node *Node, ctx *fasthttp.RequestCtx) {
+	if node == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+	writeRPC(ctx, node.Status())
+}
+
+func decodeRPC(node *Node, ctx *fasthttp.RequestCtx, req interface{}) bool {
+	if node == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return false
+	}
+	if err := json.Unmarshal(ctx.PostBody(), req); err != nil {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// rpcError responde cuando no se pudo guardar el estado: sin él no se puede responder
+func rpcError(ctx *fasthttp.RequestCtx, err error) {
+	log.Printf("⚠️ Error guardando el estado Raft: %v", err)
+	ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(`{"error": "❌ No se pudo guardar el estado Raft"}`)
+}
+
+func writeRPC(ctx *fasthttp.RequestCtx, resp interface{}) {
+	data, _ := json.Marshal(resp)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
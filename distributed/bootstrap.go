@@ -0,0 +1,312 @@
+package distributed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Espera máxima de cada sondeo a /info durante el arranque
+const probeTimeout = 2 * time.Second
+
+var ErrNoRecoverySource = errors.New("ningún peer pudo servir su caché")
+
+// NodeInfo es lo que un nodo cuenta de sí mismo en /info. Sirve para elegir la
+// mejor fuente de recuperación y para detectar que un peer es el propio nodo
+type NodeInfo struct {
+	NodeID     string `json:"node_id"`
+	Address    string `json:"address"`
+	Role       string `json:"role,omitempty"`
+	Protocol   int    `json:"protocol"`
+	Keys       int    `json:"keys"`
+	MaxVersion uint64 `json:"max_version"` // Reloj de la última escritura vista
+	StartedAt  int64  `json:"started_at"`  // Unix ms
+}
+
+// SourceCandidate es el resultado de sondear un peer
+type SourceCandidate struct {
+	Peer  string    `json:"peer"`
+	Info  *NodeInfo `json:"info,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// BootstrapStatus es el informe de la recuperación de arranque
+type BootstrapStatus struct {
+	State      string            `json:"state"` // pending, probing, importing, done, empty, failed, skipped
+	Source     string            `json:"source,omitempty"`
+	Imported   int               `json:"imported"`
+	Candidates []SourceCandidate `json:"candidates,omitempty"`
+	Attempts   []string          `json:"attempts,omitempty"` // Peers probados en orden
+	Error      string            `json:"error,omitempty"`
+	StartedAt  string            `json:"started_at,omitempty"`
+	FinishedAt string            `json:"finished_at,omitempty"`
+}
+
+var (
+	bootstrapMu     sync.Mutex
+	bootstrapStatus = BootstrapStatus{State: "pending"}
+)
+
+// IsSelf indica si una dirección apunta al propio nodo. Las direcciones de loopback
+// (localhost, 127.0.0.1...) se consideran la misma si coincide el puerto
+func IsSelf(peer string) bool {
+	if localAddress == "" || peer == "" {
+		return false
+	}
+
+	a, b := normalizeAddress(peer), normalizeAddress(localAddress)
+	if a == b {
+		return true
+	}
+
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return isLoopback(ua.Hostname()) && isLoopback(ub.Hostname()) && ua.Port() == ub.Port()
+}
+
+func normalizeAddress(address string) string {
+	return strings.TrimSuffix(strings.ToLower(address), "/")
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// LocalInfo devuelve la información del nodo local
+func LocalInfo(cache *internal.Cache) NodeInfo {
+	return NodeInfo{
+		NodeID:     nodeID,
+		Address:    localAddress,
+		Role:       localRole,
+		Protocol:   ProtocolVersion,
+		Keys:       cache.Len(),
+		MaxVersion: cache.MaxVersion(),
+		StartedAt:  startedAt.UnixMilli(),
+	}
+}
+
+// FetchInfo pide a un peer su /info
+func FetchInfo(peer string, timeout time.Duration) (NodeInfo, error) {
+	var info NodeInfo
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(peer + "/info")
+	if err := fasthttp.DoTimeout(req, resp, timeout); err != nil {
+		return info, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return info, fmt.Errorf("código de estado %d", resp.StatusCode())
+	}
+	if err := json.Unmarshal(resp.Body(), &info); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// ProbePeers sondea en paralelo todos los peers conocidos y negocia el protocolo con
+// ellos. Los que resultan ser el propio nodo (mismo node_id) se eliminan de la lista
+// de peers
+func ProbePeers(peerManager *PeerManager) []SourceCandidate {
+	peers := peerManager.GetPeers()
+	candidates := make([]SourceCandidate, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			candidates[i].Peer = peer
+			info, err := FetchInfo(peer, probeTimeout)
+			if err != nil {
+				candidates[i].Error = err.Error()
+				return
+			}
+			// Un peer con un protocolo incompatible no puede ser fuente de recuperación
+			if info.NodeID != nodeID {
+				if negotiated, ok := peerManager.handshake(peer); ok && !negotiated.Compatible {
+					candidates[i].Error = "protocolo incompatible: " + negotiated.Error
+					return
+				}
+			}
+			candidates[i].Info = &info
+		}(i, peer)
+	}
+	wg.Wait()
+
+	result := candidates[:0]
+	for _, candidate := range candidates {
+		if candidate.Info != nil && candidate.Info.NodeID == nodeID {
+			log.Printf("ℹ️ %s es este mismo nodo, se elimina de los peers", candidate.Peer)
+			peerManager.RemovePeer(candidate.Peer)
+			continue
+		}
+		result = append(result, candidate)
+	}
+	return result
+}
+
+// rankSources ordena los peers que respondieron del más al menos actualizado: primero
+// por la última escritura vista y después por número de claves. Los observers solo
+// se usan si se permite con recover_from_observers, y siempre detrás de los members
+func rankSources(candidates []SourceCandidate) []SourceCandidate {
+	var ranked []SourceCandidate
+	for _, candidate := range candidates {
+		if candidate.Info != nil && (candidate.Info.Role != RoleObserver || recoverFromObservers) {
+			ranked = append(ranked, candidate)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].Info, ranked[j].Info
+		if observerA, observerB := a.Role == RoleObserver, b.Role == RoleObserver; observerA != observerB {
+			return observerB
+		}
+		if a.MaxVersion != b.MaxVersion {
+			return a.MaxVersion > b.MaxVersion
+		}
+		return a.Keys > b.Keys
+	})
+	return ranked
+}
+
+// Bootstrap recupera la caché al arrancar desde el peer más actualizado, probando con
+// los siguientes si falla. Las claves importadas se mezclan con las locales por versión,
+// así que la caché local nunca se vacía. Las claves escritas o borradas mientras tanto
+// (con warm-up el nodo ya atiende a los clientes) no se tocan
+func Bootstrap(peerManager *PeerManager, cache *internal.Cache) {
+	tracker := cache.TrackChanges()
+	defer cache.StopTracking(tracker)
+
+	setBootstrap(func(s *BootstrapStatus) {
+		s.State = "probing"
+		s.StartedAt = time.Now().Format(time.RFC3339)
+	})
+
+	if len(peerManager.GetPeers()) == 0 {
+		finishBootstrap("skipped", "", 0, nil)
+		return
+	}
+
+	log.Printf("🔎 Sondeando %d peers para recuperar la caché", len(peerManager.GetPeers()))
+	candidates := ProbePeers(peerManager)
+	setBootstrap(func(s *BootstrapStatus) { s.Candidates = candidates })
+
+	for _, candidate := range candidates {
+		if candidate.Error != "" {
+			log.Printf("⚠️ %s no responde: %s", candidate.Peer, candidate.Error)
+		}
+	}
+
+	ranked := rankSources(candidates)
+	if len(ranked) == 0 {
+		finishBootstrap("failed", "", 0, ErrNoRecoverySource)
+		log.Printf("⚠️ Ningún peer puede servir de fuente (caídos, incompatibles u observers), se arranca con la caché local")
+		return
+	}
+	if ranked[0].Info.Keys == 0 {
+		finishBootstrap("empty", "", 0, nil)
+		log.Printf("ℹ️ Ningún peer tiene claves, no hay nada que recuperar")
+		return
+	}
+
+	setBootstrap(func(s *BootstrapStatus) { s.State = "importing" })
+	for i, candidate := range ranked {
+		if candidate.Info.Keys == 0 {
+			break
+		}
+		log.Printf("📥 Recuperando la caché de %s (%d/%d, %d claves)", candidate.Peer, i+1, len(ranked), candidate.Info.Keys)
+		setBootstrap(func(s *BootstrapStatus) { s.Attempts = append(s.Attempts, candidate.Peer) })
+
+		entries, offset, err := fetchExport(peerManager, candidate.Peer)
+		if err != nil {
+			log.Printf("⚠️ No se pudo recuperar la caché de %s: %v", candidate.Peer, err)
+			continue
+		}
+
+		internal.CacheMutex.Lock()
+		applyEntries(cache, tracker, entries, offset)
+		internal.CacheMutex.Unlock()
+
+		finishBootstrap("done", candidate.Peer, len(entries), nil)
+		log.Printf("✅ Caché recuperada con éxito desde %s (%d claves)", candidate.Peer, len(entries))
+		return
+	}
+
+	finishBootstrap("failed", "", 0, ErrNoRecoverySource)
+	log.Printf("⚠️ %v, se arranca con la caché local", ErrNoRecoverySource)
+}
+
+// SkipBootstrap deja constancia de que no se recupera la caché de los peers porque ya
+// se cargó de otra fuente (p.e. un snapshot en disco)
+func SkipBootstrap(source string) {
+	setBootstrap(func(s *BootstrapStatus) { s.StartedAt = time.Now().Format(time.RFC3339) })
+	finishBootstrap("skipped", source, 0, nil)
+	log.Printf("ℹ️ No se recupera la caché de los peers: cargada desde %s", source)
+}
+
+func setBootstrap(update func(*BootstrapStatus)) {
+	bootstrapMu.Lock()
+	defer bootstrapMu.Unlock()
+	update(&bootstrapStatus)
+}
+
+func finishBootstrap(state, source string, imported int, err error) {
+	setBootstrap(func(s *BootstrapStatus) {
+		s.State = state
+		s.Source = source
+		s.Imported = imported
+		if err != nil {
+			s.Error = err.Error()
+		}
+		s.FinishedAt = time.Now().Format(time.RFC3339)
+	})
+}
+
+// GetBootstrapStatus devuelve el informe de la recuperación de arranque
+func GetBootstrapStatus() BootstrapStatus {
+	bootstrapMu.Lock()
+	defer bootstrapMu.Unlock()
+	return bootstrapStatus
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleInfo devuelve la identidad y el estado de la caché del nodo
+func HandleInfo(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	data, _ := json.Marshal(LocalInfo(cache))
+	SetProtocolHeaders(ctx)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+// HandleBootstrapStatus devuelve el informe de la recuperación de arranque
+func HandleBootstrapStatus(ctx *fasthttp.RequestCtx) {
+	data, _ := json.Marshal(GetBootstrapStatus())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
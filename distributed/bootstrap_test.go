@@ -0,0 +1,40 @@
+package distributed
+
+import "testing"
+
+func TestRankSourcesPrefersMostUpToDateMembers(t *testing.T) {
+	candidates := []SourceCandidate{
+		{Peer: "http://caido:8080", Error: "connection refused"},
+		{Peer: "http://observer:8080", Info: &NodeInfo{Role: RoleObserver, MaxVersion: 900, Keys: 50}},
+		{Peer: "http://antiguo:8080", Info: &NodeInfo{MaxVersion: 100, Keys: 80}},
+		{Peer: "http://pocas:8080", Info: &NodeInfo{MaxVersion: 500, Keys: 10}},
+		{Peer: "http://muchas:8080", Info: &NodeInfo{MaxVersion: 500, Keys: 40}},
+	}
+
+	order := func() []string {
+		var peers []string
+		for _, candidate := range rankSources(candidates) {
+			peers = append(peers, candidate.Peer)
+		}
+		return peers
+	}
+	check := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("orden = %v, se esperaba %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("orden = %v, se esperaba %v", got, want)
+			}
+		}
+	}
+
+	defer func(allowed bool) { recoverFromObservers = allowed }(recoverFromObservers)
+	recoverFromObservers = false
+	check(order(), "http://muchas:8080", "http://pocas:8080", "http://antiguo:8080")
+
+	// Los observers solo se usan si se permite, y siempre detrás de los members
+	recoverFromObservers = true
+	check(order(), "http://muchas:8080", "http://pocas:8080", "http://antiguo:8080", "http://observer:8080")
+}
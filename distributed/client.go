@@ -0,0 +1,51 @@
+package distributed
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// postJSON envía un POST con el payload serializado en JSON y devuelve el código
+// de estado y una copia del cuerpo de la respuesta
+func postJSON(url string, payload interface{}, timeout time.Duration) (int, []byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	setNodeHeader(req)
+	req.SetBody(data)
+
+	if err := fasthttp.DoTimeout(req, resp, timeout); err != nil {
+		return 0, nil, err
+	}
+
+	body := append([]byte(nil), resp.Body()...)
+	return resp.StatusCode(), body, nil
+}
+
+// getBody hace un GET y devuelve el código de estado y una copia del cuerpo
+func getBody(url string, timeout time.Duration) (int, []byte, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	if err := fasthttp.DoTimeout(req, resp, timeout); err != nil {
+		return 0, nil, err
+	}
+
+	body := append([]byte(nil), resp.Body()...)
+	return resp.StatusCode(), body, nil
+}
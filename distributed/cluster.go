@@ -0,0 +1,315 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Espera máxima de cada nodo en las operaciones de cluster
+const clusterTimeout = 5 * time.Second
+
+// NodeResult es el resultado de una operación de cluster en un nodo
+type NodeResult struct {
+	Node       string     `json:"node"`
+	OK         bool       `json:"ok"`
+	Error      string     `json:"error,omitempty"`
+	DurationMs float64    `json:"duration_ms"`
+	Keys       int        `json:"keys"`
+	Stats      *NodeStats `json:"stats,omitempty"`
+}
+
+// ClusterReport resume el resultado de una operación en todos los nodos
+type ClusterReport struct {
+	Nodes     []NodeResult `json:"nodes"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+// NodeStats son las estadísticas de un nodo (/stats)
+type NodeStats struct {
+	NodeInfo
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Peers         int     `json:"peers"`
+	ActivePeers   int     `json:"active_peers"`
+
+	DiskTier    *internal.TierStats        `json:"disk_tier,omitempty"`
+	Compression *internal.CompressionStats `json:"compression,omitempty"`
+}
+
+// ClusterEntry es una clave de /cluster/list con los nodos que la tienen. Si las
+// versiones no coinciden se muestra la más reciente y se marca como divergente
+type ClusterEntry struct {
+	internal.CacheEntry
+	Nodes     []string `json:"nodes"`
+	Divergent bool     `json:"divergent,omitempty"`
+}
+
+// ClusterList es la respuesta de /cluster/list
+type ClusterList struct {
+	ClusterReport
+	Items []ClusterEntry `json:"items"`
+}
+
+// ClusterKeys es la respuesta de /cluster/keys
+type ClusterKeys struct {
+	ClusterReport
+	Keys []string `json:"keys"`
+}
+
+// ClusterStats es la respuesta de /cluster/stats
+type ClusterStats struct {
+	ClusterReport
+	TotalKeys int `json:"total_keys"`
+	MinKeys   int `json:"min_keys"`
+	MaxKeys   int `json:"max_keys"`
+}
+
+// LocalStats devuelve las estadísticas del nodo local
+func LocalStats(peerManager *PeerManager, cache *internal.Cache) NodeStats {
+	return NodeStats{
+		NodeInfo:      LocalInfo(cache),
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+		Peers:         len(peerManager.GetPeers()),
+		ActivePeers:   len(peerManager.GetActivePeers()),
+		DiskTier:      cache.DiskTierStats(),
+		Compression:   cache.CompressionStats(),
+	}
+}
+
+// fanOut ejecuta la operación en el nodo local y en paralelo en cada peer. Los peers
+// de 'skipped' no se contactan y cuentan como fallidos
+func fanOut(peers []string, skipped []NodeResult, local func(*NodeResult), remote func(peer string, result *NodeResult) error) ClusterReport {
+	self := localAddress
+	if self == "" {
+		self = "local"
+	}
+
+	start := time.Now()
+	localResult := NodeResult{Node: self, OK: true}
+	local(&localResult)
+	localResult.DurationMs = durationMs(start)
+
+	results := make([]NodeResult, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			start := time.Now()
+			results[i].Node = peer
+			if err := remote(peer, &results[i]); err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].OK = true
+			}
+			results[i].DurationMs = durationMs(start)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	report := ClusterReport{Nodes: append([]NodeResult{localResult}, results...)}
+	report.Nodes = append(report.Nodes, skipped...)
+	for _, result := range report.Nodes {
+		if result.OK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// activeTargets separa los peers activos de los inactivos, que se informan sin contactarlos
+func activeTargets(peerManager *PeerManager) ([]string, []NodeResult) {
+	var peers []string
+	var skipped []NodeResult
+	for _, status := range peerManager.GetPeerStatus() {
+		if status.State != "active" {
+			skipped = append(skipped, NodeResult{Node: status.Address, Error: "peer inactivo"})
+			continue
+		}
+		peers = append(peers, status.Address)
+	}
+	return peers, skipped
+}
+
+// remoteJSON hace un GET a un peer y decodifica la respuesta JSON
+func remoteJSON(url string, target interface{}) error {
+	status, body, err := getBody(url, clusterTimeout)
+	if err != nil {
+		return err
+	}
+	if status != fasthttp.StatusOK {
+		return fmt.Errorf("código de estado %d", status)
+	}
+	return json.Unmarshal(body, target)
+}
+
+// ListCluster devuelve las claves de todos los nodos, fusionadas por versión
+func ListCluster(peerManager *PeerManager, cache *internal.Cache, truncateValue bool) ClusterList {
+	var mu sync.Mutex
+	merged := make(map[string]*ClusterEntry)
+
+	merge := func(node string, items []internal.CacheEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range items {
+			entry, ok := merged[item.Key]
+			if !ok {
+				merged[item.Key] = &ClusterEntry{CacheEntry: item, Nodes: []string{node}}
+				continue
+			}
+			if item.Version != entry.Version {
+				entry.Divergent = true
+			}
+			if item.Version > entry.Version {
+				entry.CacheEntry = item
+			}
+			entry.Nodes = append(entry.Nodes, node)
+		}
+	}
+
+	allValue := "true"
+	if truncateValue {
+		allValue = "false"
+	}
+
+	peers, skipped := activeTargets(peerManager)
+	report := fanOut(peers, skipped,
+		func(result *NodeResult) {
+			items := cache.GetAll(truncateValue)
+			result.Keys = len(items)
+			merge(result.Node, items)
+		},
+		func(peer string, result *NodeResult) error {
+			var items []internal.CacheEntry
+			if err := remoteJSON(peer+"/list?allValue="+allValue, &items); err != nil {
+				return err
+			}
+			result.Keys = len(items)
+			merge(peer, items)
+			return nil
+		})
+
+	list := ClusterList{ClusterReport: report, Items: make([]ClusterEntry, 0, len(merged))}
+	for _, entry := range merged {
+		sort.Strings(entry.Nodes)
+		list.Items = append(list.Items, *entry)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Key < list.Items[j].Key })
+	return list
+}
+
+// KeysCluster devuelve la unión de las claves que contienen el patrón en todos los nodos
+func KeysCluster(peerManager *PeerManager, cache *internal.Cache, pattern string) ClusterKeys {
+	var mu sync.Mutex
+	union := make(map[string]struct{})
+
+	merge := func(keys []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			union[key] = struct{}{}
+		}
+	}
+
+	peers, skipped := activeTargets(peerManager)
+	report := fanOut(peers, skipped,
+		func(result *NodeResult) {
+			keys := cache.Keys(pattern)
+			result.Keys = len(keys)
+			merge(keys)
+		},
+		func(peer string, result *NodeResult) error {
+			var keys []string
+			if err := remoteJSON(peer+"/keys?pattern="+url.QueryEscape(pattern), &keys); err != nil {
+				return err
+			}
+			result.Keys = len(keys)
+			merge(keys)
+			return nil
+		})
+
+	result := ClusterKeys{ClusterReport: report, Keys: make([]string, 0, len(union))}
+	for key := range union {
+		result.Keys = append(result.Keys, key)
+	}
+	sort.Strings(result.Keys)
+	return result
+}
+
+// StatsCluster recoge las estadísticas de todos los nodos
+func StatsCluster(peerManager *PeerManager, cache *internal.Cache) ClusterStats {
+	peers, skipped := activeTargets(peerManager)
+	report := fanOut(peers, skipped,
+		func(result *NodeResult) {
+			stats := LocalStats(peerManager, cache)
+			result.Stats = &stats
+			result.Keys = stats.Keys
+		},
+		func(peer string, result *NodeResult) error {
+			var stats NodeStats
+			if err := remoteJSON(peer+"/stats", &stats); err != nil {
+				return err
+			}
+			result.Stats = &stats
+			result.Keys = stats.Keys
+			return nil
+		})
+
+	result := ClusterStats{ClusterReport: report, MinKeys: -1}
+	for _, node := range report.Nodes {
+		if !node.OK {
+			continue
+		}
+		result.TotalKeys += node.Keys
+		if node.Keys > result.MaxKeys {
+			result.MaxKeys = node.Keys
+		}
+		if result.MinKeys < 0 || node.Keys < result.MinKeys {
+			result.MinKeys = node.Keys
+		}
+	}
+	return result
+}
+
+// FlushCluster vacía la caché local y la de cada peer, esperando la confirmación de
+// cada uno. Los peers caídos o con hints pendientes reciben el flush como hint
+func FlushCluster(peerManager *PeerManager, cache *internal.Cache) ClusterReport {
+	msg := SyncMessage{Action: "flush", Protocol: ProtocolVersion, SentAt: nowMillis()}
+	peerManager.Regions().Enqueue(msg)
+	data, _ := json.Marshal(msg)
+
+	peers, hinted := peerManager.syncTargets()
+	var skipped []NodeResult
+	for _, peer := range hinted {
+		peerManager.Hints().Store(peer, msg)
+		skipped = append(skipped, NodeResult{Node: peer, Error: "peer inactivo, se aplicará al volver"})
+	}
+
+	// Sin hinted handoff los inactivos no aparecen en syncTargets
+	if peerManager.Hints() == nil {
+		_, skipped = activeTargets(peerManager)
+	}
+
+	return fanOut(peers, skipped,
+		func(result *NodeResult) {
+			cache.FlushAll()
+		},
+		func(peer string, result *NodeResult) error {
+			_, err := syncWith(peerManager, peer, msg, data, clusterTimeout)
+			return err
+		})
+}
+
+func durationMs(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
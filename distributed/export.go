@@ -0,0 +1,90 @@
+package distributed
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"phoenixcache/internal"
+	"phoenixcache/security"
+	"phoenixcache/utils"
+)
+
+// EncodeEntries serializa, comprime y, con el cifrado activo, cifra una lista de
+// entradas con el formato de /export
+func EncodeEntries(items []internal.CacheEntry) ([]byte, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	return security.Seal(utils.CompressData(data)), nil
+}
+
+// ErrPeerWithoutKey indica que, con el cifrado activo, el peer no anunció la clave
+// activa y no se le manda la caché: nunca sale en claro
+var ErrPeerWithoutKey = errors.New("el peer no tiene la clave de cifrado activa")
+
+// encodeEntriesFor codifica la exportación para un peer (ver holdsActiveKey)
+func encodeEntriesFor(peerManager *PeerManager, peer string, items []internal.CacheEntry) ([]byte, error) {
+	if !peerManager.holdsActiveKey(peer) {
+		return nil, ErrPeerWithoutKey
+	}
+	return EncodeEntries(items)
+}
+
+// DecodeEntries descifra, descomprime y parsea una exportación generada por
+// EncodeEntries. Si está cifrada con una clave desconocida no se carga
+func DecodeEntries(data []byte) ([]internal.CacheEntry, error) {
+	compressed, err := security.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := utils.DecompressData(compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []internal.CacheEntry
+	if err := json.Unmarshal(decompressed, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ApplyEntries guarda en la caché las entradas exportadas. offset es el desfase de
+// reloj con el nodo que generó la exportación. Las claves protegidas (Raft) solo
+// cambian por su log y se ignoran
+func ApplyEntries(cache *internal.Cache, entries []internal.CacheEntry, offset time.Duration) {
+	applyEntries(cache, nil, entries, offset)
+}
+
+// applyEntries es ApplyEntries. Con tracker, las claves escritas o borradas en la caché
+// desde que empezó se dejan como están
+func applyEntries(cache *internal.Cache, tracker *internal.ChangeTracker, entries []internal.CacheEntry, offset time.Duration) {
+	for _, entry := range entries {
+		if cache.IsProtected(entry.Key) {
+			continue
+		}
+
+		// Nodos con protocolo 2 mandan la expiración absoluta
+		var expiresAt time.Time
+		version := entry.Version
+		if entry.ExpiresAt > 0 {
+			expiresAt = expiryFromMillis(entry.ExpiresAt, offset)
+		} else {
+			duration, err := time.ParseDuration(entry.ExpiresIn)
+			if err != nil {
+				log.Printf("⚠️ Error al parsear duración para clave %s: %v", entry.Key, err)
+				continue
+			}
+			expiresAt, version = time.Now().Add(duration), 0
+		}
+
+		if tracker != nil {
+			cache.SetVersionedUntouched(tracker, entry.Key, entry.Value, expiresAt, version)
+		} else {
+			cache.SetVersioned(entry.Key, entry.Value, expiresAt, version)
+		}
+	}
+}
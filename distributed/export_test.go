@@ -0,0 +1,58 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestExportNeverLeavesInClear(t *testing.T) {
+	t.Setenv("PHOENIX_TEST_KEYS", "v1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	security.InitEncryption(&configuration.Config{Encryption: configuration.EncryptionConfig{Enabled: true, KeyEnv: "PHOENIX_TEST_KEYS"}})
+	defer security.InitEncryption(&configuration.Config{})
+
+	pm := &PeerManager{peers: map[string]int{"http://sincifrado:8080": 0, "http://conclave:8080": 0}, protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	pm.protocols["http://sincifrado:8080"] = PeerProtocol{Protocol: ProtocolVersion, Compatible: true}
+	pm.protocols["http://conclave:8080"] = PeerProtocol{Protocol: ProtocolVersion, Compatible: true, Features: []string{FeatureEncryption}, KeyIDs: []string{"v1"}}
+
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.Set("sesion:1", "secreto", time.Minute)
+
+	export := func(node string) *fasthttp.RequestCtx {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.Set(HeaderNode, node)
+		HandleExportCache(pm, cache, &ctx)
+		return &ctx
+	}
+
+	// Un peer sin cifrado (o alguien que se hace pasar por él) no recibe nada
+	ctx := export("http://sincifrado:8080")
+	if ctx.Response.StatusCode() != fasthttp.StatusConflict {
+		t.Fatalf("código %d para un peer sin la clave, se esperaba 409", ctx.Response.StatusCode())
+	}
+	if _, err := DecodeEntries(ctx.Response.Body()); err == nil {
+		t.Fatalf("un peer sin la clave recibió la caché: %q", ctx.Response.Body())
+	}
+	if _, err := encodeEntriesFor(pm, "http://sincifrado:8080", cache.GetReplicated()); err != ErrPeerWithoutKey {
+		t.Fatalf("/replace a un peer sin la clave: %v, se esperaba ErrPeerWithoutKey", err)
+	}
+
+	// Con la clave activa recibe la caché cifrada
+	for _, node := range []string{"http://conclave:8080", "http://desconocido:8080"} {
+		ctx = export(node)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK || !security.IsSealed(ctx.Response.Body()) {
+			t.Fatalf("exportación a %s: código %d, cifrada %v", node, ctx.Response.StatusCode(), security.IsSealed(ctx.Response.Body()))
+		}
+	}
+	entries, err := DecodeEntries(ctx.Response.Body())
+	if err != nil || len(entries) != 1 || entries[0].Value != "secreto" {
+		t.Fatalf("DecodeEntries = %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,182 @@
+package distributed
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"time"
+
+	"phoenixcache/configuration"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Modos de reenvío de las escrituras de los clientes
+const (
+	ForwardNone    = "none"    // Cada nodo atiende sus escrituras (los observers las mandan a un member)
+	ForwardPrimary = "primary" // Todas las escrituras van al nodo primario
+	ForwardOwner   = "owner"   // Cada clave tiene un nodo propietario (rendezvous hashing sobre los members activos)
+)
+
+// Cabeceras de las escrituras reenviadas a otro nodo
+const (
+	HeaderForwardedBy = "X-Phoenix-Forwarded-By" // node_id del nodo que reenvía (evita bucles)
+	HeaderForwardedTo = "X-Phoenix-Forwarded-To" // Nodo que atendió la escritura
+	HeaderOwner       = "X-Phoenix-Owner"        // Nodo al que el cliente puede escribir directamente
+)
+
+// Espera máxima de una escritura reenviada
+const forwardTimeout = 10 * time.Second
+
+var (
+	ErrNoWritablePeer     = errors.New("no hay ningún peer activo que acepte escrituras")
+	ErrPrimaryUnavailable = errors.New("el nodo primario no está activo")
+	ErrLoopbackAddress    = errors.New("advertise_address es una dirección local que los peers no conocen")
+)
+
+// Configuración del reenvío de escrituras
+var forwarding = configuration.ForwardingConfig{Mode: ForwardNone}
+
+// IsForwarded indica si la petición ya viene reenviada por otro nodo
+func IsForwarded(ctx *fasthttp.RequestCtx) bool {
+	return len(ctx.Request.Header.Peek(HeaderForwardedBy)) > 0
+}
+
+// ForwardsWrites indica si las escrituras pueden acabar en otro nodo
+func ForwardsWrites() bool {
+	return forwarding.Mode != ForwardNone || IsObserver()
+}
+
+// WriteOwner decide qué nodo debe atender una escritura. 'key' va vacía en las
+// escrituras que no son de una clave concreta (flush, borrado por patrón). Devuelve
+// la dirección del nodo, que puede ser la del propio nodo
+func WriteOwner(peerManager *PeerManager, key string) (string, error) {
+	candidates := peerManager.GetMemberPeers()
+	if !IsObserver() {
+		// Con la dirección por defecto (localhost) los peers nos conocen por otra, y
+		// cada nodo calcularía un propietario distinto para la misma clave
+		if !advertisedToPeers(candidates) {
+			return "", ErrLoopbackAddress
+		}
+		candidates = append(candidates, localAddress)
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoWritablePeer
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return nodeIdentity(candidates[i]) < nodeIdentity(candidates[j])
+	})
+
+	switch {
+	case forwarding.Mode == ForwardPrimary && forwarding.Primary != "":
+		if IsSelf(forwarding.Primary) {
+			if IsObserver() {
+				return "", ErrPrimaryUnavailable
+			}
+			return localAddress, nil
+		}
+		for _, candidate := range candidates {
+			if candidate == forwarding.Primary {
+				return candidate, nil
+			}
+		}
+		return "", ErrPrimaryUnavailable
+	case forwarding.Mode == ForwardPrimary:
+		return candidates[0], nil
+	case key == "":
+		// Sin clave no hay propietario: la atiende este nodo si acepta escrituras
+		if !IsObserver() {
+			return localAddress, nil
+		}
+		return candidates[0], nil
+	case forwarding.Mode == ForwardOwner || IsObserver():
+		return rendezvousOwner(candidates, key), nil
+	default:
+		return localAddress, nil
+	}
+}
+
+// advertisedToPeers indica si los peers pueden conocer a este nodo por su
+// advertise_address: no lo pueden si es una dirección local y alguno de ellos no lo es
+func advertisedToPeers(peers []string) bool {
+	if !isLoopbackAddress(localAddress) {
+		return true
+	}
+	for _, peer := range peers {
+		if !isLoopbackAddress(peer) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLoopbackAddress(address string) bool {
+	u, err := url.Parse(normalizeAddress(address))
+	return err == nil && isLoopback(u.Hostname())
+}
+
+// nodeIdentity es el nombre de un nodo igual en todo el cluster: la dirección
+// normalizada y, si es local, con el mismo host para localhost, 127.0.0.1 o ::1
+func nodeIdentity(address string) string {
+	normalized := normalizeAddress(address)
+	u, err := url.Parse(normalized)
+	if err != nil || !isLoopback(u.Hostname()) {
+		return normalized
+	}
+	return "localhost:" + u.Port()
+}
+
+// rendezvousOwner elige el nodo con mayor peso para la clave. Si un nodo cae, solo
+// cambian de propietario sus claves
+func rendezvousOwner(nodes []string, key string) string {
+	keyHash := fnv64(key)
+
+	var owner string
+	var best uint64
+	for _, node := range nodes {
+		if weight := mix64(keyHash ^ mix64(fnv64(nodeIdentity(node)))); owner == "" || weight > best {
+			owner, best = node, weight
+		}
+	}
+	return owner
+}
+
+func fnv64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 reparte los bits del hash (finalizador de murmur3). Sin él, nodos cuyas
+// direcciones solo difieren en el puerto se llevan casi todas las claves
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// ForwardWrite reenvía la petición de un cliente al nodo indicado y copia su respuesta,
+// añadiendo quién es el propietario para que el cliente pueda ir directo la próxima vez
+func ForwardWrite(owner string, ctx *fasthttp.RequestCtx) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	ctx.Request.CopyTo(req)
+	req.SetRequestURI(owner + string(ctx.RequestURI()))
+	req.Header.Set(HeaderForwardedBy, nodeID)
+
+	if err := fasthttp.DoTimeout(req, resp, forwardTimeout); err != nil {
+		return err
+	}
+
+	resp.CopyTo(&ctx.Response)
+	ctx.Response.Header.Set(HeaderForwardedTo, owner)
+	ctx.Response.Header.Set(HeaderOwner, owner)
+	return nil
+}
@@ -0,0 +1,34 @@
+package distributed
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteOwnerRefusesLoopbackAdvertiseAddress(t *testing.T) {
+	defer func(address string, mode string) {
+		localAddress, forwarding.Mode = address, mode
+	}(localAddress, forwarding.Mode)
+	forwarding.Mode = ForwardOwner
+
+	pm := &PeerManager{peers: map[string]int{"http://10.0.0.2:8080": 0}, protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	localAddress = "http://localhost:8080"
+	if _, err := WriteOwner(pm, "k"); !errors.Is(err, ErrLoopbackAddress) {
+		t.Fatalf("WriteOwner con advertise_address local: %v, se esperaba ErrLoopbackAddress", err)
+	}
+
+	localAddress = "http://10.0.0.1:8080"
+	if _, err := WriteOwner(pm, "k"); err != nil {
+		t.Fatalf("WriteOwner: %v", err)
+	}
+}
+
+func TestRendezvousOwnerIgnoresLoopbackAlias(t *testing.T) {
+	a := []string{"http://localhost:8080", "http://localhost:8081", "http://localhost:8082"}
+	b := []string{"http://127.0.0.1:8080", "http://LOCALHOST:8081/", "http://localhost:8082"}
+	for _, key := range []string{"a", "b", "c", "usuario:1", "usuario:2", "sesion:99"} {
+		if nodeIdentity(rendezvousOwner(a, key)) != nodeIdentity(rendezvousOwner(b, key)) {
+			t.Fatalf("la clave %q tiene propietarios distintos según el alias de localhost", key)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formato binario de los lotes de /sync_bin:
+//
+//	"PXS1" | nº de mensajes (uvarint) | mensajes
+//
+// y cada mensaje:
+//
+//	acción (1 byte) | clave | valor | expires_at (varint) | sent_at (varint) | versión (uvarint) | origen
+//
+// donde las cadenas van precedidas de su longitud (uvarint). Las confirmaciones
+// vuelven como "PXA1" | nº (uvarint) | por cada una: aplicado (1 byte) | versión (uvarint)
+const (
+	ContentTypeSyncFrame = "application/x-phoenix-sync"
+
+	frameMagic = "PXS1"
+	ackMagic   = "PXA1"
+)
+
+var actionCodes = map[string]byte{"set": 1, "remove": 2, "removePattern": 3, "flush": 4}
+var actionNames = map[byte]string{1: "set", 2: "remove", 3: "removePattern", 4: "flush"}
+
+var errInvalidFrame = errors.New("trama binaria no válida")
+
+// EncodeFrame serializa un lote de mensajes en formato binario
+func EncodeFrame(msgs []SyncMessage) []byte {
+	buf := make([]byte, 0, 64*len(msgs)+8)
+	buf = append(buf, frameMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(msgs)))
+
+	for _, msg := range msgs {
+		buf = append(buf, actionCodes[msg.Action])
+		buf = appendString(buf, msg.Key)
+		buf = appendString(buf, valueString(msg.Value))
+		buf = binary.AppendVarint(buf, msg.ExpiresAt)
+		buf = binary.AppendVarint(buf, msg.SentAt)
+		buf = binary.AppendUvarint(buf, msg.Version)
+		buf = appendString(buf, msg.Origin)
+	}
+	return buf
+}
+
+// DecodeFrame parsea un lote generado por EncodeFrame
+func DecodeFrame(data []byte) ([]SyncMessage, error) {
+	if !bytes.HasPrefix(data, []byte(frameMagic)) {
+		return nil, errInvalidFrame
+	}
+	r := bytes.NewReader(data[len(frameMagic):])
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(len(data)) {
+		return nil, errInvalidFrame
+	}
+
+	msgs := make([]SyncMessage, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var msg SyncMessage
+
+		code, err := r.ReadByte()
+		if err != nil {
+			return nil, errInvalidFrame
+		}
+		action, ok := actionNames[code]
+		if !ok {
+			return nil, fmt.Errorf("acción desconocida %d", code)
+		}
+		msg.Action = action
+
+		if msg.Key, err = readString(r); err != nil {
+			return nil, err
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if action == "set" {
+			msg.Value = value
+		}
+		if msg.ExpiresAt, err = binary.ReadVarint(r); err != nil {
+			return nil, errInvalidFrame
+		}
+		if msg.SentAt, err = binary.ReadVarint(r); err != nil {
+			return nil, errInvalidFrame
+		}
+		if msg.Version, err = binary.ReadUvarint(r); err != nil {
+			return nil, errInvalidFrame
+		}
+		if msg.Origin, err = readString(r); err != nil {
+			return nil, err
+		}
+
+		msg.Protocol = ProtocolVersion
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// EncodeAcks serializa las confirmaciones de un lote
+func EncodeAcks(acks []SyncAck) []byte {
+	buf := make([]byte, 0, 10*len(acks)+8)
+	buf = append(buf, ackMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(acks)))
+	for _, ack := range acks {
+		applied := byte(0)
+		if ack.Applied {
+			applied = 1
+		}
+		buf = append(buf, applied)
+		buf = binary.AppendUvarint(buf, ack.Version)
+	}
+	return buf
+}
+
+// DecodeAcks parsea las confirmaciones generadas por EncodeAcks
+func DecodeAcks(data []byte) ([]SyncAck, error) {
+	if !bytes.HasPrefix(data, []byte(ackMagic)) {
+		return nil, errInvalidFrame
+	}
+	r := bytes.NewReader(data[len(ackMagic):])
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(len(data)) {
+		return nil, errInvalidFrame
+	}
+
+	acks := make([]SyncAck, 0, count)
+	for i := uint64(0); i < count; i++ {
+		applied, err := r.ReadByte()
+		if err != nil {
+			return nil, errInvalidFrame
+		}
+		version, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errInvalidFrame
+		}
+		acks = append(acks, SyncAck{Status: "ok", Applied: applied == 1, Version: version})
+	}
+	return acks, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", errInvalidFrame
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errInvalidFrame
+	}
+	return string(b), nil
+}
+
+// valueString convierte el valor de un mensaje a cadena (los valores de la caché son cadenas)
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
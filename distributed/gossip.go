@@ -0,0 +1,484 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Estados de un miembro del cluster
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+)
+
+// Member es la vista que un nodo tiene de otro miembro del cluster
+type Member struct {
+	Address     string `json:"address"`
+	State       string `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+}
+
+// Mensaje que se intercambia en join, ping y ping-req. La lista de miembros viaja
+// siempre en el mensaje (piggyback) para diseminar los cambios de membresía
+type gossipMessage struct {
+	From    string   `json:"from"`
+	Target  string   `json:"target,omitempty"`
+	Members []Member `json:"members"`
+}
+
+type memberInfo struct {
+	Member
+	stateSince time.Time
+}
+
+// Gossip implementa un protocolo de membresía estilo SWIM sobre HTTP
+type Gossip struct {
+	self           string
+	seed           string
+	members        map[string]*memberInfo
+	mu             sync.Mutex
+	peerManager    *PeerManager
+	probeInterval  time.Duration
+	probeTimeout   time.Duration
+	suspectTimeout time.Duration
+	indirectProbes int
+	probeOrder     []string
+}
+
+// NewGossip crea el protocolo de membresía y lo asocia al PeerManager
+func NewGossip(config *configuration.Config, peerManager *PeerManager) *Gossip {
+	g := &Gossip{
+		self:           config.AdvertiseAddress,
+		seed:           config.Gossip.Seed,
+		members:        make(map[string]*memberInfo),
+		peerManager:    peerManager,
+		probeInterval:  time.Duration(config.Gossip.ProbeInterval) * time.Millisecond,
+		probeTimeout:   time.Duration(config.Gossip.ProbeTimeout) * time.Millisecond,
+		suspectTimeout: time.Duration(config.Gossip.SuspectTimeout) * time.Second,
+		indirectProbes: config.Gossip.IndirectProbes,
+	}
+
+	// Los demás nodos sondean la dirección que anunciamos: una local solo la alcanzan
+	// los que corren en la misma máquina
+	if isLoopbackAddress(g.self) {
+		for _, peer := range append([]string{g.seed}, config.Peers...) {
+			if peer != "" && !isLoopbackAddress(peer) {
+				log.Printf("⚠️ advertise_address es %s: el gossip la anuncia y los demás nodos no la alcanzarán", g.self)
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	g.members[g.self] = &memberInfo{Member: Member{Address: g.self, State: MemberAlive}, stateSince: now}
+
+	// Los peers estáticos se consideran miembros iniciales
+	for _, peer := range config.Peers {
+		if peer == g.self {
+			continue
+		}
+		g.members[peer] = &memberInfo{Member: Member{Address: peer, State: MemberAlive}, stateSince: now}
+	}
+
+	peerManager.gossip = g
+	return g
+}
+
+// Start se une al cluster a través de la semilla e inicia el ciclo de sondeo
+func (g *Gossip) Start() {
+	if g.seed != "" && g.seed != g.self {
+		if err := g.join(g.seed); err != nil {
+			log.Printf("⚠️ No se pudo unir al cluster a través de %s: %v", g.seed, err)
+		}
+	}
+
+	go g.probeLoop()
+}
+
+// Members devuelve la lista de miembros conocidos ordenada por dirección
+func (g *Gossip) Members() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.snapshot()
+}
+
+func (g *Gossip) snapshot() []Member {
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Address < members[j].Address })
+	return members
+}
+
+func (g *Gossip) join(seed string) error {
+	msg := gossipMessage{From: g.self, Members: g.Members()}
+
+	status, body, err := postJSON(seed+"/gossip/join", msg, g.probeTimeout*4)
+	if err != nil {
+		return err
+	}
+	if status != fasthttp.StatusOK {
+		return fmt.Errorf("código de estado %d", status)
+	}
+
+	var reply gossipMessage
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return err
+	}
+	g.merge(reply.Members)
+
+	log.Printf("🤝 Unido al cluster a través de %s (%d miembros)", seed, len(reply.Members))
+	return nil
+}
+
+// probeLoop sondea un miembro en cada intervalo y revisa los sospechosos
+func (g *Gossip) probeLoop() {
+	for {
+		g.probe()
+		g.reapSuspects()
+		time.Sleep(g.probeInterval)
+	}
+}
+
+// probe hace un ping directo a un miembro y, si falla, pide a otros k miembros
+// que lo sondeen (ping-req) antes de marcarlo como sospechoso
+func (g *Gossip) probe() {
+	target := g.nextTarget()
+	if target == "" {
+		return
+	}
+
+	if g.ping(target) {
+		return
+	}
+
+	helpers := g.randomMembers(g.indirectProbes, target)
+	acks := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper string) {
+			acks <- g.pingReq(helper, target)
+		}(helper)
+	}
+
+	for range helpers {
+		if <-acks {
+			return
+		}
+	}
+
+	g.suspect(target)
+}
+
+// nextTarget devuelve el siguiente miembro a sondear, recorriendo la lista en orden aleatorio
+func (g *Gossip) nextTarget() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.probeOrder) > 0 {
+		target := g.probeOrder[0]
+		g.probeOrder = g.probeOrder[1:]
+		if m, ok := g.members[target]; ok && m.State != MemberDead {
+			return target
+		}
+	}
+
+	for addr, m := range g.members {
+		if addr != g.self && m.State != MemberDead {
+			g.probeOrder = append(g.probeOrder, addr)
+		}
+	}
+	if len(g.probeOrder) == 0 {
+		return ""
+	}
+	rand.Shuffle(len(g.probeOrder), func(i, j int) {
+		g.probeOrder[i], g.probeOrder[j] = g.probeOrder[j], g.probeOrder[i]
+	})
+
+	target := g.probeOrder[0]
+	g.probeOrder = g.probeOrder[1:]
+	return target
+}
+
+// randomMembers elige hasta n miembros vivos distintos del propio nodo y de exclude
+func (g *Gossip) randomMembers(n int, exclude string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var candidates []string
+	for addr, m := range g.members {
+		if addr != g.self && addr != exclude && m.State == MemberAlive {
+			candidates = append(candidates, addr)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// ping envía un ping directo con la lista de miembros y fusiona la respuesta
+func (g *Gossip) ping(target string) bool {
+	msg := gossipMessage{From: g.self, Members: g.Members()}
+
+	status, body, err := postJSON(target+"/gossip/ping", msg, g.probeTimeout)
+	if err != nil || status != fasthttp.StatusOK {
+		return false
+	}
+
+	var reply gossipMessage
+	if err := json.Unmarshal(body, &reply); err == nil {
+		g.merge(reply.Members)
+	}
+	return true
+}
+
+// pingReq pide a helper que sondee target en nuestro nombre
+func (g *Gossip) pingReq(helper, target string) bool {
+	msg := gossipMessage{From: g.self, Target: target, Members: g.Members()}
+
+	status, _, err := postJSON(helper+"/gossip/ping-req", msg, g.probeTimeout*2)
+	return err == nil && status == fasthttp.StatusOK
+}
+
+// suspect marca un miembro vivo como sospechoso
+func (g *Gossip) suspect(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m, ok := g.members[addr]
+	if !ok || m.State != MemberAlive {
+		return
+	}
+	m.State = MemberSuspect
+	m.stateSince = time.Now()
+	log.Printf("❓ Nodo %s sospechoso de estar caído", addr)
+}
+
+// reapSuspects declara muertos a los sospechosos que no se han refutado a tiempo
+func (g *Gossip) reapSuspects() {
+	var dead []string
+
+	g.mu.Lock()
+	for addr, m := range g.members {
+		if m.State == MemberSuspect && time.Since(m.stateSince) > g.suspectTimeout {
+			m.State = MemberDead
+			m.stateSince = time.Now()
+			dead = append(dead, addr)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, addr := range dead {
+		log.Printf("💀 Nodo %s declarado caído", addr)
+		g.applyState(addr, MemberDead)
+	}
+}
+
+// merge fusiona la vista remota de la membresía con la local siguiendo las reglas
+// de SWIM: gana la encarnación más alta y, a igual encarnación, dead > suspect > alive
+func (g *Gossip) merge(remote []Member) {
+	changed := make(map[string]string)
+
+	g.mu.Lock()
+	for _, m := range remote {
+		if m.Address == "" {
+			continue
+		}
+
+		if m.Address == g.self {
+			// Refutamos cualquier sospecha sobre nosotros mismos
+			self := g.members[g.self]
+			if m.State != MemberAlive && m.Incarnation >= self.Incarnation {
+				self.Incarnation = m.Incarnation + 1
+				log.Printf("🙋 Refutando estado %s (encarnación %d)", m.State, self.Incarnation)
+			}
+			continue
+		}
+
+		local, exists := g.members[m.Address]
+		if !exists {
+			if !isAllowedMember(m.Address) {
+				log.Printf("⛔ Miembro %s no permitido por la lista blanca", m.Address)
+				continue
+			}
+			g.members[m.Address] = &memberInfo{Member: m, stateSince: time.Now()}
+			changed[m.Address] = m.State
+			continue
+		}
+
+		if overrides(m, local.Member) {
+			if local.State != m.State {
+				changed[m.Address] = m.State
+				local.stateSince = time.Now()
+			}
+			local.Member = m
+		}
+	}
+	g.mu.Unlock()
+
+	for addr, state := range changed {
+		g.applyState(addr, state)
+	}
+}
+
+// overrides indica si la información recibida sustituye a la local
+func overrides(remote, local Member) bool {
+	switch remote.State {
+	case MemberAlive:
+		return remote.Incarnation > local.Incarnation
+	case MemberSuspect:
+		return remote.Incarnation > local.Incarnation ||
+			(remote.Incarnation == local.Incarnation && local.State == MemberAlive)
+	case MemberDead:
+		return remote.Incarnation > local.Incarnation ||
+			(remote.Incarnation == local.Incarnation && local.State != MemberDead)
+	}
+	return false
+}
+
+// applyState traslada los cambios de membresía al PeerManager
+func (g *Gossip) applyState(addr, state string) {
+	switch state {
+	case MemberAlive:
+		if g.peerManager.AddPeer(addr) {
+			log.Printf("➕ Nodo %s añadido al cluster", addr)
+		}
+	case MemberDead:
+		if g.peerManager.RemovePeer(addr) {
+			log.Printf("➖ Nodo %s eliminado del cluster", addr)
+		}
+	}
+}
+
+// isAllowedMember comprueba la dirección anunciada contra la lista blanca
+func isAllowedMember(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return security.IsAllowedNode(address)
+	}
+	return security.IsAllowedNode(u.Host) || security.IsAllowedNode(u.Hostname())
+}
+
+//********************************************************************
+// Handlers del protocolo de gossip
+//********************************************************************
+
+// HandleGossipJoin da de alta a un nodo que se une a través de este
+func HandleGossipJoin(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	g := peerManager.Gossip()
+	if g == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	var msg gossipMessage
+	if err := json.Unmarshal(ctx.PostBody(), &msg); err != nil || msg.From == "" {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if !isAllowedMember(msg.From) {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBody([]byte("⛔ Acceso denegado"))
+		return
+	}
+
+	// El alta explícita gana siempre a lo que supiéramos del nodo
+	g.mu.Lock()
+	incarnation := uint64(0)
+	for _, m := range msg.Members {
+		if m.Address == msg.From {
+			incarnation = m.Incarnation
+		}
+	}
+	if local, exists := g.members[msg.From]; exists && local.Incarnation >= incarnation {
+		incarnation = local.Incarnation + 1
+	}
+	g.members[msg.From] = &memberInfo{
+		Member:     Member{Address: msg.From, State: MemberAlive, Incarnation: incarnation},
+		stateSince: time.Now(),
+	}
+	g.mu.Unlock()
+
+	g.applyState(msg.From, MemberAlive)
+	g.merge(msg.Members)
+
+	writeGossipReply(g, ctx)
+}
+
+// HandleGossipPing responde a un sondeo directo
+func HandleGossipPing(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	g := peerManager.Gossip()
+	if g == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	var msg gossipMessage
+	if err := json.Unmarshal(ctx.PostBody(), &msg); err != nil {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+	g.merge(msg.Members)
+
+	writeGossipReply(g, ctx)
+}
+
+// HandleGossipPingReq sondea un nodo en nombre de otro (sondeo indirecto)
+func HandleGossipPingReq(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	g := peerManager.Gossip()
+	if g == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	var msg gossipMessage
+	if err := json.Unmarshal(ctx.PostBody(), &msg); err != nil || msg.Target == "" {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+	g.merge(msg.Members)
+
+	if !g.ping(msg.Target) {
+		ctx.SetStatusCode(fasthttp.StatusGatewayTimeout)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleGossipMembers devuelve la vista de la membresía de este nodo
+func HandleGossipMembers(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	g := peerManager.Gossip()
+	if g == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(g.Members())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+func writeGossipReply(g *Gossip, ctx *fasthttp.RequestCtx) {
+	data, _ := json.Marshal(gossipMessage{From: g.self, Members: g.Members()})
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,84 @@
+package distributed
+
+import (
+	"testing"
+	"time"
+)
+
+// testGossip crea un gossip sin sondeos con los miembros indicados vivos
+func testGossip(self string, members ...string) *Gossip {
+	pm := &PeerManager{peers: make(map[string]int), protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	g := &Gossip{self: self, members: make(map[string]*memberInfo), peerManager: pm, suspectTimeout: time.Hour}
+	g.members[self] = &memberInfo{Member: Member{Address: self, State: MemberAlive}, stateSince: time.Now()}
+	for _, addr := range members {
+		g.members[addr] = &memberInfo{Member: Member{Address: addr, State: MemberAlive}, stateSince: time.Now()}
+		pm.peers[addr] = 0
+	}
+	pm.gossip = g
+	return g
+}
+
+func memberState(g *Gossip, addr string) Member {
+	for _, m := range g.Members() {
+		if m.Address == addr {
+			return m
+		}
+	}
+	return Member{}
+}
+
+func TestSuspectBecomesDeadAfterTimeout(t *testing.T) {
+	g := testGossip("http://a:8080", "http://b:8080")
+
+	g.suspect("http://b:8080")
+	g.reapSuspects()
+	if state := memberState(g, "http://b:8080").State; state != MemberSuspect {
+		t.Fatalf("estado = %s antes del timeout, se esperaba suspect", state)
+	}
+
+	g.mu.Lock()
+	g.members["http://b:8080"].stateSince = time.Now().Add(-2 * time.Hour)
+	g.mu.Unlock()
+	g.reapSuspects()
+	if state := memberState(g, "http://b:8080").State; state != MemberDead {
+		t.Fatalf("estado = %s tras el timeout, se esperaba dead", state)
+	}
+	if len(g.peerManager.GetPeers()) != 0 {
+		t.Fatalf("el nodo caído sigue en los peers: %v", g.peerManager.GetPeers())
+	}
+
+	// Un muerto no vuelve a sospecharse, pero sí vuelve con una encarnación mayor
+	g.suspect("http://b:8080")
+	if state := memberState(g, "http://b:8080").State; state != MemberDead {
+		t.Fatalf("estado = %s, un nodo caído no pasa a suspect", state)
+	}
+	g.merge([]Member{{Address: "http://b:8080", State: MemberAlive, Incarnation: 1}})
+	if state := memberState(g, "http://b:8080").State; state != MemberAlive {
+		t.Fatalf("estado = %s, se esperaba alive con la encarnación nueva", state)
+	}
+	if len(g.peerManager.GetPeers()) != 1 {
+		t.Fatalf("el nodo que volvió no está en los peers: %v", g.peerManager.GetPeers())
+	}
+}
+
+func TestIncarnationRefutesSuspicion(t *testing.T) {
+	g := testGossip("http://a:8080", "http://b:8080")
+
+	// Otro nodo nos cree sospechosos: subimos la encarnación y seguimos vivos
+	g.merge([]Member{{Address: "http://a:8080", State: MemberSuspect, Incarnation: 3}})
+	self := memberState(g, "http://a:8080")
+	if self.State != MemberAlive || self.Incarnation != 4 {
+		t.Fatalf("propio = %+v, se esperaba alive con encarnación 4", self)
+	}
+
+	// La refutación de b (encarnación mayor) gana a la sospecha local
+	g.suspect("http://b:8080")
+	g.merge([]Member{{Address: "http://b:8080", State: MemberAlive, Incarnation: 0}})
+	if state := memberState(g, "http://b:8080").State; state != MemberSuspect {
+		t.Fatalf("estado = %s, alive con la misma encarnación no refuta", state)
+	}
+	g.merge([]Member{{Address: "http://b:8080", State: MemberAlive, Incarnation: 1}})
+	if state := memberState(g, "http://b:8080").State; state != MemberAlive {
+		t.Fatalf("estado = %s, la encarnación mayor debía refutar la sospecha", state)
+	}
+}
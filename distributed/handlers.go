@@ -2,8 +2,8 @@ package distributed
 
 import (
 	"encoding/json"
+	"errors"
 	"phoenixcache/internal"
-	"phoenixcache/utils"
 
 	"github.com/valyala/fasthttp"
 )
@@ -16,41 +16,91 @@ func SyncHandler(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	ack := ApplySyncMessage(cache, msg, requestPeer(ctx))
+
+	data, _ := json.Marshal(ack)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+// HandleSyncBinary recibe un lote de cambios en formato binario por una conexión
+// persistente y devuelve las confirmaciones en el mismo orden
+func HandleSyncBinary(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	msgs, err := DecodeFrame(ctx.PostBody())
+	if err != nil {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+
+	peer := requestPeer(ctx)
+	acks := make([]SyncAck, len(msgs))
+	for i, msg := range msgs {
+		acks[i] = ApplySyncMessage(cache, msg, peer)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(ContentTypeSyncFrame)
+	ctx.SetBody(EncodeAcks(acks))
+}
+
+// ApplySyncMessage aplica en la caché local un cambio recibido de otro nodo
+func ApplySyncMessage(cache *internal.Cache, msg SyncMessage, peer string) SyncAck {
+	ack := SyncAck{Status: "ok", Applied: true}
+
+	// Las claves de Raft solo cambian por su log
+	if (msg.Action == "set" || msg.Action == "remove") && cache.IsProtected(msg.Key) {
+		ack.Applied = false
+		return ack
+	}
+
 	switch msg.Action {
 	case "set":
-		cache.Set(msg.Key, msg.Value, msg.TTL)
+		ack.Applied = cache.SetVersioned(msg.Key, msg.Value, msg.Expiry(peer), msg.Version)
+		if ack.Applied {
+			cache.SetLocal(msg.Key, false)
+		}
+		ack.Version = cache.Version(msg.Key)
+	// Las claves solo locales de este nodo no son del espacio compartido: los borrados
+	// de otros nodos no las tocan
 	case "remove":
-		cache.RemoveKey(msg.Key)
+		if !cache.IsLocal(msg.Key) {
+			cache.RemoveKey(msg.Key)
+		}
 	case "removePattern":
-		cache.RemovePatternKey(msg.Key)
+		cache.RemovePatternReplicated(msg.Key)
 	case "flush":
-		cache.FlushAll()
+		cache.FlushReplicated()
 	}
-	ctx.Response.Header.Set("Connection", "close")
-	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetBodyString("Ok")
+	return ack
 }
 
 // Handle encargado de exportar la cache para recuperarla en otro servidor
-func HandleExportCache(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+func HandleExportCache(peerManager *PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 	internal.CacheMutex.Lock()
 	defer internal.CacheMutex.Unlock()
 
-	items := cache.GetAll(false)
+	// Las claves solo locales no salen del nodo
+	items := cache.GetReplicated()
 	if items == nil {
 		ctx.SetStatusCode(fasthttp.StatusNoContent)
 		return
 	}
 
-	data, err := json.Marshal(items)
-
+	compressed, err := encodeEntriesFor(peerManager, requestPeer(ctx), items)
+	if errors.Is(err, ErrPeerWithoutKey) {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.SetContentType("application/json")
+		ctx.SetBody([]byte(`{"error": "❌ El peer no tiene la clave de cifrado activa: no se exporta la caché"}`))
+		return
+	}
 	if err != nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.SetBody([]byte(`{"error": "Error exportando cache"}`))
 		return
 	}
 
-	compressed := utils.CompressData(data)
+	SetProtocolHeaders(ctx)
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetContentType("application/octet-stream")
 	ctx.SetBody(compressed)
@@ -69,13 +119,21 @@ func HandleDiff(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	SetProtocolHeaders(ctx)
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetBody(data)
 }
 
-// HandlePing es el handler para /ping
-func HandlePing(ctx *fasthttp.RequestCtx) {
+// HandlePing es el handler para /ping. Siempre responde 200 mientras el nodo esté
+// vivo; el estado del cluster visto desde el nodo va en la cabecera y en el cuerpo
+func HandlePing(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	state := peerManager.Partition().State()
+
+	SetProtocolHeaders(ctx)
+	ctx.Response.Header.Set(HeaderClusterState, state)
 	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody([]byte(`{"status": "ok", "cluster": "` + state + `"}`))
 }
 
 func HandleSetBatch(peerManager *PeerManager, cache *internal.Cache) {
@@ -0,0 +1,37 @@
+package distributed
+
+import (
+	"testing"
+	"time"
+
+	"phoenixcache/internal"
+)
+
+func TestRemoteRemovesKeepLocalOnlyKeys(t *testing.T) {
+	cache := internal.NewCache(1000, 1<<20, 64)
+	for _, key := range []string{"user:1", "user:2", "user:local"} {
+		cache.Set(key, "v", time.Minute)
+	}
+	cache.SetLocal("user:local", true)
+
+	ApplySyncMessage(cache, SyncMessage{Action: "remove", Key: "user:local"}, "http://peer:8080")
+	if _, ok := cache.Get("user:local"); !ok {
+		t.Fatalf("el remove de un peer borró una clave solo local")
+	}
+
+	ApplySyncMessage(cache, SyncMessage{Action: "removePattern", Key: "user:"}, "http://peer:8080")
+	if _, ok := cache.Get("user:local"); !ok {
+		t.Fatalf("el removePattern de un peer borró una clave solo local")
+	}
+	for _, key := range []string{"user:1", "user:2"} {
+		if _, ok := cache.Get(key); ok {
+			t.Fatalf("el removePattern de un peer no borró %s", key)
+		}
+	}
+
+	cache.SetLocal("user:local", false)
+	ApplySyncMessage(cache, SyncMessage{Action: "remove", Key: "user:local"}, "http://peer:8080")
+	if _, ok := cache.Get("user:local"); ok {
+		t.Fatalf("el remove de un peer no borró la clave ya replicada")
+	}
+}
@@ -0,0 +1,315 @@
+package distributed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"phoenixcache/internal"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// MinProtocolVersion es la versión más antigua del protocolo que este nodo sigue
+// entendiendo. Los peers por debajo se excluyen de la replicación
+const MinProtocolVersion = 1
+
+// Funcionalidades que un nodo puede anunciar en /hello
+const (
+	FeatureSyncBinary     = "sync_bin"        // Lotes binarios por /sync_bin
+	FeatureAbsoluteExpiry = "absolute_expiry" // ExpiresAt y SentAt en /sync y /export
+	FeatureVersions       = "versions"        // Versión por clave (last write wins)
+	FeatureEncryption     = "encryption"      // /export y /replace cifrados si el peer tiene la clave activa
+)
+
+// Espera máxima del saludo con un peer
+const helloTimeout = 2 * time.Second
+
+var errInvalidHello = errors.New("respuesta de /hello no válida")
+
+// Hello es lo que intercambian dos nodos en /hello para acordar el protocolo
+type Hello struct {
+	NodeID      string   `json:"node_id"`
+	Address     string   `json:"address,omitempty"`
+	Role        string   `json:"role,omitempty"` // member u observer (vacío en nodos antiguos)
+	Protocol    int      `json:"protocol"`
+	MinProtocol int      `json:"min_protocol"`
+	Features    []string `json:"features"`
+	KeyIDs      []string `json:"key_ids,omitempty"` // ID de las claves de cifrado cargadas
+}
+
+// PeerProtocol es el resultado de la negociación con un peer: la versión más alta
+// que entienden los dos y las funcionalidades comunes
+type PeerProtocol struct {
+	Protocol   int      `json:"protocol"`
+	Features   []string `json:"features"`
+	Compatible bool     `json:"compatible"`
+	Role       string   `json:"role"`
+	KeyIDs     []string `json:"key_ids,omitempty"` // Claves de cifrado del peer (con 'encryption')
+	Legacy     bool     `json:"legacy,omitempty"`  // Nodo sin /hello: se deduce de /ping
+	Error      string   `json:"error,omitempty"`
+	CheckedAt  string   `json:"checked_at"`
+}
+
+// Supports indica si el peer admite una funcionalidad
+func (p PeerProtocol) Supports(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// localHello devuelve el saludo de este nodo
+func localHello() Hello {
+	hello := Hello{
+		NodeID:      nodeID,
+		Address:     localAddress,
+		Role:        localRole,
+		Protocol:    ProtocolVersion,
+		MinProtocol: MinProtocolVersion,
+		Features:    []string{FeatureSyncBinary, FeatureAbsoluteExpiry, FeatureVersions},
+	}
+	if security.EncryptionEnabled() {
+		hello.Features = append(hello.Features, FeatureEncryption)
+		hello.KeyIDs = security.KeyIDs()
+	}
+	return hello
+}
+
+// negotiate acuerda el protocolo entre dos nodos: la versión más alta común y la
+// intersección de funcionalidades. Son incompatibles si la versión común queda por
+// debajo del mínimo de alguno de los dos
+func negotiate(local Hello, remote Hello) PeerProtocol {
+	result := PeerProtocol{
+		Protocol:  min(local.Protocol, remote.Protocol),
+		Role:      RoleMember,
+		CheckedAt: time.Now().Format(time.RFC3339),
+	}
+	if remote.Role != "" {
+		result.Role = remote.Role
+	}
+
+	if result.Protocol < max(local.MinProtocol, remote.MinProtocol) {
+		result.Error = fmt.Sprintf("protocolo %d del peer (mínimo %d) sin versión común con %d (mínimo %d)",
+			remote.Protocol, remote.MinProtocol, local.Protocol, local.MinProtocol)
+		result.Protocol = 0
+		return result
+	}
+
+	result.Compatible = true
+	remoteFeatures := make(map[string]bool, len(remote.Features))
+	for _, f := range remote.Features {
+		remoteFeatures[f] = true
+	}
+	for _, f := range local.Features {
+		if remoteFeatures[f] {
+			result.Features = append(result.Features, f)
+		}
+	}
+	if result.Supports(FeatureEncryption) {
+		result.KeyIDs = remote.KeyIDs
+	}
+	return result
+}
+
+// SayHello saluda a un peer y devuelve su Hello. Los nodos anteriores a /hello
+// contestan 404: su versión se deduce de la cabecera de /ping
+func SayHello(peer string, timeout time.Duration) (Hello, bool, error) {
+	status, body, err := postJSON(peer+"/hello", localHello(), timeout)
+	if err != nil {
+		return Hello{}, false, err
+	}
+
+	switch status {
+	case fasthttp.StatusOK:
+	case fasthttp.StatusNotFound:
+		remote, err := legacyHello(peer, timeout)
+		return remote, true, err
+	default:
+		return Hello{}, false, fmt.Errorf("código de estado %d", status)
+	}
+
+	var remote Hello
+	if err := json.Unmarshal(body, &remote); err != nil {
+		return Hello{}, false, fmt.Errorf("%w: %v", errInvalidHello, err)
+	}
+	if remote.Protocol <= 0 {
+		return Hello{}, false, fmt.Errorf("%w: sin versión de protocolo", errInvalidHello)
+	}
+	return remote, false, nil
+}
+
+// legacyHello construye el saludo de un nodo sin /hello. Los que no mandan la
+// versión en /ping hablan el protocolo 1
+func legacyHello(peer string, timeout time.Duration) (Hello, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(peer + "/ping")
+	if err := fasthttp.DoTimeout(req, resp, timeout); err != nil {
+		return Hello{}, err
+	}
+
+	protocol, err := strconv.Atoi(string(resp.Header.Peek(HeaderProtocol)))
+	if err != nil || protocol <= 0 {
+		protocol = 1
+	}
+
+	hello := Hello{Protocol: protocol, MinProtocol: 1}
+	if protocol >= 2 {
+		hello.Features = []string{FeatureAbsoluteExpiry, FeatureVersions}
+	}
+	return hello, nil
+}
+
+// handshake negocia el protocolo con un peer y guarda el resultado. Si el peer no
+// responde se conserva lo negociado antes
+func (pm *PeerManager) handshake(peer string) (PeerProtocol, bool) {
+	remote, legacy, err := SayHello(peer, helloTimeout)
+	if err != nil && !errors.Is(err, errInvalidHello) {
+		return PeerProtocol{}, false
+	}
+
+	var negotiated PeerProtocol
+	if err != nil {
+		negotiated = PeerProtocol{Error: err.Error(), CheckedAt: time.Now().Format(time.RFC3339)}
+	} else {
+		negotiated = negotiate(localHello(), remote)
+		negotiated.Legacy = legacy
+	}
+
+	pm.setProtocol(peer, negotiated)
+	return negotiated, true
+}
+
+// setProtocol guarda lo negociado con un peer y avisa si cambia
+func (pm *PeerManager) setProtocol(peer string, negotiated PeerProtocol) {
+	pm.mu.Lock()
+	if _, exists := pm.peers[peer]; !exists {
+		pm.mu.Unlock()
+		return
+	}
+	previous, known := pm.protocols[peer]
+	pm.protocols[peer] = negotiated
+	pm.mu.Unlock()
+
+	if known && previous.Compatible == negotiated.Compatible && previous.Protocol == negotiated.Protocol && previous.Role == negotiated.Role &&
+		strings.Join(previous.Features, ",") == strings.Join(negotiated.Features, ",") {
+		return
+	}
+
+	if !negotiated.Compatible {
+		log.Printf("🚫 %s es incompatible (%s), se excluye de la replicación", peer, negotiated.Error)
+		return
+	}
+	log.Printf("🤝 Protocolo con %s (%s): versión %d [%s]", peer, negotiated.Role, negotiated.Protocol, strings.Join(negotiated.Features, ", "))
+}
+
+// PeerProtocol devuelve lo negociado con un peer (false si aún no se ha saludado)
+func (pm *PeerManager) PeerProtocol(peer string) (PeerProtocol, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	negotiated, ok := pm.protocols[peer]
+	return negotiated, ok
+}
+
+// supports indica si se puede usar una funcionalidad con un peer. Mientras no se
+// haya saludado se asume que sí, y el transporte se adapta si el peer no la tiene
+func (pm *PeerManager) supports(peer string, feature string) bool {
+	negotiated, ok := pm.PeerProtocol(peer)
+	return !ok || negotiated.Supports(feature)
+}
+
+// holdsActiveKey indica si se puede mandar la caché a un peer (/export, /replace). Con
+// el cifrado activo, solo si anunció en /hello que tiene la clave activa. A quien no es
+// un peer saludado se le manda cifrada igualmente: sin la clave no la puede leer
+func (pm *PeerManager) holdsActiveKey(peer string) bool {
+	if !security.EncryptionEnabled() {
+		return true
+	}
+	negotiated, ok := pm.PeerProtocol(peer)
+	if !ok {
+		return true
+	}
+	if !negotiated.Supports(FeatureEncryption) {
+		return false
+	}
+	for _, id := range negotiated.KeyIDs {
+		if id == security.ActiveKeyID() {
+			return true
+		}
+	}
+	return false
+}
+
+// entryFormat es cómo se interpretan las claves que exporta un peer (/export y
+// /getKeys) según lo negociado en /hello
+type entryFormat struct {
+	absoluteExpiry bool
+	versions       bool
+}
+
+// entryFormat devuelve el formato de las claves de un peer. Sin saludo previo se
+// asume el actual; las entradas sin ExpiresAt siguen usando la duración restante
+func (pm *PeerManager) entryFormat(peer string) entryFormat {
+	if pm == nil {
+		return entryFormat{absoluteExpiry: true, versions: true}
+	}
+	return entryFormat{
+		absoluteExpiry: pm.supports(peer, FeatureAbsoluteExpiry),
+		versions:       pm.supports(peer, FeatureVersions),
+	}
+}
+
+// normalize deja en las entradas solo lo que el peer sabe generar: sin
+// absolute_expiry se usa la duración restante y sin versions cada clave recibe una
+// versión local nueva
+func (f entryFormat) normalize(entries []internal.CacheEntry) {
+	for i := range entries {
+		if !f.absoluteExpiry {
+			entries[i].ExpiresAt = 0
+		}
+		if !f.versions {
+			entries[i].Version = 0
+		}
+	}
+}
+
+// isCompatible indica si se puede replicar con un peer (se debe llamar con pm.mu bloqueado)
+func (pm *PeerManager) isCompatible(peer string) bool {
+	negotiated, ok := pm.protocols[peer]
+	return !ok || negotiated.Compatible
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleHello responde al saludo de otro nodo con el propio. Si el nodo que saluda
+// es uno de nuestros peers se guarda también lo negociado con él
+func HandleHello(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	var remote Hello
+	if err := json.Unmarshal(ctx.PostBody(), &remote); err != nil || remote.Protocol <= 0 {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if remote.Address != "" {
+		peerManager.setProtocol(remote.Address, negotiate(localHello(), remote))
+	}
+
+	data, _ := json.Marshal(localHello())
+	SetProtocolHeaders(ctx)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
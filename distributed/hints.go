@@ -0,0 +1,429 @@
+package distributed
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Número de hints que se reenvían en cada lote
+const hintReplayBatch = 500
+
+// Hint es un cambio que no se pudo entregar a un peer y que se le reenviará al volver
+type Hint struct {
+	Peer     string      `json:"peer"`
+	Message  SyncMessage `json:"message"`
+	StoredAt int64       `json:"stored_at"` // Unix ms
+	seq      uint64
+}
+
+// HintStore guarda, por peer, los cambios que se ha perdido mientras estaba caído
+// (hinted handoff). Opcionalmente los escribe en disco en un JSONL por peer
+type HintStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxHints int
+	maxAge   time.Duration
+	queues   map[string]*hintQueue
+}
+
+type hintQueue struct {
+	hints []Hint
+	seq   uint64
+
+	// complete indica que no se ha perdido ningún cambio desde que el peer dejó de
+	// responder, así que el reenvío basta y no hace falta pedir el diff
+	complete  bool
+	since     time.Time
+	stored    uint64
+	dropped   uint64
+	expired   uint64
+	replayed  uint64
+	replaying bool
+	rewrite   int // Hints descartados en memoria que siguen en el fichero
+
+	lastReplay time.Time
+	lastError  string
+}
+
+// HintStatus es el estado de los hints de un peer
+type HintStatus struct {
+	Peer          string  `json:"peer"`
+	Pending       int     `json:"pending"`
+	OldestSeconds float64 `json:"oldest_seconds"`
+	Stored        uint64  `json:"stored"`
+	Replayed      uint64  `json:"replayed"`
+	Dropped       uint64  `json:"dropped"`
+	Expired       uint64  `json:"expired"`
+	Replaying     bool    `json:"replaying"`
+	Complete      bool    `json:"complete"`
+	Since         string  `json:"since"`
+	LastReplay    string  `json:"last_replay,omitempty"`
+	LastError     string  `json:"last_error,omitempty"`
+}
+
+// NewHintStore crea el almacén de hints, carga los que hubiera en disco y lo asocia al PeerManager
+func NewHintStore(config *configuration.Config, peerManager *PeerManager) *HintStore {
+	hs := &HintStore{
+		dir:      config.HintedHandoff.Dir,
+		maxHints: config.HintedHandoff.MaxHints,
+		maxAge:   time.Duration(config.HintedHandoff.MaxAge) * time.Second,
+		queues:   make(map[string]*hintQueue),
+	}
+
+	if hs.dir != "" {
+		if err := os.MkdirAll(hs.dir, 0755); err != nil {
+			log.Printf("⚠️ No se pudo crear el directorio de hints %s: %v", hs.dir, err)
+			hs.dir = ""
+		} else {
+			hs.load()
+		}
+	}
+
+	peerManager.hints = hs
+	return hs
+}
+
+// MarkDown empieza a seguir a un peer que acaba de quedar inactivo
+func (hs *HintStore) MarkDown(peer string) {
+	if hs == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if _, ok := hs.queues[peer]; !ok {
+		hs.queues[peer] = &hintQueue{complete: true, since: time.Now()}
+	}
+}
+
+// Pending indica si el peer tiene hints por reenviar. Mientras los tenga, los
+// cambios nuevos se encolan detrás para no adelantarse a los antiguos
+func (hs *HintStore) Pending(peer string) bool {
+	if hs == nil {
+		return false
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	q, ok := hs.queues[peer]
+	return ok && len(q.hints) > 0
+}
+
+// Store guarda un cambio para un peer. Si el peer no se seguía (p.e. el primer envío
+// fallido) la cola empieza en ese momento
+func (hs *HintStore) Store(peer string, msg SyncMessage) {
+	if hs == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	q, ok := hs.queues[peer]
+	if !ok {
+		q = &hintQueue{complete: true, since: time.Now()}
+		hs.queues[peer] = q
+	}
+
+	q.seq++
+	hint := Hint{Peer: peer, Message: msg, StoredAt: nowMillis(), seq: q.seq}
+	q.hints = append(q.hints, hint)
+	q.stored++
+	hs.trim(q)
+
+	if hs.dir != "" {
+		hs.appendToDisk(peer, q, hint)
+	}
+}
+
+// Forget descarta los hints de un peer eliminado
+func (hs *HintStore) Forget(peer string) {
+	if hs == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	delete(hs.queues, peer)
+	if hs.dir != "" {
+		os.Remove(hs.path(peer))
+	}
+}
+
+// Replay reenvía en orden los hints de un peer. Devuelve true si se entregaron todos
+// y no se perdió ningún cambio mientras estaba caído (no hace falta el diff)
+func (hs *HintStore) Replay(peer string) bool {
+	if hs == nil {
+		return false
+	}
+
+	hs.mu.Lock()
+	q, ok := hs.queues[peer]
+	if !ok || q.replaying {
+		hs.mu.Unlock()
+		return false
+	}
+	q.replaying = true
+	total := len(q.hints)
+	replayedBefore := q.replayed
+	hs.mu.Unlock()
+
+	if total > 0 {
+		log.Printf("🔁 Reenviando %d hints a %s", total, peer)
+	}
+
+	for {
+		hs.mu.Lock()
+		hs.trim(q)
+		n := len(q.hints)
+		if n == 0 {
+			// Entregado todo: el peer vuelve a recibir los cambios directamente
+			complete := q.complete
+			q.replaying = false
+			q.lastReplay = time.Now()
+			q.lastError = ""
+			delete(hs.queues, peer)
+			if hs.dir != "" {
+				os.Remove(hs.path(peer))
+			}
+			hs.mu.Unlock()
+
+			if total > 0 {
+				log.Printf("✅ Hints reenviados a %s", peer)
+			}
+			return complete
+		}
+		if n > hintReplayBatch {
+			n = hintReplayBatch
+		}
+		// La hora de envío es la del reenvío: el receptor no debe ver como desfase de
+		// reloj el tiempo que el hint pasó en la cola
+		sentAt := nowMillis()
+		batch := make([]SyncMessage, n)
+		for i, hint := range q.hints[:n] {
+			batch[i] = hint.Message
+			batch[i].SentAt = sentAt
+		}
+		lastSeq := q.hints[n-1].seq
+		hs.mu.Unlock()
+
+		err := sendHints(peer, batch)
+
+		hs.mu.Lock()
+		if err != nil {
+			// El fichero se reescribe una sola vez al cortarse el reenvío, para que lo
+			// ya entregado no se vuelva a cargar (reescribirlo en cada lote sería
+			// cuadrático justo cuando el peer vuelve con muchos hints)
+			if hs.dir != "" && q.replayed > replayedBefore {
+				hs.rewriteFile(peer, q)
+			}
+			q.replaying = false
+			q.lastError = err.Error()
+			hs.mu.Unlock()
+			log.Printf("⚠️ Error reenviando hints a %s: %v", peer, err)
+			return false
+		}
+
+		// Mientras se enviaba pudieron descartarse hints de la cabeza de la cola
+		delivered := 0
+		for delivered < len(q.hints) && q.hints[delivered].seq <= lastSeq {
+			delivered++
+		}
+		q.hints = q.hints[delivered:]
+		q.replayed += uint64(n)
+		q.lastReplay = time.Now()
+		hs.mu.Unlock()
+	}
+}
+
+// Status devuelve el estado de los hints de cada peer
+func (hs *HintStore) Status() []HintStatus {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	status := make([]HintStatus, 0, len(hs.queues))
+	for peer, q := range hs.queues {
+		s := HintStatus{
+			Peer:      peer,
+			Pending:   len(q.hints),
+			Stored:    q.stored,
+			Replayed:  q.replayed,
+			Dropped:   q.dropped,
+			Expired:   q.expired,
+			Replaying: q.replaying,
+			Complete:  q.complete,
+			Since:     q.since.Format(time.RFC3339),
+			LastError: q.lastError,
+		}
+		if len(q.hints) > 0 {
+			s.OldestSeconds = float64(nowMillis()-q.hints[0].StoredAt) / 1000
+		}
+		if !q.lastReplay.IsZero() {
+			s.LastReplay = q.lastReplay.Format(time.RFC3339)
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Peer < status[j].Peer })
+	return status
+}
+
+// trim aplica los límites de tamaño y antigüedad (con hs.mu bloqueado). Cualquier
+// hint perdido obliga a pedir el diff cuando el peer vuelva
+func (hs *HintStore) trim(q *hintQueue) {
+	limit := nowMillis() - hs.maxAge.Milliseconds()
+	for len(q.hints) > 0 && q.hints[0].StoredAt < limit {
+		q.hints = q.hints[1:]
+		q.expired++
+		q.rewrite++
+		q.complete = false
+	}
+	for len(q.hints) > hs.maxHints {
+		q.hints = q.hints[1:]
+		q.dropped++
+		q.rewrite++
+		q.complete = false
+	}
+}
+
+//********************************************************************
+// Persistencia en disco
+//********************************************************************
+
+func (hs *HintStore) path(peer string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, peer)
+	return filepath.Join(hs.dir, name+".jsonl")
+}
+
+// appendToDisk añade el hint al fichero del peer. Cuando los descartados pesan más que
+// los vigentes se reescribe el fichero entero para que no crezca sin límite
+func (hs *HintStore) appendToDisk(peer string, q *hintQueue, hint Hint) {
+	if q.rewrite >= hs.maxHints {
+		hs.rewriteFile(peer, q)
+		return
+	}
+
+	f, err := os.OpenFile(hs.path(peer), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("⚠️ Error guardando hint de %s: %v", peer, err)
+		return
+	}
+	defer f.Close()
+
+	line, _ := json.Marshal(hint)
+	f.Write(append(security.SealLine(line), '\n'))
+}
+
+func (hs *HintStore) rewriteFile(peer string, q *hintQueue) {
+	tmp := hs.path(peer) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("⚠️ Error reescribiendo los hints de %s: %v", peer, err)
+		return
+	}
+
+	w := bufio.NewWriter(f)
+	for _, hint := range q.hints {
+		line, _ := json.Marshal(hint)
+		w.Write(append(security.SealLine(line), '\n'))
+	}
+	w.Flush()
+	f.Close()
+
+	if err := os.Rename(tmp, hs.path(peer)); err != nil {
+		log.Printf("⚠️ Error reescribiendo los hints de %s: %v", peer, err)
+		return
+	}
+	q.rewrite = 0
+}
+
+// load recupera los hints guardados en disco. No se sabe qué pasó mientras este nodo
+// estaba parado, así que esas colas nunca se consideran completas
+func (hs *HintStore) load() {
+	files, _ := filepath.Glob(filepath.Join(hs.dir, "*.jsonl"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			line, err := security.OpenLine(scanner.Bytes())
+			if err != nil {
+				log.Printf("⚠️ Hint de %s descartado: %v", file, err)
+				continue
+			}
+			var hint Hint
+			if json.Unmarshal(line, &hint) != nil || hint.Peer == "" {
+				continue
+			}
+			q, ok := hs.queues[hint.Peer]
+			if !ok {
+				q = &hintQueue{since: time.UnixMilli(hint.StoredAt)}
+				hs.queues[hint.Peer] = q
+			}
+			q.seq++
+			hint.seq = q.seq
+			q.hints = append(q.hints, hint)
+		}
+		f.Close()
+	}
+
+	for peer, q := range hs.queues {
+		hs.trim(q)
+		q.complete = false
+		log.Printf("📂 %d hints pendientes para %s cargados de disco", len(q.hints), peer)
+	}
+}
+
+// sendHints entrega un lote de hints en una sola petición binaria a /sync_bin, o
+// mensaje a mensaje por /sync si el peer no la soporta
+func sendHints(peer string, msgs []SyncMessage) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(peer + "/sync_bin")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType(ContentTypeSyncFrame)
+	setNodeHeader(req)
+	req.SetBody(EncodeFrame(msgs))
+
+	if err := fasthttp.DoTimeout(req, resp, 30*time.Second); err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case fasthttp.StatusOK:
+		return nil
+	case fasthttp.StatusNotFound:
+		for _, msg := range msgs {
+			data, _ := json.Marshal(msg)
+			if _, err := sendSync(peer, data, 10*time.Second); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("código de estado %d", resp.StatusCode())
+	}
+}
@@ -0,0 +1,105 @@
+package distributed
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyPeer acepta los primeros lotes de /sync_bin y rechaza el resto
+type flakyPeer struct {
+	mu       sync.Mutex
+	accept   int
+	received [][]SyncMessage
+}
+
+func (p *flakyPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	msgs, err := DecodeFrame(body)
+	if err != nil || p.accept == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	p.accept--
+	p.received = append(p.received, msgs)
+	acks := make([]SyncAck, len(msgs))
+	for i := range acks {
+		acks[i] = SyncAck{Status: "ok", Applied: true}
+	}
+	w.Write(EncodeAcks(acks))
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("no se pudo abrir %s: %v", path, err)
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestHintReplayCompactsFileAndRefreshesSentAt(t *testing.T) {
+	peer := &flakyPeer{accept: 1}
+	server := httptest.NewServer(peer)
+	defer server.Close()
+
+	hs := &HintStore{dir: t.TempDir(), maxHints: 10000, maxAge: time.Hour, queues: make(map[string]*hintQueue)}
+	total := hintReplayBatch + 100
+	for i := 0; i < total; i++ {
+		hs.Store(server.URL, SyncMessage{Action: "remove", Key: "k", Protocol: ProtocolVersion, SentAt: 1})
+	}
+	if lines := countLines(t, hs.path(server.URL)); lines != total {
+		t.Fatalf("el fichero tiene %d hints, se esperaban %d", lines, total)
+	}
+
+	// El segundo lote falla: el primero ya no debe quedar en disco
+	if hs.Replay(server.URL) {
+		t.Fatalf("Replay dio por entregados todos los hints")
+	}
+	if lines := countLines(t, hs.path(server.URL)); lines != 100 {
+		t.Fatalf("tras entregar un lote el fichero tiene %d hints, se esperaban 100", lines)
+	}
+	if !hs.Pending(server.URL) {
+		t.Fatalf("no quedan hints pendientes en memoria")
+	}
+
+	peer.mu.Lock()
+	sentAt := peer.received[0][0].SentAt
+	peer.mu.Unlock()
+	if time.Since(time.UnixMilli(sentAt)) > time.Minute {
+		t.Fatalf("SentAt = %d, se esperaba la hora del reenvío", sentAt)
+	}
+
+	// Al cargar de nuevo solo vuelven los pendientes
+	reloaded := &HintStore{dir: hs.dir, maxHints: 10000, maxAge: time.Hour, queues: make(map[string]*hintQueue)}
+	reloaded.load()
+	if status := reloaded.Status(); len(status) != 1 || status[0].Pending != 100 {
+		t.Fatalf("hints cargados = %+v, se esperaban 100", status)
+	}
+
+	peer.mu.Lock()
+	peer.accept = 1
+	peer.mu.Unlock()
+	hs.Replay(server.URL)
+	if hs.Pending(server.URL) {
+		t.Fatalf("quedaron hints tras un reenvío completo")
+	}
+	if _, err := os.Stat(hs.path(server.URL)); !os.IsNotExist(err) {
+		t.Fatalf("el fichero de hints sigue en disco: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package distributed
+
+import "github.com/valyala/fasthttp"
+
+// Roles de un nodo
+const (
+	RoleMember   = "member"   // Acepta escrituras y cuenta para el quorum
+	RoleObserver = "observer" // Réplica de solo lectura: recibe los cambios pero no cuenta para el quorum
+)
+
+var (
+	localRole            = RoleMember
+	recoverFromObservers bool // Usar observers como fuente de recuperación
+)
+
+// IsObserver indica si este nodo es un observer de solo lectura
+func IsObserver() bool {
+	return localRole == RoleObserver
+}
+
+// isObserver indica si un peer se anunció como observer (se debe llamar con pm.mu bloqueado)
+func (pm *PeerManager) isObserver(peer string) bool {
+	negotiated, ok := pm.protocols[peer]
+	return ok && negotiated.Role == RoleObserver
+}
+
+// GetMemberPeers devuelve los peers activos que aceptan escrituras. Son los que
+// cuentan para el quorum y los que se usan como fuente del diff
+func (pm *PeerManager) GetMemberPeers() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var members []string
+	for peer, failures := range pm.peers {
+		if failures < pm.maxFailures && pm.isCompatible(peer) && !pm.isObserver(peer) {
+			members = append(members, peer)
+		}
+	}
+	return members
+}
+
+// FromMember indica si la petición la hace un member del cluster, según la cabecera
+// X-Phoenix-Node
+func FromMember(pm *PeerManager, ctx *fasthttp.RequestCtx) bool {
+	node := ctx.Request.Header.Peek(HeaderNode)
+	if len(node) == 0 {
+		return false
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	_, known := pm.peers[string(node)]
+	return known && pm.isCompatible(string(node)) && !pm.isObserver(string(node))
+}
+
+// observers devuelve el conjunto de peers que se anunciaron como observers
+func (pm *PeerManager) observers() map[string]bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	observers := make(map[string]bool)
+	for peer := range pm.peers {
+		if pm.isObserver(peer) {
+			observers[peer] = true
+		}
+	}
+	return observers
+}
@@ -0,0 +1,28 @@
+package distributed
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestFromMemberOnlyTrustsKnownMembers(t *testing.T) {
+	pm := &PeerManager{peers: map[string]int{"http://member:8080": 0, "http://observer:8080": 0}, protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	pm.protocols["http://observer:8080"] = PeerProtocol{Protocol: ProtocolVersion, Compatible: true, Role: RoleObserver}
+
+	cases := map[string]bool{
+		"":                     false,
+		"http://member:8080":   true,
+		"http://observer:8080": false,
+		"http://unknown:8080":  false,
+	}
+	for node, want := range cases {
+		var ctx fasthttp.RequestCtx
+		if node != "" {
+			ctx.Request.Header.Set(HeaderNode, node)
+		}
+		if got := FromMember(pm, &ctx); got != want {
+			t.Fatalf("FromMember(%q) = %v, se esperaba %v", node, got, want)
+		}
+	}
+}
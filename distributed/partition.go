@@ -0,0 +1,320 @@
+package distributed
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Estados del cluster vistos desde este nodo
+const (
+	ClusterHealthy  = "healthy"  // Todos los peers responden
+	ClusterDegraded = "degraded" // Faltan peers pero este lado tiene mayoría
+	ClusterMinority = "minority" // Este lado no tiene mayoría: posible split-brain
+)
+
+const (
+	maxReports      = 20   // Informes de reconciliación que se guardan
+	maxReportedKeys = 1000 // Claves que se detallan en cada informe
+)
+
+// PartitionMonitor detecta particiones a partir de los peers que responden y, cuando
+// un peer que siguió vivo durante la partición vuelve, reconcilia las dos cachés
+type PartitionMonitor struct {
+	peerManager  *PeerManager
+	cache        *internal.Cache
+	rejectWrites bool
+
+	mu          sync.Mutex
+	state       string
+	since       time.Time
+	reachable   int
+	size        int
+	unreachable []string
+	downSince   map[string]time.Time // Cuándo dejó de responder cada peer
+	reports     []ReconciliationReport
+}
+
+// PartitionStatus es el estado que se expone en /admin/partition
+type PartitionStatus struct {
+	State        string                 `json:"state"`
+	Since        string                 `json:"since"`
+	ClusterSize  int                    `json:"cluster_size"`
+	Reachable    int                    `json:"reachable"`
+	Unreachable  []string               `json:"unreachable,omitempty"`
+	RejectWrites bool                   `json:"reject_writes_in_minority"`
+	Reports      []ReconciliationReport `json:"reconciliations,omitempty"`
+}
+
+// ReconciliationReport describe qué claves divergieron con un peer tras una partición
+// y cómo se resolvió cada una
+type ReconciliationReport struct {
+	Peer           string        `json:"peer"`
+	PartitionSince string        `json:"partition_since"`
+	StartedAt      string        `json:"started_at"`
+	DurationMs     float64       `json:"duration_ms"`
+	Compared       int           `json:"compared"`
+	Diverged       int           `json:"diverged"`
+	PulledFromPeer int           `json:"pulled_from_peer"`
+	Keys           []DivergedKey `json:"keys,omitempty"`
+	Truncated      bool          `json:"truncated,omitempty"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// DivergedKey es una clave con distinto contenido a cada lado de la partición
+type DivergedKey struct {
+	Key           string `json:"key"`
+	LocalVersion  uint64 `json:"local_version,omitempty"`
+	RemoteVersion uint64 `json:"remote_version,omitempty"`
+	Resolution    string `json:"resolution"`
+}
+
+// Resoluciones de una clave divergente (gana la versión más reciente). Cada lado solo
+// trae lo que le falta: lo que gana aquí lo trae el peer en su propia reconciliación
+const (
+	resolvedLocalOnly  = "local_only_kept"     // Solo existía aquí
+	resolvedRemoteOnly = "remote_only_pulled"  // Solo existía en el peer: se copia aquí
+	resolvedLocalWins  = "local_newer_kept"    // La versión local es más reciente
+	resolvedRemoteWins = "remote_newer_pulled" // La versión del peer es más reciente
+)
+
+// NewPartitionMonitor crea el detector de particiones y lo asocia al PeerManager
+func NewPartitionMonitor(config *configuration.Config, peerManager *PeerManager, cache *internal.Cache) *PartitionMonitor {
+	monitor := &PartitionMonitor{
+		peerManager:  peerManager,
+		cache:        cache,
+		rejectWrites: config.Partition.RejectMinorityWrites,
+		state:        ClusterHealthy,
+		since:        time.Now(),
+		downSince:    make(map[string]time.Time),
+	}
+	peerManager.partition = monitor
+	return monitor
+}
+
+// peerDown anota cuándo dejó de responder un peer
+func (pmon *PartitionMonitor) peerDown(peer string) {
+	if pmon == nil {
+		return
+	}
+	pmon.mu.Lock()
+	defer pmon.mu.Unlock()
+	if _, ok := pmon.downSince[peer]; !ok {
+		pmon.downSince[peer] = time.Now()
+	}
+}
+
+// observe recalcula el estado del cluster con la vista actual de los peers
+func (pmon *PartitionMonitor) observe(status []PeerStatus) {
+	if pmon == nil {
+		return
+	}
+
+	// Los observers no cuentan para la mayoría
+	var unreachable []string
+	size := 1
+	for _, peer := range status {
+		if peer.Protocol != nil && peer.Protocol.Role == RoleObserver {
+			continue
+		}
+		size++
+		if peer.State != "active" {
+			unreachable = append(unreachable, peer.Address)
+		}
+	}
+	reachable := size - len(unreachable)
+
+	state := ClusterHealthy
+	switch {
+	case reachable*2 <= size:
+		state = ClusterMinority
+	case len(unreachable) > 0:
+		state = ClusterDegraded
+	}
+
+	pmon.mu.Lock()
+	defer pmon.mu.Unlock()
+
+	pmon.size = size
+	pmon.reachable = reachable
+	pmon.unreachable = unreachable
+	if state == pmon.state {
+		return
+	}
+
+	pmon.state = state
+	pmon.since = time.Now()
+	switch state {
+	case ClusterMinority:
+		log.Printf("🚨 Nodo en minoría: %d de %d nodos alcanzables, posible partición de red", reachable, size)
+	case ClusterDegraded:
+		log.Printf("⚠️ Cluster degradado: %d de %d nodos alcanzables", reachable, size)
+	default:
+		log.Printf("✅ Cluster sano: %d nodos alcanzables", size)
+	}
+}
+
+// State devuelve el estado del cluster visto desde este nodo
+func (pmon *PartitionMonitor) State() string {
+	if pmon == nil {
+		return ClusterHealthy
+	}
+	pmon.mu.Lock()
+	defer pmon.mu.Unlock()
+	return pmon.state
+}
+
+// RejectWrites indica si hay que rechazar las escrituras por estar en minoría
+func (pmon *PartitionMonitor) RejectWrites() bool {
+	return pmon != nil && pmon.rejectWrites && pmon.State() == ClusterMinority
+}
+
+// Status devuelve el estado del cluster y los últimos informes de reconciliación
+func (pmon *PartitionMonitor) Status() PartitionStatus {
+	pmon.mu.Lock()
+	defer pmon.mu.Unlock()
+
+	return PartitionStatus{
+		State:        pmon.state,
+		Since:        pmon.since.Format(time.RFC3339),
+		ClusterSize:  pmon.size,
+		Reachable:    pmon.reachable,
+		Unreachable:  pmon.unreachable,
+		RejectWrites: pmon.rejectWrites,
+		Reports:      append([]ReconciliationReport(nil), pmon.reports...),
+	}
+}
+
+// wasPartitioned indica si un peer que vuelve siguió funcionando mientras no
+// respondía (partición) en lugar de haberse reiniciado. Devuelve desde cuándo
+func (pmon *PartitionMonitor) wasPartitioned(peer string) (time.Time, bool) {
+	if pmon == nil {
+		return time.Time{}, false
+	}
+
+	pmon.mu.Lock()
+	since, ok := pmon.downSince[peer]
+	delete(pmon.downSince, peer)
+	pmon.mu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	info, err := FetchInfo(peer, probeTimeout)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return since, time.UnixMilli(info.StartedAt).Before(since)
+}
+
+func (pmon *PartitionMonitor) addReport(report ReconciliationReport) {
+	pmon.mu.Lock()
+	defer pmon.mu.Unlock()
+
+	pmon.reports = append(pmon.reports, report)
+	if len(pmon.reports) > maxReports {
+		pmon.reports = pmon.reports[len(pmon.reports)-maxReports:]
+	}
+}
+
+// Reconcile compara la caché local con la del peer y trae las claves en las que el
+// peer tiene una versión más reciente o que solo existen allí. Los dos lados de la
+// partición reconcilian por su cuenta, así que cada uno acaba con la versión más
+// reciente de cada clave (los borrados durante la partición no se conservan)
+func (pmon *PartitionMonitor) Reconcile(peer string, since time.Time) ReconciliationReport {
+	start := time.Now()
+	report := ReconciliationReport{
+		Peer:           peer,
+		PartitionSince: since.Format(time.RFC3339),
+		StartedAt:      start.Format(time.RFC3339),
+	}
+	defer func() {
+		report.DurationMs = durationMs(start)
+		pmon.addReport(report)
+	}()
+
+	log.Printf("🔀 Reconciliando la caché con %s tras la partición", peer)
+
+	remoteEntries, offset, err := fetchExport(pmon.peerManager, peer)
+	if err != nil {
+		report.Error = err.Error()
+		log.Printf("⚠️ Error reconciliando con %s: %v", peer, err)
+		return report
+	}
+
+	remote := make(map[string]internal.CacheEntry, len(remoteEntries))
+	for _, entry := range remoteEntries {
+		remote[entry.Key] = entry
+	}
+	local := make(map[string]internal.CacheEntry)
+	for _, entry := range pmon.cache.GetReplicated() {
+		local[entry.Key] = entry
+	}
+
+	var pull []internal.CacheEntry
+	record := func(key DivergedKey) {
+		report.Diverged++
+		if len(report.Keys) < maxReportedKeys {
+			report.Keys = append(report.Keys, key)
+		} else {
+			report.Truncated = true
+		}
+	}
+
+	for key, l := range local {
+		report.Compared++
+		r, ok := remote[key]
+		switch {
+		case !ok:
+			record(DivergedKey{Key: key, LocalVersion: l.Version, Resolution: resolvedLocalOnly})
+		case l.Version > r.Version:
+			record(DivergedKey{Key: key, LocalVersion: l.Version, RemoteVersion: r.Version, Resolution: resolvedLocalWins})
+		case l.Version < r.Version:
+			pull = append(pull, r)
+			record(DivergedKey{Key: key, LocalVersion: l.Version, RemoteVersion: r.Version, Resolution: resolvedRemoteWins})
+		}
+	}
+	for key, r := range remote {
+		if _, ok := local[key]; ok {
+			continue
+		}
+		report.Compared++
+		pull = append(pull, r)
+		record(DivergedKey{Key: key, RemoteVersion: r.Version, Resolution: resolvedRemoteOnly})
+	}
+	sort.Slice(report.Keys, func(i, j int) bool { return report.Keys[i].Key < report.Keys[j].Key })
+
+	internal.CacheMutex.Lock()
+	ApplyEntries(pmon.cache, pull, offset)
+	internal.CacheMutex.Unlock()
+	report.PulledFromPeer = len(pull)
+
+	log.Printf("✅ Reconciliación con %s: %d claves divergentes (%d traídas)",
+		peer, report.Diverged, report.PulledFromPeer)
+	return report
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandlePartitionStatus devuelve el estado del cluster y los informes de reconciliación
+func HandlePartitionStatus(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	pmon := peerManager.Partition()
+	if pmon == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(pmon.Status())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -2,7 +2,9 @@ package distributed
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,20 +15,31 @@ import (
 type PeerManager struct {
 	peers         map[string]int // Mapa de peers con fallos consecutivos
 	mu            sync.Mutex
-	maxFailures   int           // Número máximo de fallos antes de marcar un nodo como inactivo
-	checkInterval time.Duration // Intervalo entre checks
+	maxFailures   int                     // Número máximo de fallos antes de marcar un nodo como inactivo
+	checkInterval time.Duration           // Intervalo entre checks
+	gossip        *Gossip                 // Protocolo de membresía (opcional)
+	regions       *RegionReplicator       // Replicación a otras regiones (opcional)
+	transport     *Transport              // Conexiones persistentes con los peers (opcional)
+	hints         *HintStore              // Cambios pendientes de los peers caídos (opcional)
+	partition     *PartitionMonitor       // Detección de particiones (opcional)
+	protocols     map[string]PeerProtocol // Protocolo negociado con cada peer (/hello)
 }
 
 // NewPeerManager crea un nuevo gestor de peers
 func NewPeerManager(peers []string, checkInterval time.Duration, maxFailures int) *PeerManager {
 	pm := &PeerManager{
 		peers:         make(map[string]int),
+		protocols:     make(map[string]PeerProtocol),
 		maxFailures:   maxFailures,
 		checkInterval: checkInterval,
 	}
 
 	// Inicializamos la lista de peers
 	for _, peer := range peers {
+		if IsSelf(peer) {
+			log.Printf("ℹ️ Se ignora %s en la lista de peers: es este nodo", peer)
+			continue
+		}
 		pm.peers[peer] = 0 // 0 fallos al inicio
 	}
 
@@ -44,56 +57,252 @@ func (pm *PeerManager) startHeartbeat() {
 	}
 }
 
-// checkPeers verifica el estado de cada peer
+// checkPeers verifica el estado de cada peer. Los pings se hacen sin bloquear el
+// mapa de peers para que se puedan añadir o eliminar peers mientras tanto
 func (pm *PeerManager) checkPeers() {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	for _, peer := range pm.GetPeers() {
+		alive := pm.pingPeer(peer)
 
-	for peer := range pm.peers {
-		if pm.pingPeer(peer) {
+		pm.mu.Lock()
+		if _, exists := pm.peers[peer]; !exists {
+			// Eliminado mientras se hacía el ping
+			pm.mu.Unlock()
+			continue
+		}
 
+		if alive {
 			//No estaba activo y ahora si lo está...
-			if !IsActive(peer, pm) {
-				// Recuperar datos faltantes
-				callSetBatch(peer)
-			}
+			recovered := !IsActive(peer, pm)
+
 			//Poniendo el contador a cero se marca el peer activo :-)
 			pm.peers[peer] = 0
-		} else {
+			_, greeted := pm.protocols[peer]
+			pm.mu.Unlock()
+
+			// Al volver puede haberse actualizado: se negocia de nuevo el protocolo
+			if recovered || !greeted {
+				pm.handshake(peer)
+			}
+
+			if recovered || pm.hints.Pending(peer) {
+				go pm.recoverPeer(peer, recovered)
+			}
+			continue
+		}
 
-			if pm.peers[peer] < pm.maxFailures {
-				pm.peers[peer]++
+		if pm.peers[peer] < pm.maxFailures {
+			pm.peers[peer]++
+			if pm.peers[peer] == pm.maxFailures {
+				pm.hints.MarkDown(peer)
+				pm.partition.peerDown(peer)
 			}
 		}
+		pm.mu.Unlock()
+	}
+
+	pm.partition.observe(pm.GetPeerStatus())
+}
+
+// recoverPeer reenvía los hints pendientes del peer y, si el peer acaba de volver y
+// los hints no cubren todo lo que se perdió, le pide que recupere el diff. Si el peer
+// siguió funcionando mientras no respondía (partición) se reconcilian ambas cachés
+func (pm *PeerManager) recoverPeer(peer string, recovered bool) {
+	complete := pm.hints.Replay(peer)
+	if !recovered {
+		return
+	}
+
+	if since, partitioned := pm.partition.wasPartitioned(peer); partitioned {
+		// Cada lado trae lo que le falta; el peer hace lo mismo al vernos volver
+		pm.partition.Reconcile(peer, since)
+		return
+	}
+
+	if !complete {
+		// Recuperar datos faltantes
+		callSetBatch(peer)
 	}
 }
 
 func callSetBatch(peer string) {
-	fasthttp.Get(nil, fmt.Sprintf("%s/set_batch", peer))
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("%s/set_batch", peer))
+	setNodeHeader(req)
+	fasthttp.Do(req, resp)
 }
 
+// IsActive indica si un peer está activo (se debe llamar con pm.mu bloqueado)
 func IsActive(peer string, pm *PeerManager) bool {
 	return pm.peers[peer] < pm.maxFailures
 }
 
-// pingPeer hace una solicitud al endpoint /ping de un peer
+// pingPeer hace una solicitud al endpoint /ping de un peer. De paso mide su reloj
 func (pm *PeerManager) pingPeer(peer string) bool {
-	statusCode, _, err := fasthttp.Get(nil, fmt.Sprintf("%s/ping", peer))
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("%s/ping", peer))
+	sent := time.Now()
+	if err := fasthttp.Do(req, resp); err != nil || resp.StatusCode() != http.StatusOK {
+		return false
+	}
+	recordClock(peer, sent, time.Now(), resp)
+	return true
+}
+
+// AddPeer añade un peer en tiempo de ejecución. Devuelve false si ya existía o si
+// es el propio nodo
+func (pm *PeerManager) AddPeer(peer string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, exists := pm.peers[peer]; exists || IsSelf(peer) {
+		return false
+	}
+	pm.peers[peer] = 0
+	return true
+}
+
+// RemovePeer elimina un peer en tiempo de ejecución. Devuelve false si no existía
+func (pm *PeerManager) RemovePeer(peer string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	if err != nil || statusCode != http.StatusOK {
+	if _, exists := pm.peers[peer]; !exists {
 		return false
 	}
+	delete(pm.peers, peer)
+	delete(pm.protocols, peer)
+	forgetClock(peer)
+	pm.hints.Forget(peer)
+	pm.transport.Forget(peer)
 	return true
 }
 
-// GetActivePeers devuelve una lista de nodos activos
+// Gossip devuelve el protocolo de membresía asociado (nil si no está activo)
+func (pm *PeerManager) Gossip() *Gossip {
+	if pm == nil {
+		return nil
+	}
+	return pm.gossip
+}
+
+// PeerStatus es el estado de un peer tal como lo ve el PeerManager
+type PeerStatus struct {
+	Address  string        `json:"address"`
+	Failures int           `json:"failures"`
+	State    string        `json:"state"` // active, inactive o incompatible
+	Protocol *PeerProtocol `json:"protocol,omitempty"`
+}
+
+// GetPeers devuelve todos los peers conocidos ordenados, activos o no
+func (pm *PeerManager) GetPeers() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	peers := make([]string, 0, len(pm.peers))
+	for peer := range pm.peers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// GetPeerStatus devuelve el estado de todos los peers ordenados por dirección
+func (pm *PeerManager) GetPeerStatus() []PeerStatus {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	status := make([]PeerStatus, 0, len(pm.peers))
+	for peer, failures := range pm.peers {
+		state := "active"
+		switch {
+		case !IsActive(peer, pm):
+			state = "inactive"
+		case !pm.isCompatible(peer):
+			state = "incompatible"
+		}
+
+		peerStatus := PeerStatus{Address: peer, Failures: failures, State: state}
+		if negotiated, ok := pm.protocols[peer]; ok {
+			peerStatus.Protocol = &negotiated
+		}
+		status = append(status, peerStatus)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Address < status[j].Address })
+	return status
+}
+
+// Regions devuelve el replicador de regiones asociado (nil si no hay regiones)
+func (pm *PeerManager) Regions() *RegionReplicator {
+	if pm == nil {
+		return nil
+	}
+	return pm.regions
+}
+
+// Transport devuelve el transporte persistente entre nodos, o nil si no está activo
+func (pm *PeerManager) Transport() *Transport {
+	if pm == nil {
+		return nil
+	}
+	return pm.transport
+}
+
+// Partition devuelve el detector de particiones, o nil si no está activo
+func (pm *PeerManager) Partition() *PartitionMonitor {
+	if pm == nil {
+		return nil
+	}
+	return pm.partition
+}
+
+// Hints devuelve el almacén de hints, o nil si el hinted handoff no está activo
+func (pm *PeerManager) Hints() *HintStore {
+	if pm == nil {
+		return nil
+	}
+	return pm.hints
+}
+
+// syncTargets separa los peers a los que se envía un cambio directamente de los que
+// lo reciben como hint: los inactivos y los que aún tienen hints por reenviar. Los
+// peers con un protocolo incompatible no reciben nada
+func (pm *PeerManager) syncTargets() (direct []string, hinted []string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for peer, failures := range pm.peers {
+		switch {
+		case !pm.isCompatible(peer):
+			// No se le envía nada que no pueda entender
+		case failures >= pm.maxFailures:
+			if pm.hints != nil {
+				hinted = append(hinted, peer)
+			}
+		case pm.hints.Pending(peer):
+			hinted = append(hinted, peer)
+		default:
+			direct = append(direct, peer)
+		}
+	}
+	return direct, hinted
+}
+
+// GetActivePeers devuelve una lista de nodos activos. Los incompatibles no cuentan
 func (pm *PeerManager) GetActivePeers() []string {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
 	var activePeers []string
 	for peer, failures := range pm.peers {
-		if failures < pm.maxFailures {
+		if failures < pm.maxFailures && pm.isCompatible(peer) {
 			activePeers = append(activePeers, peer)
 		}
 	}
@@ -0,0 +1,195 @@
+package distributed
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ProtocolVersion es la versión del formato de intercambio entre nodos.
+//   - 1: TTL relativos (SyncMessage.TTL, CacheEntry.ExpiresIn)
+//   - 2: instantes absolutos de expiración (ExpiresAt) y marca de envío (SentAt)
+const ProtocolVersion = 2
+
+// Cabeceras que acompañan a las respuestas entre nodos
+const (
+	HeaderProtocol     = "X-Phoenix-Protocol"
+	HeaderSentAt       = "X-Phoenix-Sent-At"
+	HeaderClusterState = "X-Phoenix-Cluster-State"
+	HeaderNode         = "X-Phoenix-Node" // Dirección del nodo que envía una petición
+)
+
+// Diferencia máxima de reloj tolerada entre nodos
+var maxClockSkew = 5 * time.Second
+
+// Identidad del nodo local: la dirección con la que lo ven los demás y un
+// identificador aleatorio por arranque para reconocerse aunque se use otro alias
+var (
+	localAddress string
+	nodeID       = newNodeID()
+	startedAt    = time.Now()
+)
+
+func newNodeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InitModule inicializa los parámetros del módulo de distribución
+func InitModule(config *configuration.Config) {
+	localAddress = config.AdvertiseAddress
+	localRole = config.Role
+	recoverFromObservers = config.RecoverFromObservers
+	forwarding = config.Forwarding
+	if forwarding.Mode != "" && forwarding.Mode != ForwardNone && isLoopbackAddress(localAddress) {
+		log.Printf("⚠️ advertise_address es %s: las escrituras no se reenviarán si los peers usan otra dirección", localAddress)
+	}
+	replicationPolicy = config.Replication
+	if config.MaxClockSkew > 0 {
+		maxClockSkew = time.Duration(config.MaxClockSkew) * time.Second
+	}
+	if config.QuorumTimeout > 0 {
+		quorumTimeout = time.Duration(config.QuorumTimeout) * time.Millisecond
+	}
+}
+
+// nowMillis devuelve el instante actual en milisegundos Unix
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// SetProtocolHeaders añade la versión de protocolo y la hora local a una respuesta
+func SetProtocolHeaders(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set(HeaderProtocol, strconv.Itoa(ProtocolVersion))
+	ctx.Response.Header.Set(HeaderSentAt, strconv.FormatInt(nowMillis(), 10))
+}
+
+// clockSample es el desfase de reloj con un peer medido en un ida y vuelta, como en
+// NTP: la hora que manda el peer se compara con el punto medio entre el envío de la
+// petición y la llegada de la respuesta. El error de la medida es como mucho la mitad
+// del ida y vuelta
+type clockSample struct {
+	offset      time.Duration // Reloj remoto menos reloj local
+	uncertainty time.Duration
+	at          time.Time
+}
+
+// Una medida más precisa solo se sustituye por otra peor pasado este tiempo
+const clockSampleTTL = time.Minute
+
+// Última medida del reloj de cada peer
+var (
+	clocksMu   sync.Mutex
+	peerClocks = make(map[string]clockSample)
+)
+
+// measureClock calcula el desfase con la hora remota de una respuesta
+func measureClock(sent, received time.Time, remoteMillis int64) clockSample {
+	rtt := received.Sub(sent)
+	midpoint := sent.Add(rtt / 2)
+	return clockSample{
+		offset:      time.UnixMilli(remoteMillis).Sub(midpoint),
+		uncertainty: rtt/2 + time.Millisecond, // La hora remota va en milisegundos
+		at:          received,
+	}
+}
+
+// recordClock mide el reloj de un peer con la respuesta a una petición enviada en
+// 'sent' y recibida en 'received', y guarda la medida si mejora la anterior
+func recordClock(peer string, sent, received time.Time, resp *fasthttp.Response) (clockSample, bool) {
+	remoteMillis, err := strconv.ParseInt(string(resp.Header.Peek(HeaderSentAt)), 10, 64)
+	if err != nil || remoteMillis <= 0 {
+		return clockSample{}, false
+	}
+	sample := measureClock(sent, received, remoteMillis)
+
+	clocksMu.Lock()
+	previous, known := peerClocks[peer]
+	if known && sample.uncertainty > previous.uncertainty && received.Sub(previous.at) < clockSampleTTL {
+		clocksMu.Unlock()
+		return sample, true
+	}
+	peerClocks[peer] = sample
+	clocksMu.Unlock()
+
+	if skewed := sample.shift() != 0; skewed && (!known || previous.shift() == 0) {
+		log.Printf("⚠️ Desfase de reloj con %s de %v (±%v, tolerancia %v), se ajustan las expiraciones", peer, sample.offset, sample.uncertainty, maxClockSkew)
+	} else if !skewed && known && previous.shift() != 0 {
+		log.Printf("ℹ️ El reloj de %s vuelve a estar dentro de la tolerancia", peer)
+	}
+	return sample, true
+}
+
+// forgetClock olvida la medida del reloj de un peer que ya no forma parte del cluster
+func forgetClock(peer string) {
+	clocksMu.Lock()
+	defer clocksMu.Unlock()
+	delete(peerClocks, peer)
+}
+
+// shift devuelve lo que hay que sumar a un instante del peer para pasarlo al reloj
+// local. Solo se ajusta si el desfase supera la tolerancia incluso con el error de la
+// medida; si no, se confía en el instante absoluto
+func (s clockSample) shift() time.Duration {
+	skew := s.offset
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew-s.uncertainty <= maxClockSkew {
+		return 0
+	}
+	return -s.offset
+}
+
+// clockOffset devuelve el desfase a aplicar a los instantes que envía un peer, según
+// la última medida de su reloj (los heartbeats la renuevan). Sin medida se confía en
+// el instante absoluto: la hora de envío de un mensaje no sirve para medir el reloj,
+// porque incluye el tiempo que pasó en colas y en la red
+func clockOffset(peer string) time.Duration {
+	clocksMu.Lock()
+	sample, known := peerClocks[peer]
+	clocksMu.Unlock()
+	if !known {
+		return 0
+	}
+	return sample.shift()
+}
+
+// responseOffset mide el reloj del peer con el ida y vuelta de una petición y devuelve
+// el desfase a aplicar a los instantes de la respuesta. Si hay una medida reciente más
+// precisa (p.e. de un heartbeat) se usa esa
+func responseOffset(resp *fasthttp.Response, peer string, sent, received time.Time) time.Duration {
+	if _, ok := recordClock(peer, sent, received, resp); !ok {
+		return 0
+	}
+	return clockOffset(peer)
+}
+
+// requestPeer devuelve el nodo que envía una petición: su dirección si la indica en
+// la cabecera X-Phoenix-Node o, si no, la dirección de la conexión
+func requestPeer(ctx *fasthttp.RequestCtx) string {
+	if node := ctx.Request.Header.Peek(HeaderNode); len(node) > 0 {
+		return string(node)
+	}
+	return ctx.RemoteAddr().String()
+}
+
+// setNodeHeader identifica al nodo local en una petición a otro nodo
+func setNodeHeader(req *fasthttp.Request) {
+	if localAddress != "" {
+		req.Header.Set(HeaderNode, localAddress)
+	}
+}
+
+// expiryFromMillis convierte un instante remoto en milisegundos a la hora local
+func expiryFromMillis(expiresAt int64, offset time.Duration) time.Time {
+	return time.UnixMilli(expiresAt).Add(offset)
+}
@@ -0,0 +1,82 @@
+package distributed
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func responseAt(remote time.Time) *fasthttp.Response {
+	resp := &fasthttp.Response{}
+	resp.Header.Set(HeaderSentAt, strconv.FormatInt(remote.UnixMilli(), 10))
+	return resp
+}
+
+func TestMeasureClockUsesRoundTripMidpoint(t *testing.T) {
+	sent := time.UnixMilli(1_000_000)
+	received := sent.Add(200 * time.Millisecond)
+	// El peer va 30s adelantado y contestó justo a mitad del ida y vuelta
+	remote := sent.Add(100*time.Millisecond + 30*time.Second)
+
+	sample := measureClock(sent, received, remote.UnixMilli())
+	if sample.offset != 30*time.Second {
+		t.Fatalf("offset = %v, se esperaba 30s", sample.offset)
+	}
+	if sample.uncertainty != 101*time.Millisecond {
+		t.Fatalf("uncertainty = %v, se esperaba 101ms", sample.uncertainty)
+	}
+	if shift := sample.shift(); shift != -30*time.Second {
+		t.Fatalf("shift = %v, se esperaba -30s", shift)
+	}
+}
+
+func TestClockOffsetIgnoresTransitDelay(t *testing.T) {
+	peer := "http://delayed.test:1"
+	defer forgetClock(peer)
+
+	// Sin medida del reloj no se ajusta nada, aunque el mensaje llegue tarde
+	if offset := clockOffset(peer); offset != 0 {
+		t.Fatalf("offset sin medida = %v, se esperaba 0", offset)
+	}
+
+	// Un ida y vuelta de 20s con los relojes sincronizados no es desfase de reloj
+	sent := time.Now().Add(-20 * time.Second)
+	received := time.Now()
+	if _, ok := recordClock(peer, sent, received, responseAt(received)); !ok {
+		t.Fatal("no se registró la medida")
+	}
+	if offset := clockOffset(peer); offset != 0 {
+		t.Fatalf("offset con un ida y vuelta lento = %v, se esperaba 0", offset)
+	}
+}
+
+func TestClockOffsetWithinTolerance(t *testing.T) {
+	peer := "http://close.test:1"
+	defer forgetClock(peer)
+
+	now := time.Now()
+	recordClock(peer, now.Add(-10*time.Millisecond), now, responseAt(now.Add(maxClockSkew/2)))
+	if offset := clockOffset(peer); offset != 0 {
+		t.Fatalf("offset = %v, se esperaba 0 dentro de la tolerancia", offset)
+	}
+}
+
+func TestClockOffsetKeepsMostPreciseSample(t *testing.T) {
+	peer := "http://skewed.test:1"
+	defer forgetClock(peer)
+
+	now := time.Now()
+	// Medida precisa: el peer va 1 minuto atrasado
+	recordClock(peer, now.Add(-10*time.Millisecond), now, responseAt(now.Add(-time.Minute-5*time.Millisecond)))
+	if offset := clockOffset(peer); offset < 59*time.Second || offset > 61*time.Second {
+		t.Fatalf("offset = %v, se esperaba ~1m", offset)
+	}
+
+	// Una medida peor (ida y vuelta de 40s) no sustituye a la anterior
+	recordClock(peer, now.Add(-40*time.Second), now, responseAt(now))
+	if offset := clockOffset(peer); offset < 59*time.Second || offset > 61*time.Second {
+		t.Fatalf("offset tras una medida imprecisa = %v, se esperaba ~1m", offset)
+	}
+}
@@ -0,0 +1,179 @@
+package distributed
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"phoenixcache/internal"
+)
+
+// Espera máxima de las operaciones de quorum (w / r)
+var quorumTimeout = 2 * time.Second
+
+var ErrQuorumNotReached = errors.New("no se alcanzó el número de réplicas solicitado")
+
+// ReplicateQuorum propaga el mensaje a todos los peers activos y espera hasta tener
+// 'needed' confirmaciones, hasta que respondan todos o hasta que venza el plazo.
+// Solo cuentan las réplicas que confirman que aplicaron el cambio (applied); los
+// observers lo reciben pero su confirmación no cuenta.
+// Los envíos pendientes siguen en segundo plano. Devuelve las confirmaciones obtenidas
+func ReplicateQuorum(msg SyncMessage, peerManager *PeerManager, needed int) int {
+	if peerManager == nil || needed <= 0 {
+		return 0
+	}
+
+	msg.Protocol = ProtocolVersion
+	msg.SentAt = nowMillis()
+	peerManager.Regions().Enqueue(msg)
+	data, _ := json.Marshal(msg)
+
+	peers, hinted := peerManager.syncTargets()
+	for _, peer := range hinted {
+		peerManager.Hints().Store(peer, msg)
+	}
+
+	observers := peerManager.observers()
+	acks := make(chan bool, len(peers))
+	for _, peer := range peers {
+		go func(peer string) {
+			ack, err := syncWith(peerManager, peer, msg, data, quorumTimeout)
+			if err != nil {
+				log.Printf("⚠️ Error sincronizando con %s: %v", peer, err)
+				peerManager.Hints().Store(peer, msg)
+			}
+			acks <- err == nil && ack.Applied && !observers[peer]
+		}(peer)
+	}
+
+	confirmed := 0
+	deadline := time.NewTimer(quorumTimeout)
+	defer deadline.Stop()
+
+	for i := 0; i < len(peers) && confirmed < needed; i++ {
+		select {
+		case ok := <-acks:
+			if ok {
+				confirmed++
+			}
+		case <-deadline.C:
+			return confirmed
+		}
+	}
+	return confirmed
+}
+
+// QuorumRead es el resultado de una lectura con quorum
+type QuorumRead struct {
+	KeyValue
+	Found     bool
+	Responses int // Réplicas que respondieron (incluida la local)
+	Repaired  int // Réplicas actualizadas por read repair
+}
+
+type replicaRead struct {
+	peer   string
+	value  KeyValue
+	found  bool
+	failed bool
+}
+
+// ReadQuorum lee la clave en local y en los peers activos que no son observers, devuelve la versión más
+// reciente si han respondido al menos 'needed' réplicas y actualiza las que estén
+// desactualizadas (read repair). Sin tombstones, una réplica sin la clave puede
+// venir de un borrado, así que entonces no se repara nada. Las claves solo locales
+// tampoco se reparan
+func ReadQuorum(key string, cache *internal.Cache, peerManager *PeerManager, needed int) (QuorumRead, error) {
+	var result QuorumRead
+
+	val, expTime, version, found := cache.GetVersioned(key)
+	local := replicaRead{value: KeyValue{Value: val, Expiration: expTime, Version: version}, found: found}
+
+	var peers []string
+	if peerManager != nil {
+		peers = peerManager.GetMemberPeers()
+	}
+
+	replies := make(chan replicaRead, len(peers))
+	for _, peer := range peers {
+		go func(peer string) {
+			values, offset, err := FetchKeys(peer, []string{key}, quorumTimeout)
+			if err != nil {
+				replies <- replicaRead{peer: peer, failed: true}
+				return
+			}
+			value, found := values[key]
+			value.Expiration = value.Expiration.Add(offset)
+			replies <- replicaRead{peer: peer, value: value, found: found}
+		}(peer)
+	}
+
+	reads := []replicaRead{local}
+	deadline := time.NewTimer(quorumTimeout)
+	defer deadline.Stop()
+
+collect:
+	for range peers {
+		select {
+		case reply := <-replies:
+			if !reply.failed {
+				reads = append(reads, reply)
+			}
+		case <-deadline.C:
+			break collect
+		}
+	}
+
+	result.Responses = len(reads)
+	if result.Responses < needed {
+		return result, ErrQuorumNotReached
+	}
+
+	// La versión más reciente gana
+	for _, read := range reads {
+		if read.found && (!result.Found || read.value.Version > result.Version) {
+			result.KeyValue = read.value
+			result.Found = true
+		}
+	}
+	if !result.Found || cache.IsLocal(key) {
+		return result, nil
+	}
+	for _, read := range reads {
+		if !read.found {
+			return result, nil
+		}
+	}
+
+	// Read repair de las réplicas que no tienen la última versión
+	msg := SyncMessage{
+		Action:    "set",
+		Key:       key,
+		Value:     result.Value,
+		ExpiresAt: result.Expiration.UnixMilli(),
+		Version:   result.Version,
+		Protocol:  ProtocolVersion,
+		SentAt:    nowMillis(),
+	}
+	data, _ := json.Marshal(msg)
+
+	for _, read := range reads {
+		if read.value.Version >= result.Version {
+			continue
+		}
+		result.Repaired++
+
+		if read.peer == "" {
+			cache.SetVersioned(key, result.Value, result.Expiration, result.Version)
+			continue
+		}
+		go func(peer string) {
+			if _, err := syncWith(peerManager, peer, msg, data, quorumTimeout); err != nil {
+				log.Printf("⚠️ Error en read repair de %s en %s: %v", key, peer, err)
+			}
+		}(read.peer)
+	}
+
+	return result, nil
+}
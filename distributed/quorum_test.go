@@ -0,0 +1,139 @@
+package distributed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"phoenixcache/internal"
+)
+
+// fakeReplica es un peer mínimo: contesta /sync con la respuesta indicada y /getKeys
+// con las claves que tenga
+type fakeReplica struct {
+	mu       sync.Mutex
+	syncBody string
+	keys     map[string]KeyValue
+	synced   []SyncMessage
+}
+
+func (f *fakeReplica) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/sync":
+		var msg SyncMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		f.synced = append(f.synced, msg)
+		w.Write([]byte(f.syncBody))
+	case "/getKeys":
+		json.NewEncoder(w).Encode(f.keys)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeReplica) received() []SyncMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SyncMessage(nil), f.synced...)
+}
+
+// testPeerManager crea un PeerManager con los peers activos, sin heartbeat
+func testPeerManager(t *testing.T, replicas ...*fakeReplica) *PeerManager {
+	pm := &PeerManager{peers: make(map[string]int), protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	for _, replica := range replicas {
+		server := httptest.NewServer(replica)
+		t.Cleanup(server.Close)
+		pm.peers[server.URL] = 0
+	}
+	return pm
+}
+
+func TestReplicateQuorumCountsOnlyAppliedAcks(t *testing.T) {
+	pm := testPeerManager(t,
+		&fakeReplica{syncBody: `{"status": "ok", "applied": true, "version": 2}`},
+		&fakeReplica{syncBody: `{"status": "ok", "applied": false, "version": 3}`},
+		&fakeReplica{syncBody: `Ok`},
+	)
+
+	msg := SyncMessage{Action: "set", Key: "k", Value: "v", ExpiresAt: time.Now().Add(time.Minute).UnixMilli(), Version: 2}
+	if acks := ReplicateQuorum(msg, pm, 3); acks != 1 {
+		t.Fatalf("confirmaciones = %d, solo cuenta la réplica que aplicó el cambio", acks)
+	}
+}
+
+func TestReadQuorumRepairsStaleReplicas(t *testing.T) {
+	expiration := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	stale := &fakeReplica{syncBody: `{"status": "ok", "applied": true}`, keys: map[string]KeyValue{"k": {Value: "viejo", Expiration: expiration, Version: 1}}}
+	pm := testPeerManager(t, stale)
+
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.SetVersioned("k", "nuevo", expiration, 5)
+
+	result, err := ReadQuorum("k", cache, pm, 2)
+	if err != nil {
+		t.Fatalf("ReadQuorum: %v", err)
+	}
+	if result.Value != "nuevo" || result.Repaired != 1 {
+		t.Fatalf("resultado = %+v, se esperaba 'nuevo' con una réplica reparada", result)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(stale.received()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("la réplica desactualizada no recibió el read repair")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := stale.received()[0]; got.Version != 5 || got.Value != "nuevo" {
+		t.Fatalf("read repair = %+v", got)
+	}
+}
+
+func TestReadQuorumDoesNotResurrectDeletes(t *testing.T) {
+	expiration := time.Now().Add(time.Minute)
+	deleted := &fakeReplica{syncBody: `{"status": "ok", "applied": true}`, keys: map[string]KeyValue{}}
+	pm := testPeerManager(t, deleted)
+
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.SetVersioned("k", "v", expiration, 5)
+
+	result, err := ReadQuorum("k", cache, pm, 2)
+	if err != nil {
+		t.Fatalf("ReadQuorum: %v", err)
+	}
+	if !result.Found || result.Repaired != 0 {
+		t.Fatalf("resultado = %+v, no se debía reparar la réplica sin la clave", result)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := deleted.received(); len(got) != 0 {
+		t.Fatalf("se reenvió la clave a la réplica que la borró: %+v", got)
+	}
+}
+
+func TestReadQuorumSkipsLocalOnlyKeys(t *testing.T) {
+	expiration := time.Now().Add(time.Minute)
+	stale := &fakeReplica{syncBody: `{"status": "ok", "applied": true}`, keys: map[string]KeyValue{"k": {Value: "otro", Expiration: expiration, Version: 1}}}
+	pm := testPeerManager(t, stale)
+
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.SetVersioned("k", "local", expiration, 5)
+	cache.SetLocal("k", true)
+
+	result, err := ReadQuorum("k", cache, pm, 2)
+	if err != nil {
+		t.Fatalf("ReadQuorum: %v", err)
+	}
+	if result.Repaired != 0 {
+		t.Fatalf("se reparó una clave solo local: %+v", result)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := stale.received(); len(got) != 0 {
+		t.Fatalf("la clave solo local salió del nodo: %+v", got)
+	}
+}
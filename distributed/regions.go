@@ -0,0 +1,304 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RegionBatch es el lote de cambios que se envía al gateway de otra región
+type RegionBatch struct {
+	Region   string        `json:"region"`
+	SentAt   int64         `json:"sent_at"`
+	Messages []SyncMessage `json:"messages"`
+}
+
+// RegionReplicator replica de forma asíncrona los cambios locales a las regiones remotas
+type RegionReplicator struct {
+	region string
+	links  []*regionLink
+
+	mu       sync.Mutex
+	incoming map[string]*incomingStats // Estadísticas de lo recibido por región de origen
+}
+
+// regionLink es la cola de envío hacia el gateway de una región
+type regionLink struct {
+	config configuration.RegionConfig
+
+	mu        sync.Mutex
+	queue     []queuedMessage
+	sent      uint64
+	failed    uint64
+	dropped   uint64
+	lastFlush time.Time
+	lastError string
+}
+
+type queuedMessage struct {
+	msg      SyncMessage
+	enqueued time.Time
+}
+
+type incomingStats struct {
+	batches      uint64
+	messages     uint64
+	lastReceived time.Time
+	lastDelay    time.Duration
+}
+
+// RegionStatus son las métricas de replicación hacia una región
+type RegionStatus struct {
+	Name         string  `json:"name"`
+	Gateway      string  `json:"gateway"`
+	Pending      int     `json:"pending"`
+	LagSeconds   float64 `json:"lag_seconds"` // Antigüedad del cambio pendiente más viejo
+	Sent         uint64  `json:"sent"`
+	Failed       uint64  `json:"failed_batches"`
+	Dropped      uint64  `json:"dropped"`
+	LastFlush    string  `json:"last_flush,omitempty"`
+	LastError    string  `json:"last_error,omitempty"`
+	Direction    string  `json:"direction"`
+	Received     uint64  `json:"received,omitempty"`
+	LastReceived string  `json:"last_received,omitempty"`
+	DelaySeconds float64 `json:"delay_seconds,omitempty"` // Retraso del último lote recibido
+}
+
+// NewRegionReplicator crea el replicador de regiones y lo asocia al PeerManager
+func NewRegionReplicator(config *configuration.Config, peerManager *PeerManager) *RegionReplicator {
+	rr := &RegionReplicator{
+		region:   config.Region,
+		incoming: make(map[string]*incomingStats),
+	}
+
+	for _, region := range config.Regions {
+		if region.Name == config.Region || region.Gateway == "" {
+			continue
+		}
+		rr.links = append(rr.links, &regionLink{config: region})
+	}
+
+	peerManager.regions = rr
+	return rr
+}
+
+// Start arranca el envío periódico de lotes a cada región
+func (rr *RegionReplicator) Start() {
+	for _, link := range rr.links {
+		go link.run(rr.region)
+	}
+}
+
+// Enqueue encola un cambio local para las regiones cuyo filtro lo acepta. Los cambios
+// que llegan de otra región no se reenvían
+func (rr *RegionReplicator) Enqueue(msg SyncMessage) {
+	if rr == nil || (msg.Origin != "" && msg.Origin != rr.region) {
+		return
+	}
+	msg.Origin = rr.region
+
+	for _, link := range rr.links {
+		if link.accepts(msg) {
+			link.enqueue(msg)
+		}
+	}
+}
+
+// Status devuelve las métricas de cada región, salientes y entrantes
+func (rr *RegionReplicator) Status() []RegionStatus {
+	var status []RegionStatus
+	for _, link := range rr.links {
+		status = append(status, link.status())
+	}
+
+	rr.mu.Lock()
+	for name, in := range rr.incoming {
+		status = append(status, RegionStatus{
+			Name:         name,
+			Direction:    "incoming",
+			Received:     in.messages,
+			LastReceived: in.lastReceived.Format(time.RFC3339),
+			DelaySeconds: in.lastDelay.Seconds(),
+		})
+	}
+	rr.mu.Unlock()
+
+	sort.SliceStable(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+func (rr *RegionReplicator) recordIncoming(batch RegionBatch) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	in, ok := rr.incoming[batch.Region]
+	if !ok {
+		in = &incomingStats{}
+		rr.incoming[batch.Region] = in
+	}
+	in.batches++
+	in.messages += uint64(len(batch.Messages))
+	in.lastReceived = time.Now()
+
+	// El SentAt de cada mensaje es el de la escritura original
+	if len(batch.Messages) > 0 && batch.Messages[0].SentAt > 0 {
+		in.lastDelay = time.Duration(nowMillis()-batch.Messages[0].SentAt) * time.Millisecond
+	}
+}
+
+// accepts aplica el filtro de prefijos de la región. Las operaciones que no afectan
+// a una clave concreta (flush, borrado por patrón) solo cruzan si no hay filtro
+func (link *regionLink) accepts(msg SyncMessage) bool {
+	if len(link.config.Prefixes) == 0 {
+		return true
+	}
+	if msg.Action != "set" && msg.Action != "remove" {
+		return false
+	}
+	for _, prefix := range link.config.Prefixes {
+		if strings.HasPrefix(msg.Key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (link *regionLink) enqueue(msg SyncMessage) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	if len(link.queue) >= link.config.MaxQueue {
+		link.queue = link.queue[1:]
+		link.dropped++
+	}
+	link.queue = append(link.queue, queuedMessage{msg: msg, enqueued: time.Now()})
+}
+
+func (link *regionLink) run(localRegion string) {
+	interval := time.Duration(link.config.FlushInterval) * time.Millisecond
+	for {
+		time.Sleep(interval)
+		for link.flush(localRegion) {
+		}
+	}
+}
+
+// flush envía el siguiente lote. Si falla, el lote se queda en la cola para reintentarlo.
+// Devuelve true si quedan más mensajes por enviar
+func (link *regionLink) flush(localRegion string) bool {
+	link.mu.Lock()
+	n := len(link.queue)
+	if n == 0 {
+		link.mu.Unlock()
+		return false
+	}
+	if n > link.config.BatchSize {
+		n = link.config.BatchSize
+	}
+	droppedBefore := link.dropped
+	batch := RegionBatch{Region: localRegion, SentAt: nowMillis(), Messages: make([]SyncMessage, 0, n)}
+	for _, queued := range link.queue[:n] {
+		batch.Messages = append(batch.Messages, queued.msg)
+	}
+	link.mu.Unlock()
+
+	status, _, err := postJSON(link.config.Gateway+"/sync_region", batch, 10*time.Second)
+	if err == nil && status != fasthttp.StatusOK {
+		err = fmt.Errorf("código de estado %d", status)
+	}
+
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	if err != nil {
+		link.failed++
+		link.lastError = err.Error()
+		log.Printf("⚠️ Error replicando a la región %s: %v", link.config.Name, err)
+		return false
+	}
+
+	// Los mensajes descartados mientras se enviaba salieron de la cabeza de la cola
+	n -= int(link.dropped - droppedBefore)
+	if n > 0 {
+		link.queue = link.queue[n:]
+	}
+	link.sent += uint64(len(batch.Messages))
+	link.lastFlush = time.Now()
+	link.lastError = ""
+	return len(link.queue) > 0
+}
+
+func (link *regionLink) status() RegionStatus {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	status := RegionStatus{
+		Name:      link.config.Name,
+		Gateway:   link.config.Gateway,
+		Direction: "outgoing",
+		Pending:   len(link.queue),
+		Sent:      link.sent,
+		Failed:    link.failed,
+		Dropped:   link.dropped,
+		LastError: link.lastError,
+	}
+	if len(link.queue) > 0 {
+		status.LagSeconds = time.Since(link.queue[0].enqueued).Seconds()
+	}
+	if !link.lastFlush.IsZero() {
+		status.LastFlush = link.lastFlush.Format(time.RFC3339)
+	}
+	return status
+}
+
+//********************************************************************
+// Handlers de la replicación entre regiones
+//********************************************************************
+
+// HandleSyncRegion recibe un lote de otra región, lo aplica y lo reparte a los peers locales
+func HandleSyncRegion(peerManager *PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	var batch RegionBatch
+	if err := json.Unmarshal(ctx.PostBody(), &batch); err != nil {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if rr := peerManager.Regions(); rr != nil {
+		rr.recordIncoming(batch)
+	}
+
+	// Las expiraciones se corrigen con el desfase de reloj medido con el gateway que
+	// manda el lote (X-Phoenix-Node), si es uno de nuestros peers
+	peer := requestPeer(ctx)
+	for _, msg := range batch.Messages {
+		if msg.Origin == "" {
+			msg.Origin = batch.Region
+		}
+		ApplySyncMessage(cache, msg, peer)
+		PropagateChange(msg, peerManager)
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleRegionStatus devuelve las métricas de replicación por región
+func HandleRegionStatus(peerManager *PeerManager, ctx *fasthttp.RequestCtx) {
+	rr := peerManager.Regions()
+	if rr == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(rr.Status())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,78 @@
+package distributed
+
+import (
+	"fmt"
+	"strings"
+
+	"phoenixcache/configuration"
+)
+
+// ReplicationMode indica cómo se replica una escritura
+type ReplicationMode string
+
+const (
+	ReplicationLocal ReplicationMode = "local" // Solo en este nodo, nunca se propaga ni se exporta
+	ReplicationAsync ReplicationMode = "async" // Se propaga en segundo plano (por defecto)
+	ReplicationSync  ReplicationMode = "sync"  // Se espera la confirmación de todos los peers activos (salvo observers)
+)
+
+// Política de replicación configurada (modo por defecto y reglas por prefijo)
+var replicationPolicy = configuration.ReplicationConfig{Default: string(ReplicationAsync)}
+
+// ResolveReplication decide el modo de replicación de una clave. El modo pedido en la
+// petición gana sobre la regla del prefijo, salvo que la regla esté fijada (pinned)
+func ResolveReplication(key string, requested string) (ReplicationMode, error) {
+	if requested != "" && !validReplication(requested) {
+		return "", fmt.Errorf("modo de replicación no válido: '%s' (local, async o sync)", requested)
+	}
+
+	// La regla con el prefijo más largo es la más específica
+	var rule *configuration.ReplicationRule
+	for i, candidate := range replicationPolicy.Rules {
+		if strings.HasPrefix(key, candidate.Prefix) && (rule == nil || len(candidate.Prefix) > len(rule.Prefix)) {
+			rule = &replicationPolicy.Rules[i]
+		}
+	}
+
+	switch {
+	case rule != nil && rule.Pinned:
+		if requested != "" && requested != rule.Mode {
+			return "", fmt.Errorf("las claves '%s*' se replican siempre en modo %s", rule.Prefix, rule.Mode)
+		}
+		return ReplicationMode(rule.Mode), nil
+	case requested != "":
+		return ReplicationMode(requested), nil
+	case rule != nil:
+		return ReplicationMode(rule.Mode), nil
+	default:
+		return ReplicationMode(replicationPolicy.Default), nil
+	}
+}
+
+func validReplication(mode string) bool {
+	switch ReplicationMode(mode) {
+	case ReplicationLocal, ReplicationAsync, ReplicationSync:
+		return true
+	}
+	return false
+}
+
+// Replicate propaga un cambio según el modo de replicación. Devuelve las
+// confirmaciones obtenidas y las necesarias (ambas 0 salvo en modo sync)
+func Replicate(msg SyncMessage, peerManager *PeerManager, mode ReplicationMode) (acks int, needed int) {
+	switch mode {
+	case ReplicationLocal:
+		return 0, 0
+	case ReplicationSync:
+		needed = len(peerManager.GetMemberPeers())
+		if needed == 0 {
+			// Sin peers activos no hay a quién esperar (los caídos reciben hints)
+			PropagateChange(msg, peerManager)
+			return 0, 0
+		}
+		return ReplicateQuorum(msg, peerManager, needed), needed
+	default:
+		PropagateChange(msg, peerManager)
+		return 0, 0
+	}
+}
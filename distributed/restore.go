@@ -0,0 +1,97 @@
+package distributed
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Espera máxima de un peer para sustituir su caché
+const replaceTimeout = 60 * time.Second
+
+// ReplaceCluster sustituye la caché de los peers activos por 'entries' (/replace), en
+// lugar de mezclarla por versión. 'replaceLocal' sustituye la del nodo local y
+// devuelve las claves cargadas. Los peers inactivos no se contactan: al volver se
+// recuperan como cualquier otro nodo
+func ReplaceCluster(peerManager *PeerManager, entries []internal.CacheEntry, replaceLocal func() int) ClusterReport {
+	peers, skipped := activeTargets(peerManager)
+
+	return fanOut(peers, skipped,
+		func(result *NodeResult) {
+			result.Keys = replaceLocal()
+		},
+		func(peer string, result *NodeResult) error {
+			body, err := encodeEntriesFor(peerManager, peer, entries)
+			if err != nil {
+				return err
+			}
+			keys, err := sendReplace(peer, body)
+			result.Keys = keys
+			return err
+		})
+}
+
+// sendReplace manda a un peer la caché que debe sustituir a la suya
+func sendReplace(peer string, body []byte) (int, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(peer + "/replace")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/octet-stream")
+	req.Header.Set(HeaderProtocol, strconv.Itoa(ProtocolVersion))
+	setNodeHeader(req)
+	req.SetBody(body)
+
+	if err := fasthttp.DoTimeout(req, resp, replaceTimeout); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return 0, fmt.Errorf("código de estado %d", resp.StatusCode())
+	}
+	keys, _ := strconv.Atoi(string(resp.Header.Peek("X-Phoenix-Keys")))
+	return keys, nil
+}
+
+// ReplaceCache vacía la caché y carga las entradas con una versión nueva en este nodo,
+// para que no pierdan frente a cambios más antiguos que aún lleguen de otros peers
+func ReplaceCache(cache *internal.Cache, entries []internal.CacheEntry, offset time.Duration) int {
+	internal.CacheMutex.Lock()
+	defer internal.CacheMutex.Unlock()
+
+	version := cache.NextVersion()
+	for i := range entries {
+		if entries[i].Version < version {
+			entries[i].Version = version
+		}
+	}
+
+	cache.FlushAll()
+	ApplyEntries(cache, entries, offset)
+	return cache.Len()
+}
+
+// HandleReplace sustituye la caché local por la que manda otro nodo (restauración
+// de un backup en todo el cluster)
+func HandleReplace(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	entries, err := DecodeEntries(ctx.PostBody())
+	if err != nil {
+		ctx.Error("❌ Error en el payload", fasthttp.StatusBadRequest)
+		return
+	}
+
+	peer := requestPeer(ctx)
+	keys := ReplaceCache(cache, entries, clockOffset(peer))
+	log.Printf("♻️ Caché sustituida por la de %s: %d claves", peer, keys)
+
+	SetProtocolHeaders(ctx)
+	ctx.Response.Header.Set("X-Phoenix-Keys", strconv.Itoa(keys))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
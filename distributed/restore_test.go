@@ -0,0 +1,30 @@
+package distributed
+
+import (
+	"testing"
+	"time"
+
+	"phoenixcache/internal"
+)
+
+func TestReplaceCacheRestampsRestoredKeys(t *testing.T) {
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.Set("k", "actual", time.Minute)
+	before := cache.Version("k")
+
+	expiresAt := time.Now().Add(time.Minute).UnixMilli()
+	ReplaceCache(cache, []internal.CacheEntry{{Key: "k", Value: "backup", ExpiresAt: expiresAt, Version: 1}}, 0)
+
+	value, _, version, found := cache.GetVersioned("k")
+	if !found || value != "backup" {
+		t.Fatalf("valor = %v, se esperaba el del backup", value)
+	}
+	if version <= before {
+		t.Fatalf("versión restaurada %d, debe ser posterior a %d", version, before)
+	}
+
+	// Un cambio anterior a la restauración que llega tarde de un peer ya no la pisa
+	if cache.SetVersioned("k", "tardío", time.UnixMilli(expiresAt), before) {
+		t.Fatalf("un cambio anterior a la restauración sustituyó la clave restaurada")
+	}
+}
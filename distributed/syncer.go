@@ -4,20 +4,50 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"phoenixcache/internal"
-	"phoenixcache/utils"
 
 	"github.com/valyala/fasthttp"
 )
 
-// Estructura de sincronización
+// Estructura de sincronización.
+// TTL se mantiene para nodos con protocolo 1; a partir del protocolo 2 manda
+// ExpiresAt (instante absoluto) y SentAt (hora de envío), ambos en milisegundos Unix
 type SyncMessage struct {
-	Action string        `json:"action"`
-	Key    string        `json:"key"`
-	Value  interface{}   `json:"value,omitempty"`
-	TTL    time.Duration `json:"ttl,omitempty"`
+	Action    string        `json:"action"`
+	Key       string        `json:"key"`
+	Value     interface{}   `json:"value,omitempty"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+	ExpiresAt int64         `json:"expires_at,omitempty"`
+	SentAt    int64         `json:"sent_at,omitempty"`
+	Protocol  int           `json:"protocol,omitempty"`
+	Version   uint64        `json:"version,omitempty"`
+	Origin    string        `json:"origin,omitempty"` // Región en la que se originó el cambio
+}
+
+// KeyValue es cada una de las claves que devuelve /getKeys. "expiration" es el
+// instante absoluto de expiración en el nodo remoto
+type KeyValue struct {
+	Value      interface{} `json:"value"`
+	Expiration time.Time   `json:"expiration"`
+	Version    uint64      `json:"version,omitempty"`
+}
+
+// SyncAck es la confirmación que devuelve /sync al aplicar un mensaje
+type SyncAck struct {
+	Status  string `json:"status"`
+	Applied bool   `json:"applied"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// Expiry calcula el instante local de expiración de un mensaje "set"
+func (msg SyncMessage) Expiry(peer string) time.Time {
+	if msg.Protocol >= 2 && msg.ExpiresAt > 0 {
+		return expiryFromMillis(msg.ExpiresAt, clockOffset(peer))
+	}
+	return time.Now().Add(msg.TTL)
 }
 
 // Propaga los cambios a los diferentes servidores asignados
@@ -26,120 +56,198 @@ func PropagateChange(msg SyncMessage, peerManager *PeerManager) {
 		return
 	}
 
-	data, _ := json.Marshal(msg)
-	for _, peer := range peerManager.GetActivePeers() {
-		go func(peer string) {
-			resp := fasthttp.AcquireResponse()
-			req := fasthttp.AcquireRequest()
-			defer fasthttp.ReleaseResponse(resp)
-			defer fasthttp.ReleaseRequest(req)
+	msg.Protocol = ProtocolVersion
+	msg.SentAt = nowMillis()
+
+	// Las regiones remotas reciben el cambio en lotes a través de su gateway
+	peerManager.Regions().Enqueue(msg)
 
-			req.SetRequestURI(peer + "/sync")
-			req.Header.SetMethod(fasthttp.MethodPost)
-			req.Header.SetContentType("application/json")
-			req.Header.Set("Connection", "close")
+	// Los peers caídos (o con hints pendientes) lo recibirán cuando vuelvan
+	peers, hinted := peerManager.syncTargets()
+	for _, peer := range hinted {
+		peerManager.Hints().Store(peer, msg)
+	}
 
-			req.SetBody(data) // <-- Usa data directamente en SetBody
+	// Los peers que no admiten lotes binarios reciben JSON por /sync
+	transport := peerManager.Transport()
+	var data []byte
+	for _, peer := range peers {
+		if transport != nil && peerManager.supports(peer, FeatureSyncBinary) {
+			transport.Send(peer, msg)
+			continue
+		}
 
-			if err := fasthttp.Do(req, resp); err != nil {
+		if data == nil {
+			data, _ = json.Marshal(msg)
+		}
+		go func(peer string) {
+			if _, err := sendSync(peer, data, 0); err != nil {
 				log.Printf("⚠️ Error sincronizando con %s: %v", peer, err)
+				peerManager.Hints().Store(peer, msg)
 			}
-
 		}(peer)
 	}
 }
 
-// Recuperamos la cache del primer servidor activo de caches
-func RecoverCacheFromPeer(peerManager *PeerManager, cache *internal.Cache) {
-
-	peer := peerManager.GetActivePeers()[0]
-	if peer == "" {
-		return
+// syncWith envía un mensaje a un peer y espera su confirmación, por el transporte
+// persistente si está activo y el peer lo admite o por /sync en otro caso
+func syncWith(peerManager *PeerManager, peer string, msg SyncMessage, data []byte, timeout time.Duration) (SyncAck, error) {
+	if transport := peerManager.Transport(); transport != nil && peerManager.supports(peer, FeatureSyncBinary) {
+		return transport.SendWait(peer, msg, timeout)
 	}
+	return sendSync(peer, data, timeout)
+}
 
-	url := fmt.Sprintf("%s/export", peer)
+// sendSync envía un mensaje ya serializado al /sync de un peer y devuelve su
+// confirmación. Los nodos antiguos contestan "Ok" sin versión: la entrega se da por
+// buena, pero no cuenta como aplicada
+func sendSync(peer string, data []byte, timeout time.Duration) (SyncAck, error) {
+	resp := fasthttp.AcquireResponse()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseResponse(resp)
+	defer fasthttp.ReleaseRequest(req)
 
-	statusCode, compressedData, err := fasthttp.Get(nil, url)
+	req.SetRequestURI(peer + "/sync")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	setNodeHeader(req)
 
-	if err != nil {
-		log.Printf("⚠️ Error al obtener la caché de %s: %s", url, err.Error())
-		return
-	}
+	req.SetBody(data) // <-- Usa data directamente en SetBody
 
-	if statusCode == 0 {
-		log.Printf("⚠️ Respuesta vacía o conexión rechazada de %s", peer)
-		return
+	var err error
+	if timeout > 0 {
+		err = fasthttp.DoTimeout(req, resp, timeout)
+	} else {
+		err = fasthttp.Do(req, resp)
 	}
-
-	if statusCode == fasthttp.StatusNoContent {
-		log.Printf("⚠️ No hay caché de %s", peer)
-		return
+	if err != nil {
+		return SyncAck{}, err
 	}
-	if statusCode != fasthttp.StatusOK {
-		log.Printf("⚠️ No se pudo recuperar la caché de %s", peer)
-		return
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return SyncAck{}, fmt.Errorf("código de estado %d", resp.StatusCode())
 	}
 
-	decompressedData, err := utils.DecompressData(compressedData)
-	if err != nil {
-		log.Printf("⚠️ Error al descomprimir datos de %s: %v", peer, err)
-		return
+	var ack SyncAck
+	if json.Unmarshal(resp.Body(), &ack) != nil {
+		return SyncAck{Status: "ok"}, nil
 	}
+	return ack, nil
+}
 
-	var recoveredCache []internal.CacheEntry
+// RecoverCacheFromPeer importa la caché completa del peer más actualizado, probando
+// con los siguientes si falla. La caché local solo se sustituye si la importación
+// termina bien
+func RecoverCacheFromPeer(peerManager *PeerManager, cache *internal.Cache) error {
+	for _, candidate := range rankSources(ProbePeers(peerManager)) {
+		if err := RecoverCacheFrom(peerManager, candidate.Peer, cache); err == nil {
+			return nil
+		}
+	}
+	return ErrNoRecoverySource
+}
 
-	err = json.Unmarshal(decompressedData, &recoveredCache)
+// RecoverCacheFrom importa la caché completa de un peer concreto, sustituyendo la local
+func RecoverCacheFrom(peerManager *PeerManager, peer string, cache *internal.Cache) error {
+	entries, offset, err := fetchExport(peerManager, peer)
 	if err != nil {
-		log.Printf("⚠️ Error al parsear JSON de %s: %v", peer, err)
-		return
+		log.Printf("⚠️ No se pudo recuperar la caché de %s: %v", peer, err)
+		return err
+	}
+	if entries == nil {
+		log.Printf("⚠️ No hay caché de %s", peer)
+		return nil
 	}
 
 	// Bloqueamos el acceso a la caché antes de modificarla
 	internal.CacheMutex.Lock()
 	defer internal.CacheMutex.Unlock()
 
-	// Limpiar la caché antes de poblarla con la nueva data
-	cache.FlushAll()
+	// Limpiar la caché antes de poblarla con la nueva data. Las claves solo locales no
+	// están en la del peer y se conservan
+	cache.FlushReplicated()
+	ApplyEntries(cache, entries, offset)
 
-	for _, entry := range recoveredCache {
-		duration, err := time.ParseDuration(entry.ExpiresIn)
-		if err != nil {
-			log.Printf("⚠️ Error al parsear duración para clave %s: %v", entry.Key, err)
-			continue
-		}
-		cache.Set(entry.Key, entry.Value, duration)
+	log.Println("✅ Caché recuperada con éxito desde", peer)
+	return nil
+}
+
+// fetchExport descarga y decodifica la exportación de un peer
+func fetchExport(peerManager *PeerManager, peer string) ([]internal.CacheEntry, time.Duration, error) {
+	url := fmt.Sprintf("%s/export", peer)
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.Set(HeaderProtocol, strconv.Itoa(ProtocolVersion))
+	setNodeHeader(req)
+
+	sent := time.Now()
+	if err := fasthttp.Do(req, resp); err != nil {
+		return nil, 0, err
+	}
+	received := time.Now()
+
+	switch resp.StatusCode() {
+	case fasthttp.StatusOK:
+	case fasthttp.StatusNoContent:
+		// El peer no tiene nada que exportar
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("código de estado %d", resp.StatusCode())
 	}
 
-	log.Println("✅ Caché recuperada con éxito desde", peer)
+	offset := responseOffset(resp, peer, sent, received)
+	entries, err := DecodeEntries(resp.Body())
+	if err != nil {
+		return nil, 0, err
+	}
+	peerManager.entryFormat(peer).normalize(entries)
+	return entries, offset, nil
 }
 
-func RecoverCacheDiff(peerManager *PeerManager, cache *internal.Cache) {
-	peer := peerManager.GetActivePeers()[0]
-	if peer == "" {
-		return
+// RecoverCacheDiff sincroniza la caché local con el diff del peer más actualizado,
+// probando con los siguientes si falla
+func RecoverCacheDiff(peerManager *PeerManager, cache *internal.Cache) error {
+	for _, candidate := range rankSources(ProbePeers(peerManager)) {
+		if err := RecoverCacheDiffFrom(peerManager, candidate.Peer, cache); err == nil {
+			return nil
+		}
 	}
+	return ErrNoRecoverySource
+}
 
+// RecoverCacheDiffFrom sincroniza la caché local con la de un peer concreto
+func RecoverCacheDiffFrom(peerManager *PeerManager, peer string, cache *internal.Cache) error {
 	url := fmt.Sprintf("%s/diff", peer)
 	statusCode, diffData, err := fasthttp.Get(nil, url)
-
-	if err != nil || statusCode != fasthttp.StatusOK {
+	if err == nil && statusCode != fasthttp.StatusOK {
+		err = fmt.Errorf("código de estado %d", statusCode)
+	}
+	if err != nil {
 		log.Printf("⚠️ No se pudo recuperar el diff de %s: %v", peer, err)
-		return
+		return err
 	}
 
 	var remoteDiff map[string]int64
 	err = json.Unmarshal(diffData, &remoteDiff)
 	if err != nil {
 		log.Printf("⚠️ Error al parsear JSON del diff de %s: %v", peer, err)
-		return
+		return err
 	}
 
 	// Obtener diff local
 	localDiff := cache.GetDiff()
 
+	// Las expiraciones del peer están en su reloj
+	skew := int64(clockOffset(peer) / time.Second)
+
 	// Detectar claves desactualizadas o faltantes
 	var missingKeys []string
 	for key, remoteExp := range remoteDiff {
+		remoteExp += skew
 		localExp, exists := localDiff[key]
 
 		if !exists || remoteExp > localExp {
@@ -147,57 +255,79 @@ func RecoverCacheDiff(peerManager *PeerManager, cache *internal.Cache) {
 		}
 	}
 
-	// Detectar claves expiradas localmente que ya no existen en el peer
-	for key := range localDiff {
-		if _, exists := remoteDiff[key]; !exists {
-			cache.RemoveKey(key) // Eliminar claves expiradas
-		}
-	}
+	// Eliminar las claves que ya no existen en el peer (salvo las de Raft)
+	cache.RemoveMissing(remoteDiff)
 
 	// Si hay claves desactualizadas, pedir sus valores
 	if len(missingKeys) > 0 {
-		FetchAndUpdateKeys(peer, cache, missingKeys)
+		return FetchAndUpdateKeys(peerManager, peer, cache, missingKeys)
 	}
+	return nil
 }
 
-func FetchAndUpdateKeys(peer string, cache *internal.Cache, keys []string) {
+// FetchAndUpdateKeys trae de un peer los valores de las claves indicadas. Si el peer
+// no tiene versiones por clave, cada una recibe una versión local nueva
+func FetchAndUpdateKeys(peerManager *PeerManager, peer string, cache *internal.Cache, keys []string) error {
+	recoveredData, offset, err := FetchKeys(peer, keys, 0)
+	if err != nil {
+		log.Printf("⚠️ No se pudo recuperar claves de %s: %v", peer, err)
+		return err
+	}
+	format := peerManager.entryFormat(peer)
+
+	internal.CacheMutex.Lock()
+	defer internal.CacheMutex.Unlock()
+
+	for key, entry := range recoveredData {
+		if cache.IsProtected(key) {
+			continue
+		}
+		if !format.versions {
+			entry.Version = 0
+		}
+		cache.SetVersioned(key, entry.Value, entry.Expiration.Add(offset), entry.Version)
+	}
+
+	log.Println("✅ Claves sincronizadas desde", peer)
+	return nil
+}
+
+// FetchKeys obtiene de un peer los valores de las claves indicadas (/getKeys) junto
+// con el desfase de reloj con el que hay que interpretar sus expiraciones
+func FetchKeys(peer string, keys []string, timeout time.Duration) (map[string]KeyValue, time.Duration, error) {
 	url := fmt.Sprintf("%s/getKeys", peer)
 	body, _ := json.Marshal(keys)
 
 	req := fasthttp.AcquireRequest()
 	req.SetRequestURI(url)
 	req.Header.SetMethod("POST")
+	req.Header.Set(HeaderProtocol, strconv.Itoa(ProtocolVersion))
 	req.SetBody(body)
 
 	resp := fasthttp.AcquireResponse()
-	err := fasthttp.Do(req, resp)
+	var err error
+	sent := time.Now()
+	if timeout > 0 {
+		err = fasthttp.DoTimeout(req, resp, timeout)
+	} else {
+		err = fasthttp.Do(req, resp)
+	}
+	received := time.Now()
 
 	fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 
-	if err != nil || resp.StatusCode() != fasthttp.StatusOK {
-		log.Printf("⚠️ No se pudo recuperar claves de %s: %v", peer, err)
-		return
-	}
-
-	var recoveredData map[string]struct {
-		Value      interface{} `json:"value"`
-		Expiration int64       `json:"expiration"`
-	}
-
-	err = json.Unmarshal(resp.Body(), &recoveredData)
 	if err != nil {
-		log.Printf("⚠️ Error al parsear claves de %s: %v", peer, err)
-		return
+		return nil, 0, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, 0, fmt.Errorf("código de estado %d", resp.StatusCode())
 	}
 
-	internal.CacheMutex.Lock()
-	defer internal.CacheMutex.Unlock()
-
-	for key, entry := range recoveredData {
-		timeTtl := time.Duration(entry.Expiration) * time.Second
-		cache.Set(key, entry.Value, timeTtl)
+	var recoveredData map[string]KeyValue
+	if err := json.Unmarshal(resp.Body(), &recoveredData); err != nil {
+		return nil, 0, err
 	}
 
-	log.Println("✅ Claves sincronizadas desde", peer)
+	return recoveredData, responseOffset(resp, peer, sent, received), nil
 }
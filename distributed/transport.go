@@ -0,0 +1,495 @@
+package distributed
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"phoenixcache/configuration"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Tiempo que un peer se trata como antiguo (solo /sync en JSON) antes de volver a probar /sync_bin
+const legacyRetryInterval = time.Minute
+
+// Mensajes que se pueden encolar por peer; con la cola llena los cambios pasan a hints
+const senderQueueSize = 10000
+
+var errConnClosed = errors.New("conexión cerrada")
+
+// Transport mantiene una conexión persistente por peer por la que se envían los
+// cambios agrupados en lotes binarios y en pipeline (sin esperar a cada respuesta)
+type Transport struct {
+	peerManager   *PeerManager
+	mu            sync.Mutex
+	senders       map[string]*peerSender
+	batchSize     int
+	flushInterval time.Duration
+	depth         int
+	timeout       time.Duration
+}
+
+// outbound es un mensaje pendiente de envío; done recibe la confirmación (si se espera)
+type outbound struct {
+	msg  SyncMessage
+	done chan outboundResult
+}
+
+type outboundResult struct {
+	ack SyncAck
+	err error
+}
+
+// NewTransport crea el transporte entre nodos y lo asocia al PeerManager
+func NewTransport(config *configuration.Config, peerManager *PeerManager) *Transport {
+	t := &Transport{
+		peerManager:   peerManager,
+		senders:       make(map[string]*peerSender),
+		batchSize:     config.SyncBatchSize,
+		flushInterval: time.Duration(config.SyncFlushInterval) * time.Millisecond,
+		depth:         config.SyncPipelineDepth,
+		timeout:       10 * time.Second,
+	}
+	peerManager.transport = t
+	return t
+}
+
+// Send encola un mensaje para un peer sin esperar su confirmación. Nunca bloquea:
+// si el peer no da abasto y su cola está llena, el cambio se guarda como hint (o se
+// descarta sin hinted handoff) y lo recuperará la reconciliación
+func (t *Transport) Send(peer string, msg SyncMessage) {
+	s := t.sender(peer)
+	select {
+	case s.queue <- outbound{msg: msg}:
+		s.overflowing.Store(false)
+	default:
+		if !s.overflowing.Swap(true) {
+			log.Printf("⚠️ Cola de envío a %s llena, los cambios pasan a hints", peer)
+		}
+		t.peerManager.Hints().Store(peer, msg)
+	}
+}
+
+// SendWait envía un mensaje y espera la confirmación del peer
+func (t *Transport) SendWait(peer string, msg SyncMessage, timeout time.Duration) (SyncAck, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	done := make(chan outboundResult, 1)
+	select {
+	case t.sender(peer).queue <- outbound{msg: msg, done: done}:
+	case <-deadline.C:
+		return SyncAck{}, fmt.Errorf("cola de envío a %s llena", peer)
+	}
+
+	select {
+	case result := <-done:
+		return result.ack, result.err
+	case <-deadline.C:
+		return SyncAck{}, fmt.Errorf("tiempo de espera agotado con %s", peer)
+	}
+}
+
+// Forget detiene el envío a un peer eliminado y cierra su conexión
+func (t *Transport) Forget(peer string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	s, ok := t.senders[peer]
+	delete(t.senders, peer)
+	t.mu.Unlock()
+
+	if ok {
+		close(s.stop)
+	}
+}
+
+func (t *Transport) sender(peer string) *peerSender {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.senders[peer]
+	if !ok {
+		s = &peerSender{
+			peer:      peer,
+			transport: t,
+			queue:     make(chan outbound, senderQueueSize),
+			retry:     make(chan struct{}, 1),
+			stop:      make(chan struct{}),
+		}
+		t.senders[peer] = s
+		go s.run()
+	}
+	return s
+}
+
+//********************************************************************
+// Envío por peer
+//********************************************************************
+
+type peerSender struct {
+	peer        string
+	transport   *Transport
+	queue       chan outbound
+	retry       chan struct{} // Avisa de que hay lotes en retries
+	stop        chan struct{}
+	overflowing atomic.Bool
+
+	mu          sync.Mutex
+	conn        *pipelineConn
+	legacyUntil time.Time
+	retries     [][]outbound // Lotes que se reenvían por /sync desde run
+}
+
+// run agrupa los mensajes encolados en lotes de hasta batchSize o flushInterval.
+// Todos los envíos, también los reintentos por /sync, salen de esta goroutine, así
+// que un peer lento frena su cola en vez de acumular goroutines
+func (s *peerSender) run() {
+	defer s.shutdown()
+
+	for {
+		var first outbound
+		select {
+		case <-s.stop:
+			return
+		case <-s.retry:
+			s.sendRetries()
+			continue
+		case first = <-s.queue:
+		}
+
+		batch := []outbound{first}
+		timer := time.NewTimer(s.transport.flushInterval)
+
+	collect:
+		for len(batch) < s.transport.batchSize {
+			select {
+			case next := <-s.queue:
+				batch = append(batch, next)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		s.dispatch(batch)
+	}
+}
+
+func (s *peerSender) dispatch(batch []outbound) {
+	s.mu.Lock()
+	legacy := time.Now().Before(s.legacyUntil)
+	s.mu.Unlock()
+
+	if legacy {
+		s.sendLegacy(batch)
+		return
+	}
+
+	conn, err := s.connection()
+	if err == nil {
+		err = conn.write(s.peer, batch)
+	}
+	if err != nil {
+		// Sin conexión persistente, reintentamos por HTTP normal
+		s.sendLegacy(batch)
+	}
+}
+
+// requeue pasa un lote a run para reenviarlo por /sync. Se llama desde el lector de
+// la conexión, que no puede bloquearse esperando a run
+func (s *peerSender) requeue(batch []outbound) {
+	s.mu.Lock()
+	s.retries = append(s.retries, batch)
+	s.mu.Unlock()
+
+	select {
+	case s.retry <- struct{}{}:
+	default:
+	}
+}
+
+func (s *peerSender) sendRetries() {
+	s.mu.Lock()
+	retries := s.retries
+	s.retries = nil
+	s.mu.Unlock()
+
+	for _, batch := range retries {
+		s.sendLegacy(batch)
+	}
+}
+
+// shutdown cierra la conexión de un peer eliminado. Lo que quedaba pendiente se
+// descarta, igual que sus hints
+func (s *peerSender) shutdown() {
+	s.mu.Lock()
+	conn := s.conn
+	s.retries = nil
+	s.mu.Unlock()
+
+	if conn != nil {
+		conn.close()
+	}
+}
+
+// connection devuelve la conexión persistente con el peer, abriéndola si hace falta
+func (s *peerSender) connection() (*pipelineConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil && !s.conn.isClosed() {
+		return s.conn, nil
+	}
+
+	conn, err := dialPipeline(s, s.transport.depth, s.transport.timeout)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// onResponse reparte las confirmaciones de un lote
+func (s *peerSender) onResponse(batch []outbound, resp *fasthttp.Response) {
+	if resp.StatusCode() == fasthttp.StatusNotFound {
+		// Nodo antiguo sin /sync_bin: usamos /sync en JSON durante un tiempo
+		s.mu.Lock()
+		s.legacyUntil = time.Now().Add(legacyRetryInterval)
+		s.mu.Unlock()
+		log.Printf("ℹ️ %s no soporta el transporte binario, se usa /sync", s.peer)
+		s.requeue(batch)
+		return
+	}
+
+	if resp.StatusCode() != fasthttp.StatusOK {
+		s.complete(batch, nil, fmt.Errorf("código de estado %d", resp.StatusCode()))
+		return
+	}
+
+	acks, err := DecodeAcks(resp.Body())
+	if err == nil && len(acks) != len(batch) {
+		err = errInvalidFrame
+	}
+	s.complete(batch, acks, err)
+}
+
+// onFailure reintenta por HTTP normal un lote que no obtuvo respuesta. Que el peer
+// cierre la conexión (p.e. por max_requests_per_conn) es normal y no se registra
+func (s *peerSender) onFailure(batch []outbound, err error) {
+	if err != errConnClosed {
+		log.Printf("⚠️ Conexión con %s interrumpida (%v), reenviando %d cambios", s.peer, err, len(batch))
+	}
+	s.requeue(batch)
+}
+
+// sendLegacy envía los mensajes uno a uno por /sync en JSON
+func (s *peerSender) sendLegacy(batch []outbound) {
+	for _, out := range batch {
+		data, _ := json.Marshal(out.msg)
+		ack, err := sendSync(s.peer, data, s.transport.timeout)
+		if err != nil && out.done == nil {
+			log.Printf("⚠️ Error sincronizando con %s: %v", s.peer, err)
+			s.transport.peerManager.Hints().Store(s.peer, out.msg)
+		}
+		if out.done != nil {
+			out.done <- outboundResult{ack: ack, err: err}
+		}
+	}
+}
+
+// complete reparte el resultado de un lote. Si el peer no lo confirmó, quien espera
+// recibe el error y los mensajes sin espera (Send) pasan a hints, como en sendLegacy
+func (s *peerSender) complete(batch []outbound, acks []SyncAck, err error) {
+	if err != nil {
+		log.Printf("⚠️ Error sincronizando con %s: %v", s.peer, err)
+	}
+	for i, out := range batch {
+		switch {
+		case out.done == nil && err != nil:
+			s.transport.peerManager.Hints().Store(s.peer, out.msg)
+		case out.done == nil:
+		case err != nil:
+			out.done <- outboundResult{err: err}
+		default:
+			out.done <- outboundResult{ack: acks[i]}
+		}
+	}
+}
+
+//********************************************************************
+// Conexión persistente con pipeline
+//********************************************************************
+
+// pipelineConn escribe las peticiones sin esperar respuesta y un lector las
+// empareja en orden con las respuestas (HTTP pipelining sobre una conexión)
+type pipelineConn struct {
+	sender  *peerSender
+	conn    net.Conn
+	bw      *bufio.Writer
+	br      *bufio.Reader
+	timeout time.Duration
+
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{} // Se cierra con la conexión para que termine readLoop
+	slots   chan struct{}
+	pending chan []outbound
+}
+
+func dialPipeline(sender *peerSender, depth int, timeout time.Duration) (*pipelineConn, error) {
+	u, err := url.Parse(sender.peer)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+
+	var conn net.Conn
+	if u.Scheme == "https" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = net.DialTimeout("tcp", host, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &pipelineConn{
+		sender:  sender,
+		conn:    conn,
+		bw:      bufio.NewWriter(conn),
+		br:      bufio.NewReader(conn),
+		timeout: timeout,
+		done:    make(chan struct{}),
+		slots:   make(chan struct{}, depth),
+		pending: make(chan []outbound, depth),
+	}
+	go pc.readLoop()
+	return pc, nil
+}
+
+// write envía un lote por la conexión; bloquea si hay demasiados lotes sin respuesta
+func (pc *pipelineConn) write(peer string, batch []outbound) error {
+	pc.slots <- struct{}{}
+
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		<-pc.slots
+		return errConnClosed
+	}
+
+	msgs := make([]SyncMessage, len(batch))
+	for i, out := range batch {
+		msgs[i] = out.msg
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI(peer + "/sync_bin")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType(ContentTypeSyncFrame)
+	setNodeHeader(req)
+	req.SetBody(EncodeFrame(msgs))
+
+	_ = pc.conn.SetWriteDeadline(time.Now().Add(pc.timeout))
+	err := req.Write(pc.bw)
+	if err == nil {
+		err = pc.bw.Flush()
+	}
+	fasthttp.ReleaseRequest(req)
+
+	if err != nil {
+		pc.mu.Unlock()
+		<-pc.slots
+		pc.close()
+		return err
+	}
+
+	pc.pending <- batch
+	pc.mu.Unlock()
+	return nil
+}
+
+// readLoop lee las respuestas en el mismo orden en que se enviaron las peticiones
+func (pc *pipelineConn) readLoop() {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	for {
+		var batch []outbound
+		select {
+		case batch = <-pc.pending:
+		case <-pc.done:
+			// Cerrada sin esperar respuestas (fallo al escribir o peer eliminado)
+			pc.drain(errConnClosed)
+			return
+		}
+
+		resp.Reset()
+		_ = pc.conn.SetReadDeadline(time.Now().Add(pc.timeout))
+		err := resp.Read(pc.br)
+		<-pc.slots
+
+		if err != nil {
+			pc.close()
+			pc.sender.onFailure(batch, err)
+			pc.drain(err)
+			return
+		}
+
+		pc.sender.onResponse(batch, resp)
+
+		if resp.ConnectionClose() {
+			pc.close()
+			pc.drain(errConnClosed)
+			return
+		}
+	}
+}
+
+func (pc *pipelineConn) isClosed() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.closed
+}
+
+func (pc *pipelineConn) close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if !pc.closed {
+		pc.closed = true
+		pc.conn.Close()
+		close(pc.done)
+	}
+}
+
+// drain reintenta los lotes que quedaban sin respuesta al cerrarse la conexión
+func (pc *pipelineConn) drain(err error) {
+	for {
+		select {
+		case batch := <-pc.pending:
+			<-pc.slots
+			pc.sender.onFailure(batch, err)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package distributed
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"phoenixcache/configuration"
+)
+
+func TestSendStoresHintsWhenPeerFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	pm := &PeerManager{peers: map[string]int{server.URL: 0}, protocols: make(map[string]PeerProtocol), maxFailures: 3}
+	pm.hints = &HintStore{maxHints: 10000, maxAge: time.Hour, queues: make(map[string]*hintQueue)}
+	transport := NewTransport(&configuration.Config{SyncBatchSize: 16, SyncFlushInterval: 1, SyncPipelineDepth: 4}, pm)
+	defer transport.Forget(server.URL)
+
+	transport.Send(server.URL, SyncMessage{Action: "remove", Key: "k", Protocol: ProtocolVersion})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !pm.hints.Pending(server.URL) {
+		if time.Now().After(deadline) {
+			t.Fatalf("el cambio rechazado con 500 no pasó a hints")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPipelineReaderStopsWhenConnectionCloses(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	pc := &pipelineConn{
+		sender:  &peerSender{peer: "http://peer:8080"},
+		conn:    local,
+		bw:      bufio.NewWriter(local),
+		br:      bufio.NewReader(local),
+		timeout: time.Second,
+		done:    make(chan struct{}),
+		slots:   make(chan struct{}, 1),
+		pending: make(chan []outbound, 1),
+	}
+	exited := make(chan struct{})
+	go func() {
+		pc.readLoop()
+		close(exited)
+	}()
+
+	// Sin nada en vuelo, el lector debe terminar al cerrar la conexión
+	pc.close()
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("readLoop sigue esperando tras cerrar la conexión")
+	}
+}
@@ -0,0 +1,385 @@
+package distributed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Espera máxima para descargar la lista de claves de 'keys_url'
+const warmupListTimeout = 5 * time.Second
+
+// WarmupLoader carga una clave de fuera del cluster (p.e. su origen). Devuelve
+// found=false si no la tiene
+type WarmupLoader func(key string) (value []byte, ttl time.Duration, found bool, err error)
+
+// WarmupStatus es el informe de la precarga de arranque
+type WarmupStatus struct {
+	State      string   `json:"state"`    // disabled, running, done, timeout, failed
+	Listed     int      `json:"listed"`   // Entradas de la lista (claves y prefijos)
+	Prefixes   int      `json:"prefixes"` // Prefijos de la lista
+	Requested  int      `json:"requested"`
+	FromPeers  int      `json:"from_peers"`
+	FromOrigin int      `json:"from_origin"`
+	Missing    int      `json:"missing"` // Claves que no tenía nadie
+	Sources    []string `json:"sources,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	StartedAt  string   `json:"started_at,omitempty"`
+	FinishedAt string   `json:"finished_at,omitempty"`
+}
+
+// ReadinessStatus indica si el nodo atiende ya a los clientes
+type ReadinessStatus struct {
+	Ready      bool          `json:"ready"`
+	Stage      string        `json:"stage"` // starting, warmup, bootstrap, ready
+	ReadySince string        `json:"ready_since,omitempty"`
+	Warmup     *WarmupStatus `json:"warmup,omitempty"`
+	Bootstrap  string        `json:"bootstrap"`
+}
+
+var (
+	warmupMu     sync.Mutex
+	warmupStatus = WarmupStatus{State: "disabled"}
+
+	ready      atomic.Bool
+	readyMu    sync.Mutex
+	readyStage = "starting"
+	readySince time.Time
+)
+
+// Warmup precarga las claves de la lista antes de atender a los clientes: las pide por
+// /getKeys a los peers, del más al menos actualizado, y las que no tenga ninguno las
+// carga con 'loader'. Los prefijos se resuelven con el /keys de los peers. Nunca tarda
+// más de 'timeout_in_seconds'; lo que no dé tiempo a cargar llegará con la
+// recuperación normal
+func Warmup(config *configuration.Config, peerManager *PeerManager, cache *internal.Cache, loader WarmupLoader) {
+	SetStage("warmup")
+	setWarmup(func(s *WarmupStatus) {
+		s.State = "running"
+		s.StartedAt = time.Now().Format(time.RFC3339)
+	})
+	deadline := time.Now().Add(time.Duration(config.Warmup.Timeout) * time.Second)
+
+	keys, prefixes, err := readWarmupList(config.Warmup)
+	if err != nil {
+		finishWarmup("failed", err)
+		log.Printf("⚠️ No se pudo leer la lista del warm-up: %v", err)
+		return
+	}
+	setWarmup(func(s *WarmupStatus) {
+		s.Listed = len(keys) + len(prefixes)
+		s.Prefixes = len(prefixes)
+	})
+	log.Printf("🔥 Warm-up de %d claves y %d prefijos", len(keys), len(prefixes))
+
+	var sources []string
+	if len(peerManager.GetPeers()) > 0 {
+		for _, candidate := range rankSources(ProbePeers(peerManager)) {
+			if candidate.Info.Keys > 0 {
+				sources = append(sources, candidate.Peer)
+			}
+		}
+	}
+
+	if len(prefixes) > 0 {
+		keys = append(keys, expandPrefixes(sources, prefixes, deadline)...)
+	}
+	pending := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		pending[key] = struct{}{}
+	}
+	setWarmup(func(s *WarmupStatus) { s.Requested = len(pending) })
+
+	for _, peer := range sources {
+		if len(pending) == 0 || time.Now().After(deadline) {
+			break
+		}
+		loaded := warmFromPeer(peer, cache, pending, config.Warmup.BatchSize, deadline)
+		if loaded > 0 {
+			setWarmup(func(s *WarmupStatus) {
+				s.FromPeers += loaded
+				s.Sources = append(s.Sources, peer)
+			})
+		}
+	}
+
+	// Una carga lenta del origen no retrasa el arranque más allá del límite
+	if loader != nil && len(pending) > 0 {
+		done := make(chan []string, 1)
+		go func(keys []string) { done <- warmFromLoader(loader, cache, keys, deadline) }(sortedKeys(pending))
+		select {
+		case loaded := <-done:
+			for _, key := range loaded {
+				delete(pending, key)
+			}
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+
+	status := GetWarmupStatus()
+	if time.Now().After(deadline) {
+		finishWarmup("timeout", nil)
+		log.Printf("⏱️ Warm-up interrumpido tras %ds: %d/%d claves cargadas", config.Warmup.Timeout, status.FromPeers+status.FromOrigin, status.Requested)
+		return
+	}
+	setWarmup(func(s *WarmupStatus) { s.Missing = len(pending) })
+	finishWarmup("done", nil)
+	log.Printf("✅ Warm-up terminado: %d claves de los peers, %d del origen y %d sin encontrar", status.FromPeers, status.FromOrigin, len(pending))
+}
+
+// warmFromPeer pide al peer las claves pendientes por lotes y quita de 'pending' las
+// que devuelve. Devuelve cuántas se cargaron
+func warmFromPeer(peer string, cache *internal.Cache, pending map[string]struct{}, batchSize int, deadline time.Time) int {
+	keys := sortedKeys(pending)
+	loaded := 0
+	for start := 0; start < len(keys); start += batchSize {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		batch := keys[start:min(start+batchSize, len(keys))]
+		values, offset, err := FetchKeys(peer, batch, remaining)
+		if err != nil {
+			log.Printf("⚠️ Warm-up: %s no devolvió las claves: %v", peer, err)
+			break
+		}
+
+		internal.CacheMutex.Lock()
+		for key, entry := range values {
+			cache.SetVersioned(key, entry.Value, entry.Expiration.Add(offset), entry.Version)
+			delete(pending, key)
+		}
+		internal.CacheMutex.Unlock()
+		loaded += len(values)
+	}
+	return loaded
+}
+
+// warmFromLoader carga las claves con 'loader' hasta que se acaba el tiempo y devuelve
+// las que se cargaron
+func warmFromLoader(loader WarmupLoader, cache *internal.Cache, keys []string, deadline time.Time) []string {
+	var loaded []string
+	for _, key := range keys {
+		if time.Now().After(deadline) {
+			break
+		}
+		value, ttl, found, err := loader(key)
+		if err != nil {
+			log.Printf("⚠️ Warm-up: no se pudo cargar %s del origen: %v", key, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		internal.CacheMutex.Lock()
+		cache.SetUntil(key, string(value), time.Now().Add(ttl))
+		internal.CacheMutex.Unlock()
+		loaded = append(loaded, key)
+		setWarmup(func(s *WarmupStatus) { s.FromOrigin++ })
+	}
+	return loaded
+}
+
+// expandPrefixes devuelve las claves con los prefijos que tiene el primer peer que responde
+func expandPrefixes(sources []string, prefixes []string, deadline time.Time) []string {
+	for _, peer := range sources {
+		var keys []string
+		err := func() error {
+			for _, prefix := range prefixes {
+				remaining := time.Until(deadline)
+				if remaining <= 0 {
+					return errors.New("tiempo agotado")
+				}
+				status, body, err := getBody(peer+"/keys?pattern="+url.QueryEscape(prefix), remaining)
+				if err != nil {
+					return err
+				}
+				if status != fasthttp.StatusOK {
+					return fmt.Errorf("código de estado %d", status)
+				}
+				var matches []string
+				if err := json.Unmarshal(body, &matches); err != nil {
+					return err
+				}
+				// /keys busca el patrón en cualquier parte de la clave
+				for _, key := range matches {
+					if strings.HasPrefix(key, prefix) {
+						keys = append(keys, key)
+					}
+				}
+			}
+			return nil
+		}()
+		if err == nil {
+			return keys
+		}
+		log.Printf("⚠️ Warm-up: %s no pudo resolver los prefijos: %v", peer, err)
+	}
+	return nil
+}
+
+// readWarmupList lee la lista de 'keys_file' y de 'keys_url' y separa las claves de
+// los prefijos
+func readWarmupList(config configuration.WarmupConfig) ([]string, []string, error) {
+	var entries []string
+	if config.KeysFile != "" {
+		data, err := os.ReadFile(config.KeysFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, parseWarmupList(data)...)
+	}
+	if config.KeysURL != "" {
+		status, body, err := getBody(config.KeysURL, warmupListTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", config.KeysURL, err)
+		}
+		if status != fasthttp.StatusOK {
+			return nil, nil, fmt.Errorf("%s: código de estado %d", config.KeysURL, status)
+		}
+		entries = append(entries, parseWarmupList(body)...)
+	}
+
+	seen := make(map[string]bool, len(entries))
+	var keys, prefixes []string
+	for _, entry := range entries {
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			prefixes = append(prefixes, prefix)
+		} else {
+			keys = append(keys, entry)
+		}
+	}
+	return keys, prefixes, nil
+}
+
+// parseWarmupList acepta un array JSON o una entrada por línea (con comentarios '#')
+func parseWarmupList(data []byte) []string {
+	var entries []string
+	if json.Unmarshal(data, &entries) == nil {
+		return entries
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func setWarmup(update func(*WarmupStatus)) {
+	warmupMu.Lock()
+	defer warmupMu.Unlock()
+	update(&warmupStatus)
+}
+
+func finishWarmup(state string, err error) {
+	setWarmup(func(s *WarmupStatus) {
+		s.State = state
+		if err != nil {
+			s.Error = err.Error()
+		}
+		s.FinishedAt = time.Now().Format(time.RFC3339)
+	})
+}
+
+// GetWarmupStatus devuelve el informe de la precarga de arranque
+func GetWarmupStatus() WarmupStatus {
+	warmupMu.Lock()
+	defer warmupMu.Unlock()
+	return warmupStatus
+}
+
+// SetStage deja constancia de la fase del arranque en la que está el nodo
+func SetStage(stage string) {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	if !ready.Load() {
+		readyStage = stage
+	}
+}
+
+// MarkReady indica que el nodo ya puede atender a los clientes
+func MarkReady() {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	if ready.Load() {
+		return
+	}
+	readyStage = "ready"
+	readySince = time.Now()
+	ready.Store(true)
+	log.Printf("🟢 Nodo listo para atender a los clientes")
+}
+
+// IsReady indica si el nodo atiende ya a los clientes
+func IsReady() bool {
+	return ready.Load()
+}
+
+// GetReadiness devuelve el estado del arranque del nodo
+func GetReadiness() ReadinessStatus {
+	readyMu.Lock()
+	status := ReadinessStatus{Ready: ready.Load(), Stage: readyStage}
+	if !readySince.IsZero() {
+		status.ReadySince = readySince.Format(time.RFC3339)
+	}
+	readyMu.Unlock()
+
+	if warmup := GetWarmupStatus(); warmup.State != "disabled" {
+		status.Warmup = &warmup
+	}
+	status.Bootstrap = GetBootstrapStatus().State
+	return status
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleReady responde 200 cuando el nodo atiende a los clientes y 503 mientras arranca
+func HandleReady(ctx *fasthttp.RequestCtx) {
+	status := GetReadiness()
+	data, _ := json.Marshal(status)
+	if status.Ready {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	} else {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+// HandleWarmupStatus devuelve el informe de la precarga de arranque
+func HandleWarmupStatus(ctx *fasthttp.RequestCtx) {
+	data, _ := json.Marshal(GetWarmupStatus())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"log"
 	"phoenixcache/utils"
 	"strings"
 	"sync"
@@ -14,28 +15,237 @@ import (
 type Cache struct {
 	store      *ristretto.Cache
 	expiration sync.Map
+	versions   sync.Map   // Versión (reloj de escritura) de cada clave
+	local      sync.Map   // Claves solo locales: no se replican ni se exportan
+	writeMu    sync.Mutex // Serializa las escrituras (y la comparación de versiones)
+	log        MutationLog
+	disk       *diskTier             // Segundo nivel para las claves que salen de memoria (opcional)
+	compressor *compressor           // Compresión de los valores grandes (opcional)
+	protected  func(key string) bool // Claves que solo cambian clave a clave (p.e. las de Raft)
+	tracker    *ChangeTracker        // Anota los cambios mientras se importa una caché (opcional)
 }
 
+// storedValue es lo que se guarda en ristretto. El callback de expulsión solo recibe
+// el hash de la clave, así que la clave y su versión viajan con el valor
+type storedValue struct {
+	key     string
+	value   interface{}
+	version uint64
+	codec   string // Códec del valor si está comprimido
+}
+
+// MutationLog recibe cada cambio de la caché después de aplicarlo, con writeMu
+// tomado para que el orden sea el mismo que en la caché (p.e. el WAL)
+type MutationLog interface {
+	LogSet(key string, value interface{}, expiresAt time.Time, version uint64)
+	LogRemove(key string)
+	LogRemovePattern(pattern string)
+	LogFlush()
+	LogLocal(key string, local bool)
+}
+
+// CacheEntry es la representación de una entrada para listados y exportación.
+// ExpiresIn se mantiene por compatibilidad con nodos antiguos, ExpiresAt es el
+// instante absoluto de expiración (Unix en milisegundos)
 type CacheEntry struct {
 	Key       string `json:"key"`
 	Value     string `json:"value"`
 	ExpiresIn string `json:"expires_in"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Version   uint64 `json:"version,omitempty"`
 }
 
 var CacheMutex sync.Mutex
 
+// ChangeTracker anota las claves escritas o borradas en la caché desde que se creó,
+// para que una importación lenta no pise ni resucite lo que cambió mientras tanto
+type ChangeTracker struct {
+	keys     map[string]struct{}
+	patterns []string
+	flushed  bool
+}
+
+// touched indica si la clave ha cambiado desde que empezó el tracker (con writeMu tomado)
+func (t *ChangeTracker) touched(key string) bool {
+	if t.flushed {
+		return true
+	}
+	if _, ok := t.keys[key]; ok {
+		return true
+	}
+	for _, pattern := range t.patterns {
+		if strings.Contains(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewCache crea una nueva instancia de caché
 func NewCache(numCounters, maxCost, bufferItems int64) *Cache {
-	cache, err := ristretto.NewCache(&ristretto.Config{
+	c := &Cache{}
+	store, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: numCounters,
 		MaxCost:     maxCost,
 		BufferItems: bufferItems,
+		OnEvict:     c.spill,
+		OnReject:    c.spill,
 	})
 	if err != nil {
 		panic(fmt.Sprintf("❌ Error al inicializar la caché: %v", err))
 	}
 
-	return &Cache{store: cache}
+	c.store = store
+	return c
+}
+
+// EnableDiskTier activa el nivel en disco: las claves que ristretto saca de memoria
+// se guardan en disco y vuelven a memoria al leerlas
+func (c *Cache) EnableDiskTier(opts DiskTierOptions) {
+	disk, err := newDiskTier(opts)
+	if err != nil {
+		panic(fmt.Sprintf("❌ Error al inicializar el nivel en disco: %v", err))
+	}
+
+	c.writeMu.Lock()
+	c.disk = disk
+	c.writeMu.Unlock()
+}
+
+// EnableCompression activa la compresión de los valores a partir de un tamaño. Los
+// valores ya guardados no cambian
+func (c *Cache) EnableCompression(opts CompressionOptions) {
+	if !ValidCodec(opts.Codec) {
+		panic(fmt.Sprintf("❌ Códec de compresión no válido: %q", opts.Codec))
+	}
+
+	c.writeMu.Lock()
+	c.compressor = &compressor{opts: opts}
+	c.writeMu.Unlock()
+}
+
+// CompressionStats devuelve las estadísticas de la compresión (nil si no está activa)
+func (c *Cache) CompressionStats() *CompressionStats {
+	if c.compressor == nil {
+		return nil
+	}
+	stats := c.compressor.getStats()
+	return &stats
+}
+
+// DiskTierStats devuelve las estadísticas del nivel en disco (nil si no está activo)
+func (c *Cache) DiskTierStats() *TierStats {
+	if c.disk == nil {
+		return nil
+	}
+	stats := c.disk.getStats()
+	return &stats
+}
+
+// spill baja a disco una clave que ristretto ha sacado de memoria (expulsada o no
+// admitida). Se llama desde la goroutine de ristretto, así que no puede tomar writeMu
+// ni esperar al disco: la escritura del fichero queda encolada
+func (c *Cache) spill(item *ristretto.Item) {
+	stored, ok := item.Value.(storedValue)
+	if !ok || c.disk == nil {
+		return
+	}
+
+	// Borrada, vaciada o sustituida por una versión más nueva
+	if c.Version(stored.key) != stored.version {
+		return
+	}
+	expTime, ok := c.expiration.Load(stored.key)
+	if !ok {
+		return
+	}
+	c.disk.put(stored, expTime.(time.Time))
+}
+
+// fromMemory obtiene el valor guardado de una clave si está en memoria
+func (c *Cache) fromMemory(key string) (storedValue, bool) {
+	val, found := c.store.Get(key)
+	if !found {
+		return storedValue{}, false
+	}
+	return val.(storedValue), true
+}
+
+// promote sube a memoria una clave del nivel en disco
+func (c *Cache) promote(key string) (storedValue, bool) {
+	if c.disk == nil {
+		return storedValue{}, false
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// Otra lectura pudo subirla mientras esperábamos
+	if stored, found := c.fromMemory(key); found {
+		return stored, true
+	}
+
+	record, found := c.disk.get(key)
+	if !found {
+		return storedValue{}, false
+	}
+	expTime, ok := c.expiration.Load(key)
+	if !ok || record.Version != c.Version(key) {
+		c.disk.remove(key)
+		return storedValue{}, false
+	}
+	ttl := time.Until(expTime.(time.Time))
+	if ttl <= 0 {
+		c.disk.remove(key)
+		return storedValue{}, false
+	}
+
+	// Se quita del disco antes: si ristretto no la admite, vuelve a bajar
+	stored := record.stored()
+	c.disk.promoted(key)
+	c.store.SetWithTTL(key, stored, costOf(stored), ttl)
+	c.store.Wait()
+	return stored, true
+}
+
+// lookup obtiene el valor de memoria o, si no está, del disco sin subirlo
+func (c *Cache) lookup(key string) (interface{}, bool) {
+	stored, found := c.fromMemory(key)
+	if !found && c.disk != nil {
+		record, onDisk := c.disk.get(key)
+		found = onDisk && record.Version == c.Version(key)
+		stored = record.stored()
+	}
+	if !found {
+		return nil, false
+	}
+	return c.decode(stored)
+}
+
+// decode devuelve el valor original de un valor guardado
+func (c *Cache) decode(stored storedValue) (interface{}, bool) {
+	value, err := decompress(stored.value, stored.codec)
+	if err != nil {
+		log.Printf("⚠️ Error descomprimiendo la clave %q (%s): %v", stored.key, stored.codec, err)
+		if c.compressor != nil {
+			c.compressor.errors.Add(1)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+// costOf es el coste de un valor para ristretto: los bytes que ocupa en memoria, con
+// el valor comprimido si lo está
+func costOf(stored storedValue) int64 {
+	return int64(len(stored.key)) + valueSize(stored.value)
+}
+
+// SetMutationLog registra el destino de los cambios de la caché (nil para quitarlo)
+func (c *Cache) SetMutationLog(log MutationLog) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.log = log
 }
 
 //********************************************************************
@@ -44,65 +254,432 @@ func NewCache(numCounters, maxCost, bufferItems int64) *Cache {
 
 // Set almacena un valor en la caché con un TTL
 func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
-	c.store.SetWithTTL(key, value, 1, ttl)
-	c.expiration.Store(key, time.Now().Add(ttl))
+	c.SetUntil(key, value, time.Now().Add(ttl))
+}
+
+// SetUntil almacena un valor en la caché hasta un instante absoluto de expiración
+// con una versión nueva. Devuelve la versión asignada (0 si el instante ya ha pasado)
+func (c *Cache) SetUntil(key string, value interface{}, expiresAt time.Time) uint64 {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	version := uint64(time.Now().UnixNano())
+	if current := c.Version(key); version <= current {
+		version = current + 1
+	}
+
+	if !c.put(key, value, expiresAt, version) {
+		return 0
+	}
+	return version
+}
+
+// SetVersioned almacena un valor con la versión indicada siempre que no sea más
+// antigua que la local (last write wins). Una versión 0 se trata como nueva
+func (c *Cache) SetVersioned(key string, value interface{}, expiresAt time.Time, version uint64) bool {
+	if version == 0 {
+		return c.SetUntil(key, value, expiresAt) != 0
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if version < c.Version(key) {
+		return false
+	}
+	return c.put(key, value, expiresAt, version)
+}
+
+// TrackChanges empieza a anotar las claves que cambian en la caché
+func (c *Cache) TrackChanges() *ChangeTracker {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.tracker = &ChangeTracker{keys: make(map[string]struct{})}
+	return c.tracker
+}
+
+// StopTracking deja de anotar cambios en el tracker
+func (c *Cache) StopTracking(tracker *ChangeTracker) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.tracker == tracker {
+		c.tracker = nil
+	}
+}
+
+// SetVersionedUntouched es SetVersioned salvo que la clave haya cambiado desde que
+// empezó el tracker: una escritura o un borrado local posteriores ganan siempre
+func (c *Cache) SetVersionedUntouched(tracker *ChangeTracker, key string, value interface{}, expiresAt time.Time, version uint64) bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if tracker.touched(key) {
+		return false
+	}
+	current := c.Version(key)
+	if version == 0 {
+		version = uint64(time.Now().UnixNano())
+		if version <= current {
+			version = current + 1
+		}
+	} else if version < current {
+		return false
+	}
+	return c.put(key, value, expiresAt, version)
+}
+
+// track anota un cambio en el tracker activo (con writeMu tomado)
+func (c *Cache) track(key string) {
+	if c.tracker != nil {
+		c.tracker.keys[key] = struct{}{}
+	}
+}
+
+// put guarda el valor, su expiración y su versión (con writeMu tomado)
+func (c *Cache) put(key string, value interface{}, expiresAt time.Time, version uint64) bool {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false
+	}
+
+	// La copia en disco deja de ser la última versión. Se quita antes: si ristretto
+	// no admite la nueva, vuelve a bajar
+	if c.disk != nil {
+		c.disk.remove(key)
+	}
+	stored := storedValue{key: key, value: value, version: version}
+	if c.compressor != nil {
+		stored.value, stored.codec = c.compressor.compress(value)
+	}
+
+	c.track(key)
+	c.expiration.Store(key, expiresAt)
+	c.versions.Store(key, version)
+	c.store.SetWithTTL(key, stored, costOf(stored), ttl)
 	c.store.Wait()
+	if c.log != nil {
+		c.log.LogSet(key, value, expiresAt, version)
+	}
+	return true
+}
+
+// Len devuelve el número de claves de la caché
+func (c *Cache) Len() int {
+	n := 0
+	c.expiration.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// MaxVersion devuelve la versión más alta de la caché, es decir, el reloj de la
+// última escritura que ha visto el nodo
+func (c *Cache) MaxVersion() uint64 {
+	var max uint64
+	c.versions.Range(func(_, value interface{}) bool {
+		if v := value.(uint64); v > max {
+			max = v
+		}
+		return true
+	})
+	return max
+}
+
+// NextVersion devuelve una versión nueva, posterior a todas las de la caché
+func (c *Cache) NextVersion() uint64 {
+	version := uint64(time.Now().UnixNano())
+	if max := c.MaxVersion(); version <= max {
+		version = max + 1
+	}
+	return version
+}
+
+// Version devuelve la versión de una clave (0 si no existe)
+func (c *Cache) Version(key string) uint64 {
+	version, ok := c.versions.Load(key)
+	if !ok {
+		return 0
+	}
+	return version.(uint64)
 }
 
 // Get obtiene un valor de la caché si no ha expirado
 func (c *Cache) Get(key string) (interface{}, bool) {
-	val, found := c.store.Get(key)
+	val, _, found := c.GetTier(key)
+	return val, found
+}
+
+// GetTier obtiene un valor e indica qué nivel lo sirvió (memory o disk). Las claves
+// que estaban en disco se suben a memoria
+func (c *Cache) GetTier(key string) (interface{}, string, bool) {
+	value, _, tier, found := c.GetEncoded(key, nil)
+	return value, tier, found
+}
+
+// GetEncoded obtiene un valor sin descomprimirlo si 'accepts' acepta su códec, y
+// devuelve el códec ("" si el valor va sin comprimir) y el nivel que lo sirvió
+func (c *Cache) GetEncoded(key string, accepts func(codec string) bool) (interface{}, string, string, bool) {
+	tier := TierMemory
+	stored, found := c.fromMemory(key)
 	if !found {
-		return nil, false
+		if stored, found = c.promote(key); !found {
+			return nil, "", "", false
+		}
+		tier = TierDisk
 	}
 
-	return val, true
+	if stored.codec != "" && accepts != nil && accepts(stored.codec) {
+		if c.compressor != nil {
+			c.compressor.passthrough.Add(1)
+		}
+		return stored.value, stored.codec, tier, true
+	}
+	value, ok := c.decode(stored)
+	return value, "", tier, ok
 }
 
 func (c *Cache) GetWithExpiry(key string) (interface{}, any, bool) {
-	val, found := c.store.Get(key)
+	val, found := c.Get(key)
 	if !found {
 		return nil, nil, false
 	}
 
 	expTime, exists := c.expiration.Load(key)
 	if exists && time.Now().After(expTime.(time.Time)) {
-		c.store.Del(key)
-		c.expiration.Delete(key)
+		c.RemoveKey(key)
 		return nil, nil, false
 	}
 
 	return val, expTime, true
 }
 
-// FlushAll borra toda la caché
+// GetVersioned obtiene un valor con su expiración y su versión
+func (c *Cache) GetVersioned(key string) (interface{}, time.Time, uint64, bool) {
+	val, expTime, found := c.GetWithExpiry(key)
+	if !found || expTime == nil {
+		return nil, time.Time{}, 0, false
+	}
+	return val, expTime.(time.Time), c.Version(key), true
+}
+
+// ProtectKeys marca las claves gestionadas por otro mecanismo (el log de Raft): los
+// borrados masivos (FlushAll, RemovePatternKey) no las tocan
+func (c *Cache) ProtectKeys(protected func(key string) bool) {
+	c.writeMu.Lock()
+	c.protected = protected
+	c.writeMu.Unlock()
+}
+
+// IsProtected indica si una clave la gestiona otro mecanismo y no se debe modificar
+// desde la replicación, la recuperación o las importaciones
+func (c *Cache) IsProtected(key string) bool {
+	return c.protected != nil && c.protected(key)
+}
+
+// FlushAll borra toda la caché salvo las claves protegidas
 func (c *Cache) FlushAll() {
-	c.store.Clear()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.tracker != nil {
+		c.tracker.flushed = true
+	}
+
+	if c.protected != nil {
+		c.expiration.Range(func(key, _ interface{}) bool {
+			if !c.protected(key.(string)) {
+				c.remove(key.(string))
+			}
+			return true
+		})
+		if c.log != nil {
+			c.log.LogFlush()
+		}
+		return
+	}
+
+	// Primero las versiones, para que lo que expulse Clear no baje a disco
 	c.expiration = sync.Map{}
+	c.versions = sync.Map{}
+	c.local = sync.Map{}
+	c.store.Clear()
+	if c.disk != nil {
+		c.disk.clear()
+	}
+	if c.log != nil {
+		c.log.LogFlush()
+	}
+}
+
+// FlushReplicated borra las claves replicadas y conserva las solo locales (y las
+// protegidas). Es lo que se aplica cuando el vaciado llega de otro nodo
+func (c *Cache) FlushReplicated() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.tracker != nil {
+		c.tracker.flushed = true
+	}
+
+	c.expiration.Range(func(key, _ interface{}) bool {
+		keyStr := key.(string)
+		if c.IsLocal(keyStr) || c.IsProtected(keyStr) {
+			return true
+		}
+		c.remove(keyStr)
+		if c.log != nil {
+			c.log.LogRemove(keyStr)
+		}
+		return true
+	})
 }
 
 // Elimina una Key concreta de la cache
 func (c *Cache) RemoveKey(key string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.track(key)
+	c.remove(key)
+	if c.log != nil {
+		c.log.LogRemove(key)
+	}
+}
+
+// RemoveMissing borra las claves replicadas que no están en remote (el diff de otro
+// nodo). Las protegidas solo cambian por su log y se conservan
+func (c *Cache) RemoveMissing(remote map[string]int64) []string {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deletedKeys := []string{}
+	c.expiration.Range(func(key, _ interface{}) bool {
+		keyStr := key.(string)
+		if _, exists := remote[keyStr]; exists || c.IsLocal(keyStr) || c.IsProtected(keyStr) {
+			return true
+		}
+		c.track(keyStr)
+		c.remove(keyStr)
+		deletedKeys = append(deletedKeys, keyStr)
+		if c.log != nil {
+			c.log.LogRemove(keyStr)
+		}
+		return true
+	})
+	return deletedKeys
+}
+
+// remove borra la clave (con writeMu tomado)
+func (c *Cache) remove(key string) {
 	c.store.Del(key)
 	c.expiration.Delete(key)
+	c.versions.Delete(key)
+	c.local.Delete(key)
+	if c.disk != nil {
+		c.disk.remove(key)
+	}
+}
+
+// SetLocal marca (o desmarca) una clave como solo local
+func (c *Cache) SetLocal(key string, local bool) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	changed := false
+	if local {
+		_, existed := c.local.LoadOrStore(key, struct{}{})
+		changed = !existed
+	} else {
+		_, changed = c.local.LoadAndDelete(key)
+	}
+	if changed && c.log != nil {
+		c.log.LogLocal(key, local)
+	}
+}
+
+// IsLocal indica si una clave es solo local
+func (c *Cache) IsLocal(key string) bool {
+	_, ok := c.local.Load(key)
+	return ok
+}
+
+// GetReplicated devuelve las entradas que se pueden exportar a otros nodos, es decir,
+// todas menos las solo locales
+func (c *Cache) GetReplicated() []CacheEntry {
+	var items []CacheEntry
+	for _, item := range c.GetAll(false) {
+		if !c.IsLocal(item.Key) {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func (c *Cache) RemovePatternKey(keyPattern string) []string {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 
+	if c.tracker != nil {
+		c.tracker.patterns = append(c.tracker.patterns, keyPattern)
+	}
 	deletedKeys := []string{}
 	// Recorrer la caché y eliminar los que coincidan con el patrón
 	c.expiration.Range(func(key, _ interface{}) bool {
 		keyStr := key.(string)
-		if strings.Contains(keyStr, keyPattern) {
-			c.RemoveKey(keyStr)
+		if strings.Contains(keyStr, keyPattern) && !c.IsProtected(keyStr) {
+			c.remove(keyStr)
 			deletedKeys = append(deletedKeys, keyStr)
 		}
 		return true
 	})
 
+	if c.log != nil {
+		c.log.LogRemovePattern(keyPattern)
+	}
+	return deletedKeys
+}
+
+// RemovePatternReplicated borra las claves replicadas que contienen el patrón y
+// conserva las solo locales (y las protegidas). Es lo que se aplica cuando el borrado
+// llega de otro nodo. Cada clave va al log por separado para que al reproducirlo no
+// se borren las locales
+func (c *Cache) RemovePatternReplicated(keyPattern string) []string {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.tracker != nil {
+		c.tracker.patterns = append(c.tracker.patterns, keyPattern)
+	}
+	deletedKeys := []string{}
+	c.expiration.Range(func(key, _ interface{}) bool {
+		keyStr := key.(string)
+		if !strings.Contains(keyStr, keyPattern) || c.IsLocal(keyStr) || c.IsProtected(keyStr) {
+			return true
+		}
+		c.remove(keyStr)
+		deletedKeys = append(deletedKeys, keyStr)
+		if c.log != nil {
+			c.log.LogRemove(keyStr)
+		}
+		return true
+	})
 	return deletedKeys
 }
 
+// Keys devuelve las claves que contienen el patrón (todas si está vacío)
+func (c *Cache) Keys(keyPattern string) []string {
+	keys := []string{}
+	c.expiration.Range(func(key, _ interface{}) bool {
+		keyStr := key.(string)
+		if strings.Contains(keyStr, keyPattern) {
+			keys = append(keys, keyStr)
+		}
+		return true
+	})
+	return keys
+}
+
 // List devuelve una lista de claves, sus valores truncados y sus expiraciones
 func (c *Cache) List() []CacheEntry {
 	return c.GetAll(true)
@@ -112,7 +689,7 @@ func (c *Cache) List() []CacheEntry {
 func (c *Cache) GetAll(truncateValue bool) []CacheEntry {
 	var items []CacheEntry
 	c.expiration.Range(func(key, value interface{}) bool {
-		val, found := c.store.Get(key.(string))
+		val, found := c.lookup(key.(string))
 		if !found {
 			return true
 		}
@@ -134,6 +711,8 @@ func (c *Cache) GetAll(truncateValue bool) []CacheEntry {
 			Key:       key.(string),
 			Value:     cacheValue,
 			ExpiresIn: timeRemaining,
+			ExpiresAt: expTime.UnixMilli(),
+			Version:   c.Version(key.(string)),
 		})
 		return true
 	})
@@ -145,7 +724,7 @@ func (c *Cache) GetDiff() map[string]int64 {
 
 	c.expiration.Range(func(key, value interface{}) bool {
 		expTime, ok := value.(time.Time)
-		if ok {
+		if ok && !c.IsLocal(key.(string)) {
 			diff[key.(string)] = expTime.Unix()
 		}
 		return true
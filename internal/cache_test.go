@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtectedKeysSurviveBulkRemoves(t *testing.T) {
+	cache := NewCache(1000, 1<<20, 64)
+	cache.ProtectKeys(func(key string) bool { return strings.HasPrefix(key, "strong:") })
+
+	cache.Set("strong:a", "1", time.Minute)
+	cache.Set("plain:a", "2", time.Minute)
+	cache.Set("plain:strong:b", "3", time.Minute)
+
+	cache.RemovePatternKey("a")
+	if _, ok := cache.Get("strong:a"); !ok {
+		t.Fatalf("RemovePatternKey borró una clave protegida")
+	}
+	if _, ok := cache.Get("plain:a"); ok {
+		t.Fatalf("RemovePatternKey no borró plain:a")
+	}
+
+	// Recuperación por diff: el peer no tiene las claves locales
+	cache.Set("plain:c", "4", time.Minute)
+	cache.Set("plain:d", "5", time.Minute)
+	cache.RemoveMissing(map[string]int64{"plain:d": 0})
+	if _, ok := cache.Get("strong:a"); !ok {
+		t.Fatalf("RemoveMissing borró una clave protegida")
+	}
+	if _, ok := cache.Get("plain:c"); ok {
+		t.Fatalf("RemoveMissing no borró plain:c")
+	}
+	if _, ok := cache.Get("plain:d"); !ok {
+		t.Fatalf("RemoveMissing borró una clave que tiene el peer")
+	}
+
+	cache.FlushAll()
+	if _, ok := cache.Get("strong:a"); !ok {
+		t.Fatalf("FlushAll borró una clave protegida")
+	}
+	if _, ok := cache.Get("plain:strong:b"); ok {
+		t.Fatalf("FlushAll no borró plain:strong:b")
+	}
+
+	// Clave a clave sí se pueden borrar (el log de Raft)
+	cache.RemoveKey("strong:a")
+	if _, ok := cache.Get("strong:a"); ok {
+		t.Fatalf("RemoveKey no borró la clave protegida")
+	}
+}
+
+func TestFlushReplicatedKeepsLocalOnlyKeys(t *testing.T) {
+	cache := NewCache(1000, 1<<20, 64)
+	cache.Set("compartida", "1", time.Minute)
+	cache.Set("solo", "2", time.Minute)
+	cache.SetLocal("solo", true)
+
+	cache.FlushReplicated()
+	if _, ok := cache.Get("compartida"); ok {
+		t.Fatalf("FlushReplicated no borró la clave replicada")
+	}
+	if _, ok := cache.Get("solo"); !ok {
+		t.Fatalf("FlushReplicated borró la clave solo local")
+	}
+}
+
+func TestSetVersionedUntouchedKeepsLocalChanges(t *testing.T) {
+	cache := NewCache(1000, 1<<20, 64)
+	cache.Set("borrada", "local", time.Minute)
+	tracker := cache.TrackChanges()
+
+	// Cambios de los clientes mientras se importa la caché de un peer
+	cache.RemoveKey("borrada")
+	cache.Set("escrita", "local", time.Minute)
+	cache.RemovePatternKey("patron:")
+
+	expiresAt := time.Now().Add(time.Minute)
+	for _, key := range []string{"borrada", "escrita", "patron:a"} {
+		if cache.SetVersionedUntouched(tracker, key, "peer", expiresAt, 1) {
+			t.Fatalf("la importación pisó %s, que cambió después de empezar", key)
+		}
+	}
+	if _, ok := cache.Get("borrada"); ok {
+		t.Fatalf("la importación resucitó una clave borrada")
+	}
+	if value, _ := cache.Get("escrita"); value != "local" {
+		t.Fatalf("valor de escrita = %v, se esperaba local", value)
+	}
+	if !cache.SetVersionedUntouched(tracker, "nueva", "peer", expiresAt, 1) {
+		t.Fatalf("la importación no guardó una clave que no cambió")
+	}
+
+	cache.FlushAll()
+	cache.StopTracking(tracker)
+	if cache.SetVersionedUntouched(tracker, "otra", "peer", expiresAt, 1) {
+		t.Fatalf("la importación guardó una clave después de un vaciado")
+	}
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"phoenixcache/utils"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Códecs con los que se pueden guardar los valores
+const (
+	CodecGzip   = "gzip"
+	CodecZstd   = "zstd"
+	CodecSnappy = "snappy"
+	CodecAuto   = "auto" // snappy para los valores medianos y zstd para los grandes
+)
+
+// Con 'auto', tamaño a partir del cual compensa zstd (más lento, pero comprime más)
+const autoZstdSize = 64 << 10
+
+// Un valor solo se guarda comprimido si ahorra al menos 1/minSavingDivisor de su tamaño
+const minSavingDivisor = 8
+
+// EncodeAll y DecodeAll se pueden usar desde varias goroutines a la vez
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// CompressionOptions configura la compresión de los valores
+type CompressionOptions struct {
+	Codec   string // gzip, zstd, snappy o auto
+	MinSize int    // Los valores más pequeños se guardan tal cual
+}
+
+// CompressionStats son las estadísticas de la compresión
+type CompressionStats struct {
+	Codec          string `json:"codec"`
+	MinSize        int    `json:"min_size"`
+	Compressed     uint64 `json:"compressed"`     // Valores guardados comprimidos
+	Incompressible uint64 `json:"incompressible"` // Valores que no ahorraban lo suficiente
+	BytesIn        uint64 `json:"bytes_in"`       // Tamaño original de los comprimidos
+	BytesOut       uint64 `json:"bytes_out"`      // Tamaño comprimido
+	Passthrough    uint64 `json:"passthrough"`    // Lecturas servidas sin descomprimir
+	Errors         uint64 `json:"errors"`
+}
+
+// ValidCodec indica si se sabe comprimir con el códec
+func ValidCodec(codec string) bool {
+	switch codec {
+	case CodecGzip, CodecZstd, CodecSnappy, CodecAuto:
+		return true
+	}
+	return false
+}
+
+// compressor comprime los valores grandes antes de guardarlos
+type compressor struct {
+	opts CompressionOptions
+
+	compressed     atomic.Uint64
+	incompressible atomic.Uint64
+	bytesIn        atomic.Uint64
+	bytesOut       atomic.Uint64
+	passthrough    atomic.Uint64
+	errors         atomic.Uint64
+}
+
+// compress devuelve el valor comprimido y su códec, o el valor tal cual y "" si es
+// pequeño, no es un string o no se reduce lo suficiente
+func (c *compressor) compress(value interface{}) (interface{}, string) {
+	raw, ok := value.(string)
+	if !ok || len(raw) < c.opts.MinSize {
+		return value, ""
+	}
+
+	codec := c.opts.Codec
+	if codec == CodecAuto {
+		codec = CodecSnappy
+		if len(raw) >= autoZstdSize {
+			codec = CodecZstd
+		}
+	}
+
+	var packed []byte
+	switch codec {
+	case CodecGzip:
+		packed = utils.CompressData([]byte(raw))
+	case CodecZstd:
+		packed = zstdEncoder.EncodeAll([]byte(raw), nil)
+	case CodecSnappy:
+		packed = snappy.Encode(nil, []byte(raw))
+	}
+
+	if len(packed) > len(raw)-len(raw)/minSavingDivisor {
+		c.incompressible.Add(1)
+		return value, ""
+	}
+	c.compressed.Add(1)
+	c.bytesIn.Add(uint64(len(raw)))
+	c.bytesOut.Add(uint64(len(packed)))
+	return string(packed), codec
+}
+
+func (c *compressor) getStats() CompressionStats {
+	return CompressionStats{
+		Codec:          c.opts.Codec,
+		MinSize:        c.opts.MinSize,
+		Compressed:     c.compressed.Load(),
+		Incompressible: c.incompressible.Load(),
+		BytesIn:        c.bytesIn.Load(),
+		BytesOut:       c.bytesOut.Load(),
+		Passthrough:    c.passthrough.Load(),
+		Errors:         c.errors.Load(),
+	}
+}
+
+// decompress devuelve el valor original de un valor guardado con 'codec'
+func decompress(value interface{}, codec string) (interface{}, error) {
+	if codec == "" {
+		return value, nil
+	}
+
+	packed := []byte(value.(string))
+	var raw []byte
+	var err error
+	switch codec {
+	case CodecGzip:
+		raw, err = utils.DecompressData(packed)
+	case CodecZstd:
+		raw, err = zstdDecoder.DecodeAll(packed, nil)
+	case CodecSnappy:
+		raw, err = snappy.Decode(nil, packed)
+	default:
+		err = fmt.Errorf("códec desconocido %q", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// valueSize es el tamaño en bytes de un valor guardado
+func valueSize(value interface{}) int64 {
+	if raw, ok := value.(string); ok {
+		return int64(len(raw))
+	}
+	return 1
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	value := strings.Repeat("phoenix cache ", 10000)
+
+	for _, codec := range []string{CodecGzip, CodecZstd, CodecSnappy, CodecAuto} {
+		c := &compressor{opts: CompressionOptions{Codec: codec, MinSize: 1024}}
+
+		packed, used := c.compress(value)
+		if used == "" || len(packed.(string)) >= len(value) {
+			t.Fatalf("%s: el valor no se comprimió (códec %q)", codec, used)
+		}
+		if codec == CodecAuto && used != CodecZstd {
+			t.Fatalf("auto usó %s para un valor de %d bytes, se esperaba zstd", used, len(value))
+		}
+
+		raw, err := decompress(packed, used)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if raw != value {
+			t.Fatalf("%s: el valor descomprimido no coincide con el original", codec)
+		}
+	}
+}
+
+func TestCompressionSkipsSmallAndIncompressibleValues(t *testing.T) {
+	c := &compressor{opts: CompressionOptions{Codec: CodecZstd, MinSize: 1024}}
+
+	if _, codec := c.compress("pequeño"); codec != "" {
+		t.Fatalf("se comprimió un valor por debajo de min_size")
+	}
+	if _, codec := c.compress(42); codec != "" {
+		t.Fatalf("se comprimió un valor que no es string")
+	}
+	if stats := c.getStats(); stats.Compressed != 0 {
+		t.Fatalf("estadísticas = %+v", stats)
+	}
+}
+
+func TestCacheReturnsOriginalCompressedValue(t *testing.T) {
+	cache := NewCache(1000, 1<<20, 64)
+	cache.EnableCompression(CompressionOptions{Codec: CodecSnappy, MinSize: 16})
+
+	value := strings.Repeat("abc", 1000)
+	cache.Set("k", value, 60e9)
+
+	got, found := cache.Get("k")
+	if !found || got != value {
+		t.Fatalf("Get devolvió un valor distinto del guardado")
+	}
+	if stats := cache.CompressionStats(); stats.Compressed != 1 || stats.BytesOut >= stats.BytesIn {
+		t.Fatalf("estadísticas = %+v", stats)
+	}
+}
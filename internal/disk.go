@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"phoenixcache/security"
+)
+
+// Niveles de la caché que pueden servir una lectura
+const (
+	TierMemory = "memory"
+	TierDisk   = "disk"
+)
+
+// Extensión de los ficheros del nivel en disco
+const diskEntryExt = ".entry"
+
+// Claves expulsadas de memoria que pueden esperar a escribirse en disco. Si hay más,
+// las nuevas se descartan
+const maxPendingSpills = 4096
+
+// DiskTierOptions configura el nivel en disco
+type DiskTierOptions struct {
+	Dir      string
+	MaxBytes int64
+	MinTTL   time.Duration // Las claves a las que les queda menos no se bajan a disco
+	MaxTTL   time.Duration // Tiempo máximo en disco (0: hasta que caduque la clave)
+}
+
+// TierStats son las estadísticas del nivel en disco
+type TierStats struct {
+	Keys     int    `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+	Spilled  uint64 `json:"spilled"`  // Claves bajadas a disco al salir de memoria
+	Promoted uint64 `json:"promoted"` // Claves subidas a memoria al leerlas
+	Pending  int    `json:"pending"`  // Claves expulsadas que aún no se han escrito
+	Dropped  uint64 `json:"dropped"`  // Claves descartadas por falta de espacio
+	Skipped  uint64 `json:"skipped"`  // Claves que no cumplían el TTL mínimo
+	Expired  uint64 `json:"expired"`
+	Errors   uint64 `json:"errors"`
+}
+
+// diskRecord es el contenido de cada fichero. Los valores comprimidos van en Packed
+// (base64 en el JSON) con su códec
+type diskRecord struct {
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value"`
+	Packed    []byte      `json:"packed,omitempty"`
+	Codec     string      `json:"codec,omitempty"`
+	ExpiresAt int64       `json:"expires_at"` // Unix ms, el de la clave
+	Version   uint64      `json:"version"`
+}
+
+// stored devuelve el valor tal y como se guarda en memoria
+func (r diskRecord) stored() storedValue {
+	stored := storedValue{key: r.Key, value: r.Value, version: r.Version, codec: r.Codec}
+	if r.Codec != "" {
+		stored.value = string(r.Packed)
+	}
+	return stored
+}
+
+// diskEntry es la entrada del índice en memoria de una clave en disco
+type diskEntry struct {
+	key   string
+	file  string
+	size  int64
+	until time.Time // Hasta cuándo se conserva en disco
+}
+
+// pendingSpill es una clave expulsada de memoria que espera a escribirse en disco
+type pendingSpill struct {
+	stored    storedValue
+	expiresAt time.Time
+}
+
+// record devuelve la clave tal y como quedará en disco
+func (p *pendingSpill) record() diskRecord {
+	record := diskRecord{Key: p.stored.key, Value: p.stored.value, Codec: p.stored.codec, ExpiresAt: p.expiresAt.UnixMilli(), Version: p.stored.version}
+	if p.stored.codec != "" {
+		record.Value = nil
+		record.Packed = []byte(p.stored.value.(string))
+	}
+	return record
+}
+
+// diskTier guarda en disco las claves que ristretto saca de memoria, un fichero por
+// clave. Si no cabe una nueva se descartan las que llevan más tiempo en disco. Los
+// ficheros los escribe una goroutine aparte: mientras esperan, las claves se leen de
+// 'pending'
+type diskTier struct {
+	opts DiskTierOptions
+
+	mu      sync.Mutex
+	index   map[string]*list.Element
+	order   *list.List // Orden de escritura, la más antigua primero
+	pending map[string]*pendingSpill
+	queue   []string      // Orden de llegada de las claves pendientes
+	wake    chan struct{} // Avisa al escritor de que hay claves pendientes
+	stats   TierStats
+}
+
+// newDiskTier prepara el directorio. Lo que hubiera de una ejecución anterior se
+// borra: la caché se reconstruye desde los peers, el snapshot o el WAL
+func newDiskTier(opts DiskTierOptions) (*diskTier, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	old, err := filepath.Glob(filepath.Join(opts.Dir, "*"+diskEntryExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range old {
+		os.Remove(file)
+	}
+
+	d := &diskTier{
+		opts:    opts,
+		index:   make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]*pendingSpill),
+		wake:    make(chan struct{}, 1),
+	}
+	d.stats.MaxBytes = opts.MaxBytes
+
+	go d.writeLoop()
+	go func() {
+		for range time.Tick(time.Minute) {
+			d.sweep()
+		}
+	}()
+	return d, nil
+}
+
+// fileFor devuelve el fichero de una clave
+func (d *diskTier) fileFor(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(d.opts.Dir, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+// put encola la clave para guardarla en disco si le queda al menos el TTL mínimo. Se
+// llama desde el callback de expulsión de ristretto, así que no toca el disco
+func (d *diskTier) put(stored storedValue, expiresAt time.Time) {
+	remaining := time.Until(expiresAt)
+	if remaining <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if remaining < d.opts.MinTTL {
+		d.stats.Skipped++
+		return
+	}
+	if _, queued := d.pending[stored.key]; !queued && len(d.pending) >= maxPendingSpills {
+		d.stats.Dropped++
+		return
+	}
+
+	d.removeLocked(stored.key)
+	d.pending[stored.key] = &pendingSpill{stored: stored, expiresAt: expiresAt}
+	d.queue = append(d.queue, stored.key)
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop escribe en disco las claves pendientes, en orden de llegada
+func (d *diskTier) writeLoop() {
+	for range d.wake {
+		for d.writeNext() {
+		}
+	}
+}
+
+// writeNext escribe la siguiente clave pendiente fuera del lock, para no frenar las
+// lecturas ni las expulsiones. Devuelve false si no quedan
+func (d *diskTier) writeNext() bool {
+	d.mu.Lock()
+	spill := d.nextLocked()
+	d.mu.Unlock()
+	if spill == nil {
+		return false
+	}
+
+	key := spill.stored.key
+	file := d.fileFor(key)
+	data, err := json.Marshal(spill.record())
+	if err == nil {
+		data = security.Seal(data)
+		err = os.WriteFile(file, data, 0644)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Subida a memoria, borrada o sustituida mientras se escribía
+	if d.pending[key] != spill {
+		if _, queued := d.pending[key]; !queued {
+			os.Remove(file)
+		}
+		return true
+	}
+	delete(d.pending, key)
+
+	if err != nil {
+		d.stats.Errors++
+		log.Printf("⚠️ Error bajando la clave %q a disco: %v", key, err)
+		os.Remove(file)
+		return true
+	}
+	size := int64(len(data))
+	remaining := time.Until(spill.expiresAt)
+	if size > d.opts.MaxBytes || remaining <= 0 {
+		os.Remove(file)
+		d.stats.Dropped++
+		return true
+	}
+	for d.stats.Bytes+size > d.opts.MaxBytes && d.order.Len() > 0 {
+		d.removeLocked(d.order.Front().Value.(*diskEntry).key)
+		d.stats.Dropped++
+	}
+
+	until := spill.expiresAt
+	if d.opts.MaxTTL > 0 && remaining > d.opts.MaxTTL {
+		until = time.Now().Add(d.opts.MaxTTL)
+	}
+	d.index[key] = d.order.PushBack(&diskEntry{key: key, file: file, size: size, until: until})
+	d.stats.Bytes += size
+	d.stats.Spilled++
+	return true
+}
+
+// nextLocked devuelve la siguiente clave pendiente, que sigue en 'pending' hasta que
+// se termina de escribir (con d.mu tomado)
+func (d *diskTier) nextLocked() *pendingSpill {
+	for len(d.queue) > 0 {
+		key := d.queue[0]
+		d.queue = d.queue[1:]
+		// Las subidas a memoria o borradas ya no están
+		if spill, ok := d.pending[key]; ok {
+			return spill
+		}
+	}
+	d.queue = nil
+	return nil
+}
+
+// get lee una clave del disco sin sacarla
+func (d *diskTier) get(key string) (diskRecord, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if spill, ok := d.pending[key]; ok {
+		return spill.record(), true
+	}
+
+	var record diskRecord
+	elem, ok := d.index[key]
+	if !ok {
+		return record, false
+	}
+
+	entry := elem.Value.(*diskEntry)
+	if !time.Now().Before(entry.until) {
+		d.removeLocked(key)
+		d.stats.Expired++
+		return record, false
+	}
+
+	data, err := os.ReadFile(entry.file)
+	if err == nil {
+		data, err = security.Open(data)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &record)
+	}
+	if err != nil || record.Key != key {
+		if err == nil {
+			err = fmt.Errorf("el fichero es de la clave %q", record.Key)
+		}
+		log.Printf("⚠️ Error leyendo la clave %q del disco: %v", key, err)
+		d.removeLocked(key)
+		d.stats.Errors++
+		return record, false
+	}
+	return record, true
+}
+
+// promoted quita del disco una clave que se ha subido a memoria
+func (d *diskTier) promoted(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, onDisk := d.index[key]
+	_, queued := d.pending[key]
+	if onDisk || queued {
+		d.removeLocked(key)
+		d.stats.Promoted++
+	}
+}
+
+// remove borra una clave del disco
+func (d *diskTier) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeLocked(key)
+}
+
+// removeLocked borra una clave del disco o de las pendientes (con d.mu tomado). Si se
+// estaba escribiendo, el escritor borra el fichero al terminar
+func (d *diskTier) removeLocked(key string) {
+	elem, ok := d.index[key]
+	if _, queued := d.pending[key]; queued {
+		delete(d.pending, key)
+		// Puede quedar el fichero de una escritura anterior de la clave
+		if !ok {
+			os.Remove(d.fileFor(key))
+		}
+	}
+	if !ok {
+		return
+	}
+
+	entry := elem.Value.(*diskEntry)
+	os.Remove(entry.file)
+	d.order.Remove(elem)
+	delete(d.index, key)
+	d.stats.Bytes -= entry.size
+}
+
+// clear borra todas las claves del disco
+func (d *diskTier) clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key := range d.index {
+		d.removeLocked(key)
+	}
+	for key := range d.pending {
+		d.removeLocked(key)
+	}
+	d.queue = nil
+}
+
+// sweep borra las claves que ya no se deben conservar en disco
+func (d *diskTier) sweep() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for key, elem := range d.index {
+		if !now.Before(elem.Value.(*diskEntry).until) {
+			d.removeLocked(key)
+			d.stats.Expired++
+		}
+	}
+}
+
+func (d *diskTier) getStats() TierStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Keys = len(d.index) + len(d.pending)
+	stats.Pending = len(d.pending)
+	return stats
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"container/list"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDiskTier crea el nivel en disco sin su escritor, para avanzar la cola a mano
+func testDiskTier(t *testing.T) *diskTier {
+	return &diskTier{
+		opts:    DiskTierOptions{Dir: t.TempDir(), MaxBytes: 1 << 20},
+		index:   make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]*pendingSpill),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func TestDiskTierServesQueuedKeysUntilWritten(t *testing.T) {
+	d := testDiskTier(t)
+	expiresAt := time.Now().Add(time.Minute)
+
+	d.put(storedValue{key: "a", value: "1", version: 3}, expiresAt)
+	if _, err := os.Stat(d.fileFor("a")); !os.IsNotExist(err) {
+		t.Fatalf("put escribió el fichero en la goroutine que expulsa: %v", err)
+	}
+	if record, found := d.get("a"); !found || record.Value != "1" || record.Version != 3 {
+		t.Fatalf("la clave en cola no se puede leer: %+v", record)
+	}
+
+	if !d.writeNext() {
+		t.Fatalf("el escritor no encontró la clave pendiente")
+	}
+	if _, err := os.Stat(d.fileFor("a")); err != nil {
+		t.Fatalf("no se escribió el fichero: %v", err)
+	}
+	if record, found := d.get("a"); !found || record.Value != "1" {
+		t.Fatalf("la clave escrita no se puede leer: %+v", record)
+	}
+	if stats := d.getStats(); stats.Pending != 0 || stats.Keys != 1 || stats.Spilled != 1 {
+		t.Fatalf("estadísticas = %+v", stats)
+	}
+}
+
+func TestDiskTierSkipsKeysRemovedBeforeWriting(t *testing.T) {
+	d := testDiskTier(t)
+	d.put(storedValue{key: "a", value: "1", version: 3}, time.Now().Add(time.Minute))
+	d.remove("a")
+
+	if d.writeNext() {
+		t.Fatalf("se escribió una clave borrada mientras esperaba")
+	}
+	if _, err := os.Stat(d.fileFor("a")); !os.IsNotExist(err) {
+		t.Fatalf("quedó el fichero de una clave borrada: %v", err)
+	}
+	if _, found := d.get("a"); found {
+		t.Fatalf("la clave borrada se sigue leyendo del disco")
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"phoenixcache/configuration"
+	"phoenixcache/consensus"
 	"phoenixcache/distributed"
 	"phoenixcache/internal"
+	"phoenixcache/persistence"
 	"phoenixcache/security"
 	"phoenixcache/server"
 
@@ -16,17 +18,117 @@ func main() {
 
 	// Inicializar caché
 	cache := internal.NewCache(config.NumCounters, config.MaxCost, config.BufferItems)
+	if config.DiskTier.Enabled {
+		cache.EnableDiskTier(internal.DiskTierOptions{
+			Dir:      config.DiskTier.Dir,
+			MaxBytes: int64(config.DiskTier.MaxSizeMB) << 20,
+			MinTTL:   time.Duration(config.DiskTier.MinTTL) * time.Second,
+			MaxTTL:   time.Duration(config.DiskTier.MaxTTL) * time.Second,
+		})
+	}
+
+	if config.Compression.Enabled {
+		cache.EnableCompression(internal.CompressionOptions{
+			Codec:   config.Compression.Codec,
+			MinSize: config.Compression.MinSize,
+		})
+	}
 
 	//Iniciamos el modulo de seguridad
 	security.InitModule(&config)
 
-	var peerManager *distributed.PeerManager
+	//Parámetros de sincronización entre nodos
+	distributed.InitModule(&config)
+
+	// El PeerManager se crea siempre para poder añadir peers en caliente
+	peerManager := distributed.NewPeerManager(config.Peers, time.Duration(config.HeartBeatInterval)*time.Second, config.RetriesToDisabledNode)
+
+	//Conexiones persistentes con los peers para la sincronización
+	if config.SyncTransport == "binary" {
+		distributed.NewTransport(&config, peerManager)
+	}
+
+	//Detección de particiones y reconciliación al recuperarse
+	distributed.NewPartitionMonitor(&config, peerManager, cache)
+
+	//Hints para los peers caídos
+	if config.HintedHandoff.Enabled {
+		distributed.NewHintStore(&config, peerManager)
+	}
+
+	//Replicación asíncrona a otras regiones
+	if len(config.Regions) > 0 {
+		distributed.NewRegionReplicator(&config, peerManager).Start()
+	}
+
+	//Membresía dinámica: nos unimos al cluster a través de la semilla
+	if config.Gossip.Enabled {
+		distributed.NewGossip(&config, peerManager).Start()
+	}
+
+	//Cargamos el último snapshot en disco, por si no queda ningún peer vivo
+	var snapshotter *persistence.Snapshotter
+	snapshotLoaded := false
+	if config.Snapshot.Enabled {
+		snapshotter = persistence.NewSnapshotter(&config, cache)
+		snapshotLoaded = snapshotter.Load()
+	}
+
+	//Reproducimos los cambios posteriores al snapshot y empezamos a guardarlos
+	if config.WAL.Enabled {
+		wal := persistence.NewWAL(&config, cache, snapshotter)
+		if wal.Replay() {
+			snapshotLoaded = true
+		}
+		wal.Start()
+	}
+
+	//Orígenes de las claves: write-through de /set y carga en el warm-up
+	persistence.ConfigureOrigins(&config)
+
+	//Envío en diferido de los cambios a almacenes externos
+	persistence.StartSinks(&config)
+
+	//Consistencia fuerte para los prefijos configurados
+	raftNode := consensus.NewCacheNode(&config, cache)
+
+	// Iniciar servidor: los peers y /ready se atienden desde ya, los clientes cuando
+	// el nodo esté listo
+	go server.StartServer(&config, peerManager, raftNode, cache)
+
+	//Recuperamos la caché del peer más actualizado
+	recoverCache := func() {
+		distributed.SetStage("bootstrap")
+		if snapshotLoaded && config.Snapshot.SkipPeerRecovery {
+			distributed.SkipBootstrap("snapshot")
+		} else {
+			distributed.Bootstrap(peerManager, cache)
+		}
+	}
+
+	//Con warm-up, el nodo está listo en cuanto tiene las claves más usadas y el resto
+	//de la caché se recupera mientras atiende a los clientes. La recuperación no pisa
+	//las claves que los clientes escriban o borren mientras tanto
+	if config.Warmup.Enabled {
+		distributed.Warmup(&config, peerManager, cache, persistence.ReadThrough)
+		go recoverCache()
+	} else {
+		recoverCache()
+	}
+
+	if snapshotter != nil {
+		snapshotter.Start()
+	}
+
+	//Backups programados
+	if config.Backup.Enabled {
+		persistence.NewBackupScheduler(&config, cache).Start()
+	}
 
-	if (config.Peers != nil) && (len(config.Peers) > 0) {
-		peerManager = distributed.NewPeerManager(config.Peers, time.Duration(config.HeartBeatInterval)*time.Second, config.RetriesToDisabledNode)
-		distributed.RecoverCacheFromPeer(peerManager, cache)
+	if raftNode != nil {
+		raftNode.Start()
 	}
 
-	// Iniciar servidor
-	server.StartServer(&config, peerManager, cache)
+	distributed.MarkReady()
+	select {}
 }
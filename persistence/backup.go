@@ -0,0 +1,394 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Nombre de los backups: backup-20261019T030000Z.phx (hora UTC)
+const (
+	backupPrefix     = "backup-"
+	backupExt        = ".phx"
+	backupTimeFormat = "20060102T150405Z"
+	latestBackup     = "latest"
+)
+
+var (
+	ErrBackupNotFound  = errors.New("el backup no existe")
+	ErrInvalidBackup   = errors.New("nombre de backup no válido")
+	ErrInvalidSchedule = errors.New(`programación no válida ("@every 6h", "@hourly", "@daily" o "@daily 03:30")`)
+)
+
+// BackupInfo describe un backup guardado
+type BackupInfo struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	Bytes     int64  `json:"bytes"`
+	Keys      int    `json:"keys,omitempty"` // Solo en el backup recién guardado
+}
+
+// BackupStatus es la respuesta de /admin/backups
+type BackupStatus struct {
+	Dir        string       `json:"dir"`
+	Schedule   string       `json:"schedule"`
+	Retention  int          `json:"retention"`
+	Next       string       `json:"next,omitempty"`
+	LastBackup *BackupInfo  `json:"last_backup,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Backups    []BackupInfo `json:"backups"`
+}
+
+// RestoreReport es la respuesta de /admin/restore
+type RestoreReport struct {
+	Snapshot  string                     `json:"snapshot"`
+	CreatedAt string                     `json:"created_at"`
+	Restored  int                        `json:"restored"`
+	Expired   int                        `json:"expired"` // Claves del backup que ya han caducado
+	Cluster   *distributed.ClusterReport `json:"cluster,omitempty"`
+}
+
+// schedule es cuándo se hacen los backups: cada cierto tiempo (alineado con el reloj,
+// como cron) o una vez al día a una hora
+type schedule struct {
+	every  time.Duration
+	daily  bool
+	hour   int
+	minute int
+}
+
+// parseSchedule interpreta "@every <duración>", "@hourly", "@daily" y "@daily HH:MM"
+func parseSchedule(spec string) (schedule, error) {
+	fields := strings.Fields(spec)
+	switch {
+	case len(fields) == 2 && fields[0] == "@every":
+		every, err := time.ParseDuration(fields[1])
+		if err != nil || every < time.Second {
+			return schedule{}, ErrInvalidSchedule
+		}
+		return schedule{every: every}, nil
+	case len(fields) == 1 && fields[0] == "@hourly":
+		return schedule{every: time.Hour}, nil
+	case len(fields) == 1 && fields[0] == "@daily":
+		return schedule{daily: true}, nil
+	case len(fields) == 2 && fields[0] == "@daily":
+		at, err := time.Parse("15:04", fields[1])
+		if err != nil {
+			return schedule{}, ErrInvalidSchedule
+		}
+		return schedule{daily: true, hour: at.Hour(), minute: at.Minute()}, nil
+	default:
+		return schedule{}, ErrInvalidSchedule
+	}
+}
+
+// next devuelve el siguiente instante programado después de 'now'
+func (sc schedule) next(now time.Time) time.Time {
+	if !sc.daily {
+		return now.Truncate(sc.every).Add(sc.every)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), sc.hour, sc.minute, 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// BackupScheduler guarda snapshots con fecha en un directorio según la programación
+// y conserva los últimos 'retention'
+type BackupScheduler struct {
+	cache     *internal.Cache
+	address   string
+	dir       string
+	spec      string
+	schedule  schedule
+	retention int
+
+	backupMu sync.Mutex // Un solo backup o restauración a la vez
+	statusMu sync.Mutex
+	next     time.Time
+	last     *BackupInfo
+	lastErr  string
+}
+
+// BackupScheduler activo, para los handlers de administración
+var activeBackups *BackupScheduler
+
+// NewBackupScheduler crea el programador de backups
+func NewBackupScheduler(config *configuration.Config, cache *internal.Cache) *BackupScheduler {
+	sched, err := parseSchedule(config.Backup.Schedule)
+	if err != nil {
+		log.Fatalf("❌ backup.schedule %q: %v", config.Backup.Schedule, err)
+	}
+
+	b := &BackupScheduler{
+		cache:     cache,
+		address:   config.AdvertiseAddress,
+		dir:       config.Backup.Dir,
+		spec:      config.Backup.Schedule,
+		schedule:  sched,
+		retention: config.Backup.Retention,
+	}
+	activeBackups = b
+	return b
+}
+
+// Start hace los backups según la programación
+func (b *BackupScheduler) Start() {
+	go func() {
+		for {
+			next := b.schedule.next(time.Now())
+			b.statusMu.Lock()
+			b.next = next
+			b.statusMu.Unlock()
+
+			time.Sleep(time.Until(next))
+			b.Backup()
+		}
+	}()
+	log.Printf("🗄️ Backups en %s (%s, se conservan %d)", b.dir, b.spec, b.retention)
+}
+
+// Backup guarda un snapshot con la fecha actual y borra los que sobran
+func (b *BackupScheduler) Backup() (BackupInfo, error) {
+	b.backupMu.Lock()
+	defer b.backupMu.Unlock()
+
+	now := time.Now().UTC()
+	info := BackupInfo{Name: backupPrefix + now.Format(backupTimeFormat) + backupExt, CreatedAt: now.Format(time.RFC3339)}
+
+	data := captureSnapshot(b.cache, b.address)
+	size, err := writeSnapshot(filepath.Join(b.dir, info.Name), data)
+
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+	if err != nil {
+		b.lastErr = err.Error()
+		log.Printf("⚠️ Error guardando el backup %s: %v", info.Name, err)
+		return info, err
+	}
+	info.Bytes = int64(size)
+	info.Keys = len(data.Entries)
+	b.last = &info
+	b.lastErr = ""
+	log.Printf("🗄️ Backup %s guardado: %d claves", info.Name, info.Keys)
+
+	b.prune()
+	return info, nil
+}
+
+// prune borra los backups más antiguos por encima de la retención
+func (b *BackupScheduler) prune() {
+	backups, err := b.List()
+	if err != nil {
+		return
+	}
+	for _, old := range backups[min(b.retention, len(backups)):] {
+		if err := os.Remove(filepath.Join(b.dir, old.Name)); err != nil {
+			log.Printf("⚠️ No se pudo borrar el backup %s: %v", old.Name, err)
+			continue
+		}
+		log.Printf("🗑️ Backup %s borrado por la retención", old.Name)
+	}
+}
+
+// List devuelve los backups del directorio, el más reciente primero
+func (b *BackupScheduler) List() ([]BackupInfo, error) {
+	files, err := filepath.Glob(filepath.Join(b.dir, backupPrefix+"*"+backupExt))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []BackupInfo{}
+	for _, file := range files {
+		name := filepath.Base(file)
+		createdAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt))
+		if err != nil {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: name, CreatedAt: createdAt.Format(time.RFC3339), Bytes: stat.Size()})
+	}
+
+	// La fecha del nombre ordena los backups
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Status devuelve la programación y los backups guardados
+func (b *BackupScheduler) Status() BackupStatus {
+	backups, err := b.List()
+
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+
+	status := BackupStatus{Dir: b.dir, Schedule: b.spec, Retention: b.retention, LastBackup: b.last, Error: b.lastErr, Backups: backups}
+	if !b.next.IsZero() {
+		status.Next = b.next.Format(time.RFC3339)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// resolve devuelve el fichero de un backup por su nombre ("latest" es el más reciente)
+func (b *BackupScheduler) resolve(name string) (string, error) {
+	if name == latestBackup {
+		backups, err := b.List()
+		if err != nil {
+			return "", err
+		}
+		if len(backups) == 0 {
+			return "", ErrBackupNotFound
+		}
+		name = backups[0].Name
+	}
+
+	// Solo ficheros del directorio de backups
+	if filepath.Base(name) != name || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+		return "", ErrInvalidBackup
+	}
+	path := filepath.Join(b.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrBackupNotFound
+	}
+	return path, nil
+}
+
+// Restore sustituye la caché por la de un backup. Con 'cluster' también sustituye la
+// de los peers activos (sin las claves solo locales)
+func (b *BackupScheduler) Restore(name string, cluster bool, peerManager *distributed.PeerManager) (RestoreReport, error) {
+	b.backupMu.Lock()
+	defer b.backupMu.Unlock()
+
+	path, err := b.resolve(name)
+	if err != nil {
+		return RestoreReport{}, err
+	}
+	data, err := readSnapshot(path)
+	if err != nil {
+		return RestoreReport{}, err
+	}
+
+	// Las claves restauradas llevan una versión nueva: con la del backup perderían
+	// frente a las copias posteriores de los peers, que volverían con el siguiente diff
+	version := b.cache.NextVersion()
+	for i := range data.Entries {
+		data.Entries[i].Version = version
+	}
+
+	report := RestoreReport{Snapshot: filepath.Base(path), CreatedAt: time.UnixMilli(data.CreatedAt).UTC().Format(time.RFC3339)}
+	replaceLocal := func() int {
+		internal.CacheMutex.Lock()
+		defer internal.CacheMutex.Unlock()
+
+		b.cache.FlushAll()
+		report.Restored, report.Expired = applySnapshot(b.cache, data)
+		return report.Restored
+	}
+
+	if !cluster {
+		replaceLocal()
+	} else {
+		var entries []internal.CacheEntry
+		now := time.Now().UnixMilli()
+		for _, entry := range data.Entries {
+			if !entry.Local && entry.ExpiresAt > now {
+				entries = append(entries, entry.CacheEntry)
+			}
+		}
+		result := distributed.ReplaceCluster(peerManager, entries, replaceLocal)
+		report.Cluster = &result
+	}
+
+	log.Printf("♻️ Backup %s (%s) restaurado: %d claves, %d caducadas", report.Snapshot, report.CreatedAt, report.Restored, report.Expired)
+	return report, nil
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleBackups devuelve los backups (GET) o guarda uno en el momento (POST)
+func HandleBackups(ctx *fasthttp.RequestCtx) {
+	if activeBackups == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	status := fasthttp.StatusOK
+	if ctx.IsPost() {
+		if _, err := activeBackups.Backup(); err != nil {
+			status = fasthttp.StatusInternalServerError
+		}
+	}
+
+	data, _ := json.Marshal(activeBackups.Status())
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+// HandleRestore restaura el backup 'snapshot' en este nodo o, con 'cluster=true', en
+// todo el cluster
+func HandleRestore(peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) {
+	if activeBackups == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		return
+	}
+
+	name := string(ctx.QueryArgs().Peek("snapshot"))
+	if name == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Parámetro 'snapshot' es requerido"}`)
+		return
+	}
+	cluster, _ := strconv.ParseBool(string(ctx.QueryArgs().Peek("cluster")))
+
+	report, err := activeBackups.Restore(name, cluster, peerManager)
+	if err != nil {
+		status := fasthttp.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrBackupNotFound):
+			status = fasthttp.StatusNotFound
+		case errors.Is(err, ErrInvalidBackup):
+			status = fasthttp.StatusBadRequest
+		case errors.Is(err, security.ErrUnknownKey), errors.Is(err, security.ErrDecrypt):
+			status = fasthttp.StatusUnprocessableEntity
+		}
+		errJSON, _ := json.Marshal(fmt.Sprintf("❌ %v", err))
+		ctx.SetStatusCode(status)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %s}`, errJSON))
+		return
+	}
+
+	data, _ := json.Marshal(report)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,245 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"phoenixcache/internal"
+	"phoenixcache/security"
+)
+
+// Formatos de los volcados de administración
+const (
+	FormatJSONL = "jsonl" // Una entrada JSON por línea
+	FormatCSV   = "csv"   // Cabecera key,value,expires_at,version
+)
+
+var (
+	ErrUnknownFormat   = errors.New("formato desconocido (jsonl o csv)")
+	ErrInvalidDumpPath = errors.New("la ruta debe ser relativa al directorio de volcados y sin '..'")
+)
+
+// Tamaño máximo de una línea JSONL
+const maxDumpLine = 64 << 20
+
+var csvHeader = []string{"key", "value", "expires_at", "version"}
+
+// DumpEntry es una clave de un volcado. El valor va en base64 y la expiración es
+// un instante absoluto (RFC 3339), así que el volcado se puede cargar más tarde o en
+// otro entorno
+type DumpEntry struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"` // base64
+	ExpiresAt time.Time `json:"expires_at"`
+	Version   uint64    `json:"version,omitempty"`
+}
+
+// DecodedValue devuelve el valor original
+func (e DumpEntry) DecodedValue() (string, error) {
+	value, err := base64.StdEncoding.DecodeString(e.Value)
+	return string(value), err
+}
+
+// ContentType devuelve el tipo MIME de un formato
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// ValidFormat indica si se sabe leer y escribir el formato
+func ValidFormat(format string) bool {
+	return format == FormatJSONL || format == FormatCSV
+}
+
+// WriteDump escribe las entradas en el formato indicado
+func WriteDump(w io.Writer, format string, entries []internal.CacheEntry) error {
+	switch format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := encoder.Encode(toDumpEntry(entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(csvHeader)
+		for _, entry := range entries {
+			dump := toDumpEntry(entry)
+			writer.Write([]string{dump.Key, dump.Value, dump.ExpiresAt.Format(time.RFC3339Nano), strconv.FormatUint(dump.Version, 10)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// ReadDump lee un volcado. Las líneas que no se pueden leer no detienen la lectura:
+// se devuelven en 'invalid' con su número de línea
+func ReadDump(r io.Reader, format string) (entries []DumpEntry, invalid []string, err error) {
+	switch format {
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxDumpLine)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var entry DumpEntry
+			if err := json.Unmarshal([]byte(text), &entry); err != nil {
+				invalid = append(invalid, fmt.Sprintf("línea %d: %v", line, err))
+				continue
+			}
+			if err := validateEntry(entry); err != nil {
+				invalid = append(invalid, fmt.Sprintf("línea %d: %v", line, err))
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		return entries, invalid, scanner.Err()
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return entries, invalid, nil
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				invalid = append(invalid, fmt.Sprintf("línea %d: %v", parseErr.Line, parseErr.Err))
+				continue
+			}
+			if err != nil {
+				return entries, invalid, err
+			}
+			if line == 1 && len(record) > 0 && record[0] == csvHeader[0] {
+				continue
+			}
+			entry, err := parseCSVRecord(record)
+			if err == nil {
+				err = validateEntry(entry)
+			}
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("línea %d: %v", line, err))
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		return nil, nil, ErrUnknownFormat
+	}
+}
+
+func toDumpEntry(entry internal.CacheEntry) DumpEntry {
+	return DumpEntry{
+		Key:       entry.Key,
+		Value:     base64.StdEncoding.EncodeToString([]byte(entry.Value)),
+		ExpiresAt: time.UnixMilli(entry.ExpiresAt).UTC(),
+		Version:   entry.Version,
+	}
+}
+
+func parseCSVRecord(record []string) (DumpEntry, error) {
+	var entry DumpEntry
+	if len(record) < 3 {
+		return entry, fmt.Errorf("se esperaban al menos 3 columnas y hay %d", len(record))
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, record[2])
+	if err != nil {
+		return entry, fmt.Errorf("expires_at no válido: %v", err)
+	}
+	entry = DumpEntry{Key: record[0], Value: record[1], ExpiresAt: expiresAt}
+
+	if len(record) > 3 && record[3] != "" {
+		if entry.Version, err = strconv.ParseUint(record[3], 10, 64); err != nil {
+			return entry, fmt.Errorf("version no válida: %v", err)
+		}
+	}
+	return entry, nil
+}
+
+func validateEntry(entry DumpEntry) error {
+	if entry.Key == "" {
+		return errors.New("falta la clave")
+	}
+	if entry.ExpiresAt.IsZero() {
+		return errors.New("falta expires_at")
+	}
+	if _, err := entry.DecodedValue(); err != nil {
+		return fmt.Errorf("el valor no está en base64: %v", err)
+	}
+	return nil
+}
+
+// DumpPath resuelve 'name' dentro del directorio de volcados. Las rutas absolutas y
+// las que salen del directorio con '..' no se aceptan
+func DumpPath(dir, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", ErrInvalidDumpPath
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", ErrInvalidDumpPath
+		}
+	}
+	clean := filepath.Clean(name)
+	if clean == "." {
+		return "", ErrInvalidDumpPath
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// SealedDump devuelve el volcado cifrado con la clave activa
+func SealedDump(format string, entries []internal.CacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteDump(&buf, format, entries); err != nil {
+		return nil, err
+	}
+	return security.Seal(buf.Bytes()), nil
+}
+
+// WriteDumpFile escribe el volcado (cifrado si el cifrado está activo) en un fichero
+// del servidor de forma atómica y devuelve su tamaño
+func WriteDumpFile(path, format string, entries []internal.CacheEntry) (int, error) {
+	content, err := SealedDump(format, entries)
+	if err != nil {
+		return 0, err
+	}
+	return len(content), writeAtomic(path, content)
+}
+
+// ReadDumpData lee un volcado completo, descifrándolo si está cifrado
+func ReadDumpData(data []byte, format string) ([]DumpEntry, []string, error) {
+	plain, err := security.Open(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ReadDump(bytes.NewReader(plain), format)
+}
+
+// ReadDumpFile lee un volcado de un fichero del servidor
+func ReadDumpFile(path, format string) ([]DumpEntry, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ReadDumpData(data, format)
+}
@@ -0,0 +1,20 @@
+package persistence
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpPathStaysInsideDumpDir(t *testing.T) {
+	for _, name := range []string{"", ".", "/etc/passwd", "../secreto.jsonl", "a/../../b.jsonl", `..\b.jsonl`} {
+		if path, err := DumpPath("dumps", name); !errors.Is(err, ErrInvalidDumpPath) {
+			t.Fatalf("DumpPath(%q) = %q, %v; se esperaba ErrInvalidDumpPath", name, path, err)
+		}
+	}
+
+	path, err := DumpPath("dumps", "./usuarios/hoy.jsonl")
+	if err != nil || path != filepath.Join("dumps", "usuarios", "hoy.jsonl") {
+		t.Fatalf("DumpPath = %q, %v", path, err)
+	}
+}
@@ -0,0 +1,47 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"phoenixcache/security"
+)
+
+// FileSink añade los cambios a un fichero JSONL, una mutación por línea, y lo
+// sincroniza con el disco después de cada lote. Con el cifrado activo cada línea va
+// cifrada (security.SealLine)
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink abre (o crea) el fichero en modo append
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write escribe el lote completo de una vez
+func (s *FileSink) Write(batch []Mutation) error {
+	var buf bytes.Buffer
+	for _, mutation := range batch {
+		line, err := json.Marshal(mutation)
+		if err != nil {
+			return err
+		}
+		buf.Write(security.SealLine(line))
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
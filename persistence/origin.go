@@ -0,0 +1,144 @@
+package persistence
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"phoenixcache/configuration"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Orígenes configurados por prefijo de clave
+var origins []configuration.OriginConfig
+
+// Cliente de los orígenes. No normaliza las rutas para respetar las '/' escapadas
+// de las claves
+var originClient = &fasthttp.Client{DisablePathNormalizing: true}
+
+// OriginError es el rechazo de una escritura por parte del origen. Sin Status, el
+// origen no respondió
+type OriginError struct {
+	Origin      string
+	Status      int
+	ContentType string
+	Body        []byte
+	Err         error
+}
+
+func (e *OriginError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("el origen %s no respondió: %v", e.Origin, e.Err)
+	}
+	return fmt.Sprintf("el origen %s rechazó la escritura con el código %d", e.Origin, e.Status)
+}
+
+// ConfigureOrigins carga los orígenes de la configuración
+func ConfigureOrigins(config *configuration.Config) {
+	origins = config.Origins
+}
+
+// ResolveOrigin devuelve el origen de la clave (el del prefijo más largo) o nil
+func ResolveOrigin(key string) *configuration.OriginConfig {
+	var origin *configuration.OriginConfig
+	for i, candidate := range origins {
+		if strings.HasPrefix(key, candidate.Prefix) && (origin == nil || len(candidate.Prefix) > len(origin.Prefix)) {
+			origin = &origins[i]
+		}
+	}
+	return origin
+}
+
+// WriteThrough escribe el valor en el origen de la clave, si su prefijo está en modo
+// write-through, y espera a que lo confirme con un 2xx. Devuelve un *OriginError si
+// el origen no lo acepta; en ese caso la clave no debe guardarse en la caché
+func WriteThrough(key string, value []byte, ttl time.Duration) error {
+	origin := ResolveOrigin(key)
+	if origin == nil || !origin.WriteThrough {
+		return nil
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	target := originURL(origin.URL, key)
+	req.SetRequestURI(target)
+	req.Header.SetMethod(origin.WriteMethod)
+	req.Header.SetContentType("application/octet-stream")
+	req.Header.Set("X-Phoenix-Key", key)
+	req.Header.Set("X-Phoenix-TTL", strconv.Itoa(int(ttl.Seconds())))
+	for name, value := range origin.Headers {
+		req.Header.Set(name, value)
+	}
+	req.SetBody(value)
+
+	if err := originClient.DoTimeout(req, resp, time.Duration(origin.Timeout)*time.Millisecond); err != nil {
+		return &OriginError{Origin: target, Err: err}
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return &OriginError{
+			Origin:      target,
+			Status:      resp.StatusCode(),
+			ContentType: string(resp.Header.ContentType()),
+			Body:        append([]byte(nil), resp.Body()...),
+		}
+	}
+	return nil
+}
+
+// originURL sustituye {key} en la URL del origen o, si no aparece, añade el parámetro 'key'
+func originURL(base, key string) string {
+	if strings.Contains(base, "{key}") {
+		return strings.ReplaceAll(base, "{key}", url.PathEscape(key))
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "key=" + url.QueryEscape(key)
+}
+
+// ReadThrough carga una clave de su origen, si su prefijo lo permite (read_through).
+// El TTL es el de la cabecera X-Phoenix-TTL de la respuesta o, si no viene, el del
+// origen. Un 404 indica que el origen no tiene la clave
+func ReadThrough(key string) ([]byte, time.Duration, bool, error) {
+	origin := ResolveOrigin(key)
+	if origin == nil || !origin.ReadThrough {
+		return nil, 0, false, nil
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	target := originURL(origin.URL, key)
+	req.SetRequestURI(target)
+	req.Header.Set("X-Phoenix-Key", key)
+	for name, value := range origin.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if err := originClient.DoTimeout(req, resp, time.Duration(origin.Timeout)*time.Millisecond); err != nil {
+		return nil, 0, false, &OriginError{Origin: target, Err: err}
+	}
+	if resp.StatusCode() == fasthttp.StatusNotFound {
+		return nil, 0, false, nil
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, 0, false, fmt.Errorf("el origen %s respondió con el código %d", target, resp.StatusCode())
+	}
+
+	ttl := time.Duration(origin.LoadTTL) * time.Second
+	if header := resp.Header.Peek("X-Phoenix-TTL"); len(header) > 0 {
+		if seconds, err := strconv.Atoi(string(header)); err == nil && seconds > 0 {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	return append([]byte(nil), resp.Body()...), ttl, true, nil
+}
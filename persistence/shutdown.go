@@ -0,0 +1,41 @@
+package persistence
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Tareas que se ejecutan, en orden de registro, al recibir SIGINT o SIGTERM
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks []func()
+	shutdownOnce  sync.Once
+)
+
+// onShutdown registra una tarea para antes de salir. La primera llamada empieza a
+// escuchar las señales
+func onShutdown(hook func()) {
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, hook)
+	shutdownMu.Unlock()
+
+	shutdownOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Printf("🛑 Recibida la señal %v, cerrando el nodo", sig)
+
+			shutdownMu.Lock()
+			hooks := shutdownHooks
+			shutdownMu.Unlock()
+			for _, hook := range hooks {
+				hook()
+			}
+			os.Exit(0)
+		}()
+	})
+}
@@ -0,0 +1,317 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Espera máxima entre reintentos de un lote y para vaciar las colas al parar el nodo
+const (
+	maxSinkBackoff   = time.Minute
+	sinkDrainTimeout = 10 * time.Second
+)
+
+// Operaciones de una mutación
+const (
+	MutationSet           = "set"
+	MutationRemove        = "remove"
+	MutationRemovePattern = "remove_pattern"
+	MutationFlush         = "flush"
+)
+
+// Mutation es un cambio de la caché que se envía a un almacén externo
+type Mutation struct {
+	Op        string `json:"op"`
+	Key       string `json:"key,omitempty"`        // Clave, o patrón en remove_pattern
+	Value     string `json:"value,omitempty"`      // base64
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix ms
+	Version   uint64 `json:"version,omitempty"`
+	Time      int64  `json:"time"` // Unix ms en que se hizo el cambio
+}
+
+// Sink es un almacén externo que recibe los cambios de la caché en lotes, en el orden
+// en que se hicieron. Si Write falla el lote entero se reintenta más tarde, así que
+// escribir dos veces el mismo lote no debe romper nada
+type Sink interface {
+	Write(batch []Mutation) error
+}
+
+// SinkStatus son las métricas de un sink que se exponen en /admin/sinks
+type SinkStatus struct {
+	Name           string  `json:"name"`
+	Type           string  `json:"type"`
+	Pending        int     `json:"pending"`
+	MaxQueue       int     `json:"max_queue"`
+	LagSeconds     float64 `json:"lag_seconds"` // Antigüedad del cambio pendiente más viejo
+	Written        uint64  `json:"written"`
+	Failed         uint64  `json:"failed_batches"`
+	Retries        int     `json:"retries"` // Intentos fallidos seguidos del lote actual
+	Dropped        uint64  `json:"dropped"`
+	Blocked        uint64  `json:"blocked"` // Escrituras que esperaron por la cola llena
+	BlockedSeconds float64 `json:"blocked_seconds"`
+	LastFlush      string  `json:"last_flush,omitempty"`
+	NextRetry      string  `json:"next_retry,omitempty"`
+	LastError      string  `json:"last_error,omitempty"`
+}
+
+// sinkWriter acumula los cambios de un sink y se los entrega en lotes (write-behind)
+type sinkWriter struct {
+	config configuration.SinkConfig
+	sink   Sink
+
+	mu        sync.Mutex
+	queue     []Mutation
+	written   uint64
+	failed    uint64
+	retries   int
+	dropped   uint64
+	blocked   uint64
+	blockedIn time.Duration
+	nextRetry time.Time
+	lastFlush time.Time
+	lastError string
+}
+
+// Sinks activos
+var sinkWriters []*sinkWriter
+
+// StartSinks crea los sinks de la configuración y arranca su envío periódico. Al
+// parar el nodo se intenta vaciar las colas
+func StartSinks(config *configuration.Config) {
+	for _, sinkConfig := range config.Sinks {
+		sink, err := newSink(sinkConfig, config.AdvertiseAddress)
+		if err != nil {
+			log.Fatalf("❌ No se puede crear el sink %s: %v", sinkConfig.Name, err)
+		}
+		writer := &sinkWriter{config: sinkConfig, sink: sink}
+		sinkWriters = append(sinkWriters, writer)
+		go writer.run()
+		log.Printf("🚰 Sink %s (%s) activo", sinkConfig.Name, sinkConfig.Type)
+	}
+
+	if len(sinkWriters) > 0 {
+		onShutdown(drainSinks)
+	}
+}
+
+func newSink(config configuration.SinkConfig, address string) (Sink, error) {
+	switch config.Type {
+	case "file":
+		return NewFileSink(config.Path)
+	case "webhook":
+		return NewWebhookSink(config.URL, config.Headers, address, time.Duration(config.Timeout)*time.Millisecond), nil
+	default:
+		return nil, fmt.Errorf("tipo desconocido %q", config.Type)
+	}
+}
+
+// WriteBehind encola un cambio hecho por un cliente en los sinks cuyo filtro lo acepta
+func WriteBehind(msg distributed.SyncMessage) {
+	if len(sinkWriters) == 0 {
+		return
+	}
+
+	mutation := Mutation{Key: msg.Key, Time: time.Now().UnixMilli()}
+	switch msg.Action {
+	case "set":
+		mutation.Op = MutationSet
+		mutation.Value = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(msg.Value)))
+		mutation.ExpiresAt = msg.ExpiresAt
+		mutation.Version = msg.Version
+	case "remove":
+		mutation.Op = MutationRemove
+	case "removePattern":
+		mutation.Op = MutationRemovePattern
+	case "flush":
+		mutation.Op = MutationFlush
+	default:
+		return
+	}
+
+	for _, writer := range sinkWriters {
+		if writer.accepts(mutation) {
+			writer.enqueue(mutation)
+		}
+	}
+}
+
+// SinkStatuses devuelve las métricas de los sinks
+func SinkStatuses() []SinkStatus {
+	status := make([]SinkStatus, 0, len(sinkWriters))
+	for _, writer := range sinkWriters {
+		status = append(status, writer.status())
+	}
+	return status
+}
+
+// HandleSinks devuelve las métricas de los sinks (/admin/sinks)
+func HandleSinks(ctx *fasthttp.RequestCtx) {
+	data, _ := json.Marshal(SinkStatuses())
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
+
+// accepts aplica el filtro de prefijos del sink. Los borrados por patrón y los flush
+// solo se envían si no hay filtro
+func (w *sinkWriter) accepts(mutation Mutation) bool {
+	if len(w.config.Prefixes) == 0 {
+		return true
+	}
+	if mutation.Op != MutationSet && mutation.Op != MutationRemove {
+		return false
+	}
+	for _, prefix := range w.config.Prefixes {
+		if strings.HasPrefix(mutation.Key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue añade el cambio a la cola. Con la cola llena, en modo 'block' la escritura
+// espera a que haya sitio como mucho 'block_timeout_in_ms'; si sigue llena (o en
+// modo 'drop') se descarta el cambio más antiguo
+func (w *sinkWriter) enqueue(mutation Mutation) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.queue) >= w.config.MaxQueue && w.config.OnFull == "block" {
+		start := time.Now()
+		deadline := start.Add(time.Duration(w.config.BlockTimeout) * time.Millisecond)
+		for len(w.queue) >= w.config.MaxQueue && time.Now().Before(deadline) {
+			w.mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			w.mu.Lock()
+		}
+		w.blocked++
+		w.blockedIn += time.Since(start)
+	}
+
+	if len(w.queue) >= w.config.MaxQueue {
+		w.queue = w.queue[1:]
+		w.dropped++
+	}
+	w.queue = append(w.queue, mutation)
+}
+
+func (w *sinkWriter) run() {
+	interval := time.Duration(w.config.FlushInterval) * time.Millisecond
+	for {
+		time.Sleep(interval)
+		for w.flush(false) {
+		}
+	}
+}
+
+// flush entrega el siguiente lote. Si falla, el lote se queda en la cola y se reintenta
+// con una espera que se duplica en cada fallo; con 'max_retries' se descarta al agotar
+// los reintentos. 'force' ignora la espera. Devuelve true si quedan más cambios por enviar
+func (w *sinkWriter) flush(force bool) bool {
+	w.mu.Lock()
+	n := len(w.queue)
+	if n == 0 || (!force && time.Now().Before(w.nextRetry)) {
+		w.mu.Unlock()
+		return false
+	}
+	if n > w.config.BatchSize {
+		n = w.config.BatchSize
+	}
+	droppedBefore := w.dropped
+	batch := make([]Mutation, n)
+	copy(batch, w.queue[:n])
+	w.mu.Unlock()
+
+	err := w.sink.Write(batch)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Los cambios descartados mientras se enviaba salieron de la cabeza de la cola
+	n -= int(w.dropped - droppedBefore)
+
+	if err != nil {
+		w.failed++
+		w.retries++
+		w.lastError = err.Error()
+		if w.config.MaxRetries > 0 && w.retries > w.config.MaxRetries {
+			log.Printf("⚠️ Sink %s: se descartan %d cambios tras %d intentos: %v", w.config.Name, len(batch), w.retries, err)
+			w.discard(n)
+			return len(w.queue) > 0
+		}
+		backoff := time.Duration(w.config.RetryBackoff) * time.Millisecond << min(w.retries-1, 16)
+		w.nextRetry = time.Now().Add(min(backoff, maxSinkBackoff))
+		log.Printf("⚠️ Error escribiendo en el sink %s (intento %d, siguiente en %v): %v", w.config.Name, w.retries, min(backoff, maxSinkBackoff), err)
+		return false
+	}
+
+	if n > 0 {
+		w.queue = w.queue[n:]
+	}
+	w.written += uint64(len(batch))
+	w.retries = 0
+	w.nextRetry = time.Time{}
+	w.lastFlush = time.Now()
+	w.lastError = ""
+	return len(w.queue) > 0
+}
+
+// discard quita de la cola el lote que no se pudo entregar
+func (w *sinkWriter) discard(n int) {
+	if n > 0 {
+		w.queue = w.queue[n:]
+		w.dropped += uint64(n)
+	}
+	w.retries = 0
+	w.nextRetry = time.Time{}
+}
+
+func (w *sinkWriter) status() SinkStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := SinkStatus{
+		Name:           w.config.Name,
+		Type:           w.config.Type,
+		Pending:        len(w.queue),
+		MaxQueue:       w.config.MaxQueue,
+		Written:        w.written,
+		Failed:         w.failed,
+		Retries:        w.retries,
+		Dropped:        w.dropped,
+		Blocked:        w.blocked,
+		BlockedSeconds: w.blockedIn.Seconds(),
+		LastError:      w.lastError,
+	}
+	if len(w.queue) > 0 {
+		status.LagSeconds = time.Since(time.UnixMilli(w.queue[0].Time)).Seconds()
+	}
+	if !w.lastFlush.IsZero() {
+		status.LastFlush = w.lastFlush.Format(time.RFC3339)
+	}
+	if !w.nextRetry.IsZero() {
+		status.NextRetry = w.nextRetry.Format(time.RFC3339)
+	}
+	return status
+}
+
+// drainSinks intenta entregar lo pendiente antes de salir, sin esperar entre reintentos
+func drainSinks() {
+	deadline := time.Now().Add(sinkDrainTimeout)
+	for _, writer := range sinkWriters {
+		for time.Now().Before(deadline) && writer.flush(true) {
+		}
+		if pending := writer.status().Pending; pending > 0 {
+			log.Printf("⚠️ Sink %s: %d cambios sin entregar al salir", writer.config.Name, pending)
+		}
+	}
+}
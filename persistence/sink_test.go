@@ -0,0 +1,87 @@
+package persistence
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+)
+
+// webhookServer rechaza los primeros lotes y guarda los que acepta
+type webhookServer struct {
+	mu       sync.Mutex
+	reject   int
+	batches  []WebhookBatch
+	lastAuth string
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reject > 0 {
+		s.reject--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var batch WebhookBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.batches = append(s.batches, batch)
+	s.lastAuth = r.Header.Get("Authorization")
+}
+
+func TestWebhookSinkRetriesFailedBatches(t *testing.T) {
+	hook := &webhookServer{reject: 1}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, map[string]string{"Authorization": "Bearer abc"}, "http://nodo-a:8080", time.Second)
+	writer := &sinkWriter{
+		config: configuration.SinkConfig{Name: "db", Type: "webhook", BatchSize: 2, MaxQueue: 100, RetryBackoff: 1},
+		sink:   sink,
+	}
+	defer func(writers []*sinkWriter) { sinkWriters = writers }(sinkWriters)
+	sinkWriters = []*sinkWriter{writer}
+
+	WriteBehind(distributed.SyncMessage{Action: "set", Key: "a", Value: "1", ExpiresAt: 1000, Version: 7})
+	WriteBehind(distributed.SyncMessage{Action: "remove", Key: "b"})
+	WriteBehind(distributed.SyncMessage{Action: "flush"})
+
+	// El primer intento falla y el lote se queda en la cola
+	if writer.flush(true) {
+		t.Fatalf("flush dio el lote por entregado tras un 503")
+	}
+	if status := writer.status(); status.Pending != 3 || status.Failed != 1 || status.LastError == "" {
+		t.Fatalf("estado tras el fallo = %+v", status)
+	}
+
+	for writer.flush(true) {
+	}
+	if status := writer.status(); status.Pending != 0 || status.Written != 3 || status.Retries != 0 {
+		t.Fatalf("estado tras reintentar = %+v", status)
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.batches) != 2 || len(hook.batches[0].Mutations) != 2 || len(hook.batches[1].Mutations) != 1 {
+		t.Fatalf("lotes recibidos = %+v, se esperaban dos de 2 y 1 cambios", hook.batches)
+	}
+	first := hook.batches[0]
+	if first.Node != "http://nodo-a:8080" || hook.lastAuth != "Bearer abc" {
+		t.Fatalf("lote sin el nodo o sin las cabeceras: %+v, Authorization %q", first, hook.lastAuth)
+	}
+	if set := first.Mutations[0]; set.Op != MutationSet || set.Key != "a" || set.Value != "MQ==" || set.Version != 7 {
+		t.Fatalf("mutación set = %+v", set)
+	}
+	if op := hook.batches[1].Mutations[0].Op; op != MutationFlush {
+		t.Fatalf("el último cambio es %q, se esperaba flush", op)
+	}
+}
@@ -0,0 +1,342 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+	"phoenixcache/security"
+	"phoenixcache/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Formato del fichero: cabecera mágica, CRC32 y longitud del contenido, y el contenido
+// (JSON comprimido con gzip, como /export)
+var snapshotMagic = []byte("PHXSNAP1")
+
+const snapshotHeaderSize = 8 + 4 + 8
+
+var ErrCorruptSnapshot = errors.New("snapshot corrupto")
+
+// SnapshotEntry es una clave guardada en el snapshot. Las claves solo locales se
+// marcan para que sigan sin replicarse después de cargarlas
+type SnapshotEntry struct {
+	internal.CacheEntry
+	Local bool `json:"local,omitempty"`
+}
+
+// snapshotData es el contenido del fichero
+type snapshotData struct {
+	Address    string          `json:"address"`
+	CreatedAt  int64           `json:"created_at"` // Unix ms
+	MaxVersion uint64          `json:"max_version"`
+	Entries    []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotStatus es el estado que se expone en /admin/snapshot
+type SnapshotStatus struct {
+	Path       string  `json:"path"`
+	Interval   int     `json:"interval_in_seconds"`
+	LastSaved  string  `json:"last_saved,omitempty"`
+	Keys       int     `json:"keys"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	Loaded     int     `json:"loaded"`                // Claves cargadas al arrancar
+	Expired    int     `json:"expired"`               // Claves que caducaron con el nodo parado
+	LoadedFrom string  `json:"loaded_from,omitempty"` // Fecha del snapshot cargado
+}
+
+// Snapshotter guarda la caché en disco cada cierto tiempo, bajo demanda y al parar
+// el nodo, y la carga al arrancar
+type Snapshotter struct {
+	cache    *internal.Cache
+	path     string
+	address  string
+	interval time.Duration
+	wal      *WAL // Registros que se compactan en cada snapshot
+
+	saveMu   sync.Mutex // Un solo guardado a la vez
+	statusMu sync.Mutex
+	status   SnapshotStatus
+}
+
+// Snapshotter activo, para los handlers de administración
+var active *Snapshotter
+
+// NewSnapshotter crea el gestor de snapshots
+func NewSnapshotter(config *configuration.Config, cache *internal.Cache) *Snapshotter {
+	s := &Snapshotter{
+		cache:    cache,
+		path:     config.Snapshot.Path,
+		address:  config.AdvertiseAddress,
+		interval: time.Duration(config.Snapshot.Interval) * time.Second,
+	}
+	s.status = SnapshotStatus{Path: s.path, Interval: config.Snapshot.Interval}
+	active = s
+	return s
+}
+
+// Start guarda el snapshot periódicamente y una última vez al recibir SIGINT o SIGTERM
+func (s *Snapshotter) Start() {
+	go func() {
+		for range time.Tick(s.interval) {
+			s.Save()
+		}
+	}()
+
+	onShutdown(func() {
+		log.Printf("💾 Guardando el snapshot antes de salir")
+		s.Save()
+	})
+}
+
+// Save escribe el snapshot de forma atómica: primero en un fichero temporal que se
+// sincroniza con el disco y después se renombra sobre el anterior
+func (s *Snapshotter) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	start := time.Now()
+
+	// Los registros del WAL anteriores a este punto quedan dentro del snapshot
+	if s.wal != nil {
+		if err := s.wal.rotate(); err != nil {
+			log.Printf("⚠️ Error rotando el WAL: %v", err)
+		}
+	}
+
+	data := captureSnapshot(s.cache, s.address)
+	size, err := writeSnapshot(s.path, data)
+
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if err != nil {
+		s.status.Error = err.Error()
+		log.Printf("⚠️ Error guardando el snapshot en %s: %v", s.path, err)
+		return err
+	}
+	s.status.Error = ""
+	s.status.LastSaved = start.Format(time.RFC3339)
+	s.status.Keys = len(data.Entries)
+	s.status.Bytes = size
+	s.status.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	if s.wal != nil {
+		s.wal.discardRotated()
+	}
+	return nil
+}
+
+// Load carga el snapshot en la caché, descartando las claves que caducaron mientras
+// el nodo estaba parado. Las claves ya presentes se mezclan por versión. Devuelve
+// false si no hay snapshot o no se puede usar
+func (s *Snapshotter) Load() bool {
+	data, err := readSnapshot(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("ℹ️ No hay snapshot en %s", s.path)
+		return false
+	}
+	if errors.Is(err, security.ErrUnknownKey) || errors.Is(err, security.ErrDecrypt) {
+		// Arrancar sin él acabaría sobrescribiéndolo con el siguiente snapshot
+		log.Fatalf("❌ No se puede descifrar el snapshot %s: %v", s.path, err)
+	}
+	if err != nil {
+		log.Printf("⚠️ No se puede usar el snapshot %s: %v", s.path, err)
+		s.statusMu.Lock()
+		s.status.Error = err.Error()
+		s.statusMu.Unlock()
+		return false
+	}
+
+	internal.CacheMutex.Lock()
+	loaded, expired := applySnapshot(s.cache, data)
+	internal.CacheMutex.Unlock()
+
+	createdAt := time.UnixMilli(data.CreatedAt)
+	s.statusMu.Lock()
+	s.status.Loaded = loaded
+	s.status.Expired = expired
+	s.status.LoadedFrom = createdAt.Format(time.RFC3339)
+	s.statusMu.Unlock()
+
+	log.Printf("💾 Snapshot de %s cargado: %d claves (%d caducadas mientras el nodo estaba parado)",
+		createdAt.Format(time.RFC3339), loaded, expired)
+	return true
+}
+
+// Status devuelve el estado de los snapshots
+func (s *Snapshotter) Status() SnapshotStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.status
+}
+
+// captureSnapshot copia el contenido de la caché
+func captureSnapshot(cache *internal.Cache, address string) snapshotData {
+	internal.CacheMutex.Lock()
+	defer internal.CacheMutex.Unlock()
+
+	items := cache.GetAll(false)
+	data := snapshotData{
+		Address:    address,
+		CreatedAt:  time.Now().UnixMilli(),
+		MaxVersion: cache.MaxVersion(),
+		Entries:    make([]SnapshotEntry, 0, len(items)),
+	}
+	for _, item := range items {
+		data.Entries = append(data.Entries, SnapshotEntry{CacheEntry: item, Local: cache.IsLocal(item.Key)})
+	}
+	return data
+}
+
+// writeSnapshot escribe el snapshot en disco y devuelve su tamaño
+func writeSnapshot(path string, data snapshotData) (int, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	content := encodeSnapshot(security.Seal(utils.CompressData(payload)))
+	return len(content), writeAtomic(path, content)
+}
+
+// readSnapshot lee y verifica un snapshot
+func readSnapshot(path string) (snapshotData, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return snapshotData{}, err
+	}
+	return decodeSnapshot(raw)
+}
+
+// applySnapshot carga en la caché las claves del snapshot que no han caducado (con
+// CacheMutex tomado). Las claves ya presentes se mezclan por versión
+func applySnapshot(cache *internal.Cache, data snapshotData) (loaded, expired int) {
+	now := time.Now()
+	for _, entry := range data.Entries {
+		expiresAt := time.UnixMilli(entry.ExpiresAt)
+		if entry.ExpiresAt <= 0 || !expiresAt.After(now) {
+			expired++
+			continue
+		}
+		if cache.SetVersioned(entry.Key, entry.Value, expiresAt, entry.Version) {
+			cache.SetLocal(entry.Key, entry.Local)
+			loaded++
+		}
+	}
+	return loaded, expired
+}
+
+func encodeSnapshot(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(snapshotHeaderSize + len(payload))
+	buf.Write(snapshotMagic)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(payload))
+	binary.Write(&buf, binary.BigEndian, uint64(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func decodeSnapshot(raw []byte) (snapshotData, error) {
+	var data snapshotData
+	if len(raw) < snapshotHeaderSize || !bytes.Equal(raw[:8], snapshotMagic) {
+		return data, fmt.Errorf("%w: cabecera no válida", ErrCorruptSnapshot)
+	}
+
+	checksum := binary.BigEndian.Uint32(raw[8:12])
+	length := binary.BigEndian.Uint64(raw[12:20])
+	payload := raw[snapshotHeaderSize:]
+	if uint64(len(payload)) != length {
+		return data, fmt.Errorf("%w: se esperaban %d bytes y hay %d", ErrCorruptSnapshot, length, len(payload))
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return data, fmt.Errorf("%w: el checksum no coincide", ErrCorruptSnapshot)
+	}
+	payload, err := security.Open(payload)
+	if err != nil {
+		return data, err
+	}
+
+	decompressed, err := utils.DecompressData(payload)
+	if err != nil {
+		return data, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	if err := json.Unmarshal(decompressed, &data); err != nil {
+		return data, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	return data, nil
+}
+
+// writeAtomic escribe el fichero de forma que tras un corte de luz quede el
+// contenido anterior completo o el nuevo completo, nunca uno a medias
+func writeAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// El renombrado solo es definitivo cuando se sincroniza el directorio
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleSnapshot devuelve el estado de los snapshots (GET) o guarda uno en el
+// momento (POST)
+func HandleSnapshot(ctx *fasthttp.RequestCtx) {
+	if active == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	status := fasthttp.StatusOK
+	if ctx.IsPost() {
+		if err := active.Save(); err != nil {
+			status = fasthttp.StatusInternalServerError
+		}
+	}
+
+	data, _ := json.Marshal(active.Status())
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
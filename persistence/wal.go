@@ -0,0 +1,451 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/internal"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Modos de fsync del WAL
+const (
+	FsyncAlways   = "always"   // Cada escritura se sincroniza con el disco antes de contestar
+	FsyncEverySec = "everysec" // Se sincroniza una vez por segundo (se pierde como mucho un segundo)
+	FsyncNever    = "never"    // Lo decide el sistema operativo
+)
+
+// Operaciones del WAL
+const (
+	walSet     = "set"
+	walRemove  = "remove"
+	walPattern = "remove_pattern"
+	walFlush   = "flush"
+	walLocal   = "local"
+)
+
+// Cada registro va precedido de su longitud y su CRC32 (uint32 big endian)
+const walRecordHeaderSize = 4 + 4
+
+// Tamaño máximo de un registro, para no reservar memoria con una longitud corrupta
+const maxWALRecordSize = 64 << 20
+
+var errCorruptRecord = errors.New("registro corrupto")
+
+// walRecord es un cambio de la caché
+type walRecord struct {
+	Op        string      `json:"op"`
+	Key       string      `json:"key,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	ExpiresAt int64       `json:"expires_at,omitempty"` // Unix ms
+	Version   uint64      `json:"version,omitempty"`
+	Local     bool        `json:"local,omitempty"`
+}
+
+// WALStatus es el estado que se expone en /admin/wal
+type WALStatus struct {
+	Path           string `json:"path"`
+	Fsync          string `json:"fsync"`
+	Bytes          int64  `json:"bytes"`
+	Records        int    `json:"records"` // Registros desde la última compactación
+	Compactions    int    `json:"compactions"`
+	LastCompaction string `json:"last_compaction,omitempty"`
+	Replayed       int    `json:"replayed"`        // Registros aplicados al arrancar
+	TruncatedBytes int64  `json:"truncated_bytes"` // Bytes corruptos descartados al arrancar
+	Error          string `json:"error,omitempty"`
+}
+
+// WAL guarda en disco cada cambio de la caché para reproducirlo al arrancar sobre
+// el último snapshot. Cuando crece demasiado se compacta guardando un snapshot nuevo
+type WAL struct {
+	cache       *internal.Cache
+	snapshotter *Snapshotter
+	path        string
+	fsync       string
+	maxSize     int64
+
+	mu         sync.Mutex
+	file       *os.File
+	dirty      bool // Hay escrituras sin sincronizar (modo everysec)
+	compacting bool
+	status     WALStatus
+}
+
+// WAL activo, para los handlers de administración
+var activeWAL *WAL
+
+// NewWAL crea el log de escrituras. Se compacta en el snapshot del Snapshotter
+func NewWAL(config *configuration.Config, cache *internal.Cache, snapshotter *Snapshotter) *WAL {
+	w := &WAL{
+		cache:       cache,
+		snapshotter: snapshotter,
+		path:        config.WAL.Path,
+		fsync:       config.WAL.Fsync,
+		maxSize:     int64(config.WAL.MaxSizeMB) << 20,
+	}
+	w.status = WALStatus{Path: w.path, Fsync: w.fsync}
+	snapshotter.wal = w
+	activeWAL = w
+	return w
+}
+
+// rotatedPath es el fichero con los registros que se están compactando
+func (w *WAL) rotatedPath() string {
+	return w.path + ".compacting"
+}
+
+// Replay aplica a la caché los registros del WAL (primero los de una compactación
+// que no llegó a terminar). Un final corrupto, p.e. por un corte de luz a mitad de
+// escritura, se trunca con un aviso. Devuelve true si se aplicó algún registro
+func (w *WAL) Replay() bool {
+	internal.CacheMutex.Lock()
+	defer internal.CacheMutex.Unlock()
+
+	replayed := 0
+	var truncated int64
+	for _, path := range []string{w.rotatedPath(), w.path} {
+		n, cut, err := w.replayFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("⚠️ Error leyendo el WAL %s: %v", path, err)
+		}
+		replayed += n
+		truncated += cut
+	}
+
+	w.mu.Lock()
+	w.status.Replayed = replayed
+	w.status.TruncatedBytes = truncated
+	w.mu.Unlock()
+
+	if replayed > 0 {
+		log.Printf("📜 WAL reproducido: %d cambios", replayed)
+	}
+	return replayed > 0
+}
+
+// replayFile aplica los registros de un fichero y trunca lo que haya a partir del
+// primer registro corrupto. Devuelve los registros aplicados y los bytes descartados
+func (w *WAL) replayFile(path string) (int, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	applied, offset := 0, 0
+	for offset < len(raw) {
+		record, size, err := decodeRecord(raw[offset:])
+		if errors.Is(err, security.ErrUnknownKey) || errors.Is(err, security.ErrDecrypt) {
+			// No es un final corrupto: truncarlo perdería los cambios
+			log.Fatalf("❌ No se puede descifrar el WAL %s en el byte %d: %v", path, offset, err)
+		}
+		if err != nil {
+			cut := int64(len(raw) - offset)
+			log.Printf("⚠️ WAL %s corrupto a partir del byte %d (%v): se descartan %d bytes", path, offset, err, cut)
+			if err := f.Truncate(int64(offset)); err != nil {
+				return applied, 0, err
+			}
+			f.Sync()
+			return applied, cut, nil
+		}
+		offset += size
+		if w.apply(record, now) {
+			applied++
+		}
+	}
+	return applied, 0, nil
+}
+
+// apply aplica un registro a la caché. Las claves que caducaron con el nodo parado
+// se ignoran
+func (w *WAL) apply(record walRecord, now time.Time) bool {
+	switch record.Op {
+	case walSet:
+		expiresAt := time.UnixMilli(record.ExpiresAt)
+		if !expiresAt.After(now) {
+			return false
+		}
+		return w.cache.SetVersioned(record.Key, record.Value, expiresAt, record.Version)
+	case walRemove:
+		w.cache.RemoveKey(record.Key)
+	case walPattern:
+		w.cache.RemovePatternKey(record.Key)
+	case walFlush:
+		w.cache.FlushAll()
+	case walLocal:
+		w.cache.SetLocal(record.Key, record.Local)
+	default:
+		return false
+	}
+	return true
+}
+
+// Start abre el WAL para añadir registros y empieza a recibir los cambios de la caché
+func (w *WAL) Start() {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatalf("❌ No se puede abrir el WAL %s: %v", w.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatalf("❌ No se puede abrir el WAL %s: %v", w.path, err)
+	}
+
+	w.mu.Lock()
+	w.file = file
+	w.status.Bytes = info.Size()
+	w.mu.Unlock()
+
+	w.cache.SetMutationLog(w)
+
+	if w.fsync == FsyncEverySec {
+		go func() {
+			for range time.Tick(time.Second) {
+				w.sync()
+			}
+		}()
+	}
+	log.Printf("📜 WAL activo en %s (fsync %s)", w.path, w.fsync)
+}
+
+// sync sincroniza con el disco las escrituras pendientes
+func (w *WAL) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.dirty || w.file == nil {
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		w.status.Error = err.Error()
+		log.Printf("⚠️ Error sincronizando el WAL: %v", err)
+		return
+	}
+	w.dirty = false
+}
+
+// Status devuelve el estado del WAL
+func (w *WAL) Status() WALStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+//********************************************************************
+// internal.MutationLog
+//********************************************************************
+
+func (w *WAL) LogSet(key string, value interface{}, expiresAt time.Time, version uint64) {
+	w.append(walRecord{Op: walSet, Key: key, Value: value, ExpiresAt: expiresAt.UnixMilli(), Version: version})
+}
+
+func (w *WAL) LogRemove(key string) {
+	w.append(walRecord{Op: walRemove, Key: key})
+}
+
+func (w *WAL) LogRemovePattern(pattern string) {
+	w.append(walRecord{Op: walPattern, Key: pattern})
+}
+
+func (w *WAL) LogFlush() {
+	w.append(walRecord{Op: walFlush})
+}
+
+func (w *WAL) LogLocal(key string, local bool) {
+	w.append(walRecord{Op: walLocal, Key: key, Local: local})
+}
+
+// append añade un registro al WAL. Un error de escritura no detiene la caché, pero
+// queda en el estado del WAL
+func (w *WAL) append(record walRecord) {
+	data, err := encodeRecord(record)
+	if err != nil {
+		log.Printf("⚠️ No se puede guardar en el WAL el cambio de %q: %v", record.Key, err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(data); err != nil {
+		w.status.Error = err.Error()
+		log.Printf("⚠️ Error escribiendo en el WAL: %v", err)
+		return
+	}
+	w.status.Bytes += int64(len(data))
+	w.status.Records++
+
+	if w.fsync == FsyncAlways {
+		if err := w.file.Sync(); err != nil {
+			w.status.Error = err.Error()
+			log.Printf("⚠️ Error sincronizando el WAL: %v", err)
+		}
+	} else {
+		w.dirty = true
+	}
+
+	if w.status.Bytes >= w.maxSize && !w.compacting {
+		w.compacting = true
+		go w.compact()
+	}
+}
+
+// compact guarda un snapshot nuevo, que descarta los registros ya incluidos en él
+func (w *WAL) compact() {
+	log.Printf("📜 Compactando el WAL (%d bytes) en un snapshot", w.Status().Bytes)
+	w.snapshotter.Save()
+
+	w.mu.Lock()
+	w.compacting = false
+	w.mu.Unlock()
+}
+
+// rotate aparta los registros actuales antes de guardar un snapshot: todos ellos
+// ya están aplicados en la caché, así que el snapshot los incluirá. Los cambios que
+// lleguen mientras tanto van al WAL nuevo y se reproducen sobre el snapshot
+func (w *WAL) rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || w.status.Bytes == 0 {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+
+	if _, err := os.Stat(w.rotatedPath()); err == nil {
+		// Quedan registros de una compactación fallida: se añaden los nuevos detrás
+		if err := appendFile(w.rotatedPath(), w.path); err != nil {
+			return err
+		}
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+	} else {
+		w.file.Close()
+		if err := os.Rename(w.path, w.rotatedPath()); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+
+	w.status.Bytes = 0
+	w.status.Records = 0
+	return nil
+}
+
+// discardRotated borra los registros apartados una vez que el snapshot está en disco
+func (w *WAL) discardRotated() {
+	if err := os.Remove(w.rotatedPath()); errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Printf("⚠️ No se pudo borrar %s: %v", w.rotatedPath(), err)
+		return
+	}
+
+	w.mu.Lock()
+	w.status.Compactions++
+	w.status.LastCompaction = time.Now().Format(time.RFC3339)
+	w.status.Error = ""
+	w.mu.Unlock()
+}
+
+// appendFile añade el contenido de 'src' al final de 'dst'
+func appendFile(dst, src string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	payload = security.Seal(payload)
+
+	data := make([]byte, walRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[walRecordHeaderSize:], payload)
+	return data, nil
+}
+
+// decodeRecord lee el primer registro y devuelve cuántos bytes ocupa
+func decodeRecord(raw []byte) (walRecord, int, error) {
+	var record walRecord
+	if len(raw) < walRecordHeaderSize {
+		return record, 0, errCorruptRecord
+	}
+
+	length := binary.BigEndian.Uint32(raw[0:4])
+	checksum := binary.BigEndian.Uint32(raw[4:8])
+	if length > maxWALRecordSize || int(length) > len(raw)-walRecordHeaderSize {
+		return record, 0, errCorruptRecord
+	}
+
+	payload := raw[walRecordHeaderSize : walRecordHeaderSize+int(length)]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record, 0, errCorruptRecord
+	}
+	payload, err := security.Open(payload)
+	if err != nil {
+		return record, 0, err
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, errCorruptRecord
+	}
+	return record, walRecordHeaderSize + int(length), nil
+}
+
+//********************************************************************
+// Handlers
+//********************************************************************
+
+// HandleWAL devuelve el estado del WAL
+func HandleWAL(ctx *fasthttp.RequestCtx) {
+	if activeWAL == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(activeWAL.Status())
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"phoenixcache/internal"
+)
+
+func TestReplayTruncatesCorruptTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+
+	var content []byte
+	for _, key := range []string{"a", "b", "c"} {
+		data, err := encodeRecord(walRecord{Op: walSet, Key: key, Value: "v", ExpiresAt: expiresAt, Version: 1})
+		if err != nil {
+			t.Fatalf("encodeRecord: %v", err)
+		}
+		content = append(content, data...)
+	}
+	valid := len(content)
+
+	// Un registro con el CRC mal y otro cortado a mitad de escritura
+	corrupt, _ := encodeRecord(walRecord{Op: walSet, Key: "d", Value: "v", ExpiresAt: expiresAt, Version: 1})
+	corrupt[4] ^= 0xff
+	partial, _ := encodeRecord(walRecord{Op: walSet, Key: "e", Value: "v", ExpiresAt: expiresAt, Version: 1})
+	content = append(content, corrupt...)
+	content = append(content, partial[:len(partial)/2]...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := internal.NewCache(1000, 1<<20, 64)
+	w := &WAL{cache: cache, path: path}
+	if !w.Replay() {
+		t.Fatalf("Replay no aplicó ningún registro")
+	}
+
+	status := w.Status()
+	if status.Replayed != 3 || status.TruncatedBytes != int64(len(content)-valid) {
+		t.Fatalf("estado = %+v, se esperaban 3 registros y %d bytes descartados", status, len(content)-valid)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, found := cache.Get(key); !found {
+			t.Fatalf("falta la clave %q tras reproducir el WAL", key)
+		}
+	}
+	if _, found := cache.Get("d"); found {
+		t.Fatalf("se aplicó el registro con el CRC corrupto")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(valid) {
+		t.Fatalf("el WAL mide %d bytes tras truncar, se esperaban %d", info.Size(), valid)
+	}
+}
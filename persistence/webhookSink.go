@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WebhookBatch es el cuerpo que se envía al webhook
+type WebhookBatch struct {
+	Node      string     `json:"node"`
+	SentAt    int64      `json:"sent_at"` // Unix ms
+	Mutations []Mutation `json:"mutations"`
+}
+
+// WebhookSink envía cada lote en un POST JSON a una URL. Cualquier respuesta 2xx
+// confirma el lote
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	node    string
+	timeout time.Duration
+}
+
+// NewWebhookSink crea el sink. 'headers' se añaden a cada petición (p.ej. Authorization)
+func NewWebhookSink(url string, headers map[string]string, node string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{url: url, headers: headers, node: node, timeout: timeout}
+}
+
+// Write envía el lote y espera la confirmación
+func (s *WebhookSink) Write(batch []Mutation) error {
+	body, err := json.Marshal(WebhookBatch{Node: s.node, SentAt: time.Now().UnixMilli(), Mutations: batch})
+	if err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(s.url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	req.SetBody(body)
+
+	if err := fasthttp.DoTimeout(req, resp, s.timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return fmt.Errorf("código de estado %d", resp.StatusCode())
+	}
+	return nil
+}
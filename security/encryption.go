@@ -0,0 +1,237 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"phoenixcache/configuration"
+)
+
+// Cabecera de los datos cifrados: magic, longitud del ID de la clave, el ID y el
+// nonce. La cabecera va autenticada junto con el contenido
+var sealedMagic = []byte("PHXENC01")
+
+var (
+	ErrUnknownKey = errors.New("cifrado con una clave desconocida")
+	ErrDecrypt    = errors.New("no se pudo descifrar (clave incorrecta o datos alterados)")
+)
+
+// keyring son las claves AES con las que se cifran los datos en disco y las
+// exportaciones. Se cifra con la activa y se descifra con cualquiera
+type keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// Claves cargadas (nil si el cifrado no está activo)
+var activeKeyring *keyring
+
+// InitEncryption carga las claves del fichero y de la variable de entorno
+func InitEncryption(config *configuration.Config) {
+	if !config.Encryption.Enabled {
+		activeKeyring = nil
+		return
+	}
+
+	ring := &keyring{keys: make(map[string]cipher.AEAD)}
+	var order []string
+	add := func(source, text string) {
+		for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, aead, err := parseKey(line)
+			if err != nil {
+				log.Fatalf("❌ Clave de cifrado no válida en %s: %v", source, err)
+			}
+			if _, exists := ring.keys[id]; !exists {
+				order = append(order, id)
+			}
+			ring.keys[id] = aead
+		}
+	}
+
+	if config.Encryption.KeyFile != "" {
+		data, err := os.ReadFile(config.Encryption.KeyFile)
+		if err != nil {
+			log.Fatalf("❌ No se pudo leer el fichero de claves %s: %v", config.Encryption.KeyFile, err)
+		}
+		add(config.Encryption.KeyFile, string(data))
+	}
+	if value := os.Getenv(config.Encryption.KeyEnv); value != "" {
+		add("$"+config.Encryption.KeyEnv, value)
+	}
+	if len(order) == 0 {
+		log.Fatalf("❌ Cifrado activo pero no hay claves (key_file o $%s)", config.Encryption.KeyEnv)
+	}
+
+	ring.active = config.Encryption.ActiveKey
+	if ring.active == "" {
+		ring.active = order[0]
+	}
+	if _, ok := ring.keys[ring.active]; !ok {
+		log.Fatalf("❌ La clave activa %q no está entre las cargadas", ring.active)
+	}
+
+	activeKeyring = ring
+	log.Printf("🔐 Cifrado en reposo activo con la clave %q (%d claves cargadas)", ring.active, len(order))
+}
+
+// parseKey lee una clave con el formato <id>:<clave en base64 de 16, 24 o 32 bytes>
+func parseKey(line string) (string, cipher.AEAD, error) {
+	id, encoded, ok := strings.Cut(line, ":")
+	id = strings.TrimSpace(id)
+	if !ok || id == "" || len(id) > 255 {
+		return "", nil, errors.New("se esperaba <id>:<clave en base64>")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", nil, fmt.Errorf("clave %q: %v", id, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("clave %q: %v", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, fmt.Errorf("clave %q: %v", id, err)
+	}
+	return id, aead, nil
+}
+
+// EncryptionEnabled indica si se cifran los datos
+func EncryptionEnabled() bool {
+	return activeKeyring != nil
+}
+
+// ActiveKeyID devuelve el ID de la clave con la que se cifra (vacío sin cifrado)
+func ActiveKeyID() string {
+	if activeKeyring == nil {
+		return ""
+	}
+	return activeKeyring.active
+}
+
+// KeyIDs devuelve los ID de las claves cargadas, ordenados
+func KeyIDs() []string {
+	ring := activeKeyring
+	if ring == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(ring.keys))
+	for id := range ring.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Seal cifra los datos con AES-GCM y la clave activa. Sin cifrado los devuelve tal cual
+func Seal(plain []byte) []byte {
+	ring := activeKeyring
+	if ring == nil {
+		return plain
+	}
+	aead := ring.keys[ring.active]
+
+	header := make([]byte, 0, len(sealedMagic)+1+len(ring.active)+aead.NonceSize())
+	header = append(header, sealedMagic...)
+	header = append(header, byte(len(ring.active)))
+	header = append(header, ring.active...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("❌ Error generando el nonce: %v", err))
+	}
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plain, header)
+}
+
+// Prefijo de una línea cifrada con SealLine (la cabecera de Seal en base64)
+var sealedLinePrefix = base64.StdEncoding.EncodeToString(sealedMagic)[:8]
+
+// SealLine cifra una línea de un fichero de texto (JSONL): el resultado de Seal va en
+// base64 para que no contenga saltos de línea. Sin cifrado la devuelve tal cual
+func SealLine(plain []byte) []byte {
+	if activeKeyring == nil {
+		return plain
+	}
+	sealed := Seal(plain)
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(line, sealed)
+	return line
+}
+
+// OpenLine descifra una línea de SealLine. Las líneas sin cifrar se devuelven tal cual
+func OpenLine(line []byte) ([]byte, error) {
+	if !bytes.HasPrefix(line, []byte(sealedLinePrefix)) {
+		return line, nil
+	}
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return Open(sealed[:n])
+}
+
+// IsSealed indica si los datos están cifrados con Seal
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// SealedKeyID devuelve el ID de la clave con la que se cifraron los datos
+func SealedKeyID(data []byte) (string, bool) {
+	if !IsSealed(data) || len(data) <= len(sealedMagic) {
+		return "", false
+	}
+	idLen := int(data[len(sealedMagic)])
+	start := len(sealedMagic) + 1
+	if len(data) < start+idLen {
+		return "", false
+	}
+	return string(data[start : start+idLen]), true
+}
+
+// Open descifra los datos de Seal. Los datos sin cifrar se devuelven tal cual, para
+// poder leer lo que se guardó antes de activar el cifrado. Los cifrados con una
+// clave que no está cargada se rechazan con ErrUnknownKey
+func Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+
+	id, ok := SealedKeyID(data)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	ring := activeKeyring
+	if ring == nil {
+		return nil, fmt.Errorf("%w %q (el cifrado no está activo)", ErrUnknownKey, id)
+	}
+	aead, ok := ring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	headerSize := len(sealedMagic) + 1 + len(id) + aead.NonceSize()
+	if len(data) < headerSize+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	header := data[:headerSize]
+	plain, err := aead.Open(nil, header[headerSize-aead.NonceSize():], data[headerSize:], header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
@@ -0,0 +1,66 @@
+package security
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"phoenixcache/configuration"
+)
+
+var (
+	oldKey = "v1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey = "v2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+// loadKeys carga un keyring desde la variable de entorno, como en el arranque
+func loadKeys(t *testing.T, keys string, active string) {
+	t.Helper()
+	t.Setenv("PHOENIX_TEST_KEYS", keys)
+	InitEncryption(&configuration.Config{Encryption: configuration.EncryptionConfig{Enabled: true, KeyEnv: "PHOENIX_TEST_KEYS", ActiveKey: active}})
+}
+
+func TestKeyringRotation(t *testing.T) {
+	defer func(ring *keyring) { activeKeyring = ring }(activeKeyring)
+	plain := []byte(`{"key":"a","value":"1"}`)
+
+	loadKeys(t, oldKey, "")
+	before := Seal(plain)
+	beforeLine := SealLine(plain)
+	if id, _ := SealedKeyID(before); id != "v1" {
+		t.Fatalf("cifrado con %q, se esperaba v1", id)
+	}
+
+	// Rotación: la clave nueva pasa a ser la activa y la antigua sigue cargada
+	loadKeys(t, oldKey+","+newKey, "v2")
+	after := Seal(plain)
+	if id, _ := SealedKeyID(after); id != "v2" {
+		t.Fatalf("tras rotar se cifra con %q, se esperaba v2", id)
+	}
+	for _, sealed := range [][]byte{before, after} {
+		if got, err := Open(sealed); err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("Open tras rotar: %q, %v", got, err)
+		}
+	}
+	if got, err := OpenLine(beforeLine); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("OpenLine de una línea cifrada con la clave antigua: %q, %v", got, err)
+	}
+	if ids := KeyIDs(); len(ids) != 2 || ActiveKeyID() != "v2" {
+		t.Fatalf("claves = %v (activa %q)", ids, ActiveKeyID())
+	}
+
+	// Retirada la clave antigua, lo que se cifró con ella ya no se puede leer
+	loadKeys(t, newKey, "")
+	if _, err := Open(before); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open con la clave retirada: %v, se esperaba ErrUnknownKey", err)
+	}
+	if got, err := Open(after); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open con la clave activa: %q, %v", got, err)
+	}
+
+	// Lo que se guardó sin cifrar se sigue leyendo
+	if got, err := OpenLine(plain); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("OpenLine de una línea sin cifrar: %q, %v", got, err)
+	}
+}
@@ -5,6 +5,7 @@ import "phoenixcache/configuration"
 func InitModule(config *configuration.Config) {
 
 	StartWhitelistUpdater(config)
+	InitEncryption(config)
 }
 
 func StartWhitelistUpdater(config *configuration.Config) {
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+//********************************************************************
+// Handlers de administración de peers
+//********************************************************************
+
+// HandleAdminListPeers devuelve los peers con su contador de fallos y su estado
+func HandleAdminListPeers(peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) {
+	jsonResponse, _ := json.Marshal(peerManager.GetPeerStatus())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(jsonResponse)
+}
+
+// HandleAdminAddPeer añade un peer en caliente (opcionalmente lo guarda en config.json)
+func HandleAdminAddPeer(config *configuration.Config, peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) {
+	peer := string(ctx.QueryArgs().Peek("peer"))
+	if peer == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Parámetro 'peer' es requerido"}`)
+		return
+	}
+
+	if distributed.IsSelf(peer) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ El peer es este mismo nodo"}`)
+		return
+	}
+
+	if !peerManager.AddPeer(peer) {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ El peer ya existe"}`)
+		return
+	}
+	log.Printf("➕ Peer %s añadido desde la API de administración", peer)
+
+	if !persistPeers(config, peerManager, ctx) {
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleAdminRemovePeer elimina un peer en caliente (opcionalmente lo guarda en config.json)
+func HandleAdminRemovePeer(config *configuration.Config, peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) {
+	peer := string(ctx.QueryArgs().Peek("peer"))
+	if peer == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Parámetro 'peer' es requerido"}`)
+		return
+	}
+
+	if !peerManager.RemovePeer(peer) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ El peer no existe"}`)
+		return
+	}
+	log.Printf("➖ Peer %s eliminado desde la API de administración", peer)
+
+	if !persistPeers(config, peerManager, ctx) {
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleAdminResync fuerza la sincronización de la caché local con un peer
+// (el indicado en 'peer' o el más actualizado). Con 'full=true' se importa la caché
+// completa en lugar del diff
+func HandleAdminResync(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	peer := string(ctx.QueryArgs().Peek("peer"))
+	full := string(ctx.QueryArgs().Peek("full")) == "true"
+
+	var err error
+	switch {
+	case full && peer != "":
+		err = distributed.RecoverCacheFrom(peerManager, peer, cache)
+	case full:
+		err = distributed.RecoverCacheFromPeer(peerManager, cache)
+	case peer != "":
+		err = distributed.RecoverCacheDiffFrom(peerManager, peer, cache)
+	default:
+		err = distributed.RecoverCacheDiff(peerManager, cache)
+	}
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ ` + err.Error() + `"}`)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// persistPeers guarda la lista de peers en config.json si se pide con 'persist=true'
+func persistPeers(config *configuration.Config, peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) bool {
+	if string(ctx.QueryArgs().Peek("persist")) != "true" {
+		return true
+	}
+
+	if err := configuration.SavePeers(config, peerManager.GetPeers); err != nil {
+		log.Printf("⚠️ Error guardando la configuración: %v", err)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Error guardando la configuración"}`)
+		return false
+	}
+	return true
+}
+
+// HandleAdminHints devuelve los hints pendientes por peer y el progreso de su reenvío
+func HandleAdminHints(peerManager *distributed.PeerManager, ctx *fasthttp.RequestCtx) {
+	hints := peerManager.Hints()
+	if hints == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ El hinted handoff no está activo"}`)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(hints.Status())
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(jsonResponse)
+}
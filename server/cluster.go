@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+	"phoenixcache/persistence"
+
+	"github.com/valyala/fasthttp"
+)
+
+//********************************************************************
+// Operaciones sobre todo el cluster, con el resultado de cada nodo
+//********************************************************************
+
+// HandleStats devuelve las estadísticas del nodo local
+func HandleStats(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, distributed.LocalStats(peerManager, cache))
+}
+
+// HandleKeys devuelve las claves locales que contienen 'pattern'
+func HandleKeys(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, cache.Keys(string(ctx.QueryArgs().Peek("pattern"))))
+}
+
+// HandleClusterList devuelve las claves de todos los nodos fusionadas por versión
+func HandleClusterList(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	truncate := true
+	if ctx.QueryArgs().Has("allValue") {
+		value := string(ctx.QueryArgs().Peek("allValue"))
+		truncate = value != "" && value != "true"
+	}
+	writeJSON(ctx, distributed.ListCluster(peerManager, cache, truncate))
+}
+
+// HandleClusterKeys devuelve las claves que contienen 'pattern' en cualquier nodo
+func HandleClusterKeys(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	pattern := string(ctx.QueryArgs().Peek("pattern"))
+	writeJSON(ctx, distributed.KeysCluster(peerManager, cache, pattern))
+}
+
+// HandleClusterStats devuelve las estadísticas de todos los nodos
+func HandleClusterStats(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, distributed.StatsCluster(peerManager, cache))
+}
+
+// HandleClusterFlush vacía la caché en todos los nodos y confirma cuáles lo han hecho.
+// Si algún nodo falla se responde 502 con el detalle
+func HandleClusterFlush(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	report := distributed.FlushCluster(peerManager, cache)
+	persistence.WriteBehind(distributed.SyncMessage{Action: "flush"})
+	log.Printf("🧹 Flush del cluster: %d nodos OK, %d con error", report.Succeeded, report.Failed)
+
+	writeJSON(ctx, report)
+	if report.Failed > 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+	}
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, payload interface{}) {
+	jsonResponse, _ := json.Marshal(payload)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(jsonResponse)
+}
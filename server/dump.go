@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+	"phoenixcache/persistence"
+	"phoenixcache/security"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Errores de línea que se devuelven como mucho en el informe de importación
+const maxImportErrors = 20
+
+// ExportReport es la respuesta de /admin/export cuando se escribe en un fichero
+type ExportReport struct {
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	Keys   int    `json:"keys"`
+	Bytes  int    `json:"bytes"`
+}
+
+// ImportReport es la respuesta de /admin/import
+type ImportReport struct {
+	Format     string   `json:"format"`
+	Path       string   `json:"path,omitempty"`
+	Imported   int      `json:"imported"`
+	Expired    int      `json:"expired"`    // Ya caducadas
+	Stale      int      `json:"stale"`      // Con una versión más antigua que la local
+	Filtered   int      `json:"filtered"`   // No contienen 'pattern'
+	Rejected   int      `json:"rejected"`   // El origen (write-through) no las aceptó
+	Strong     int      `json:"strong"`     // Gestionadas por Raft: solo cambian por su log
+	Invalid    int      `json:"invalid"`    // Líneas que no se pudieron leer
+	Replicated bool     `json:"replicated"` // Se propagaron a los peers
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// dumpPath resuelve 'path' dentro de dump_dir. Devuelve false si ya se respondió con
+// error
+func dumpPath(config *configuration.Config, ctx *fasthttp.RequestCtx) (string, bool) {
+	name := string(ctx.QueryArgs().Peek("path"))
+	if name == "" {
+		return "", true
+	}
+	path, err := persistence.DumpPath(config.DumpDir, name)
+	if err != nil {
+		writeDumpError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("'path' no válido: %v", err))
+		return "", false
+	}
+	return path, true
+}
+
+// writeDumpError responde con un error cuyo texto puede llevar comillas
+func writeDumpError(ctx *fasthttp.RequestCtx, status int, message string) {
+	errJSON, _ := json.Marshal("❌ " + message)
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(fmt.Sprintf(`{"error": %s}`, errJSON))
+}
+
+// dumpFormat lee 'format' (jsonl por defecto). Devuelve false si ya se respondió con error
+func dumpFormat(ctx *fasthttp.RequestCtx) (string, bool) {
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = persistence.FormatJSONL
+	}
+	if !persistence.ValidFormat(format) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ ` + persistence.ErrUnknownFormat.Error() + `"}`)
+		return "", false
+	}
+	return format, true
+}
+
+// HandleAdminExport vuelca las claves que contienen 'pattern' en JSONL o CSV, en la
+// respuesta o en el fichero 'path' de dump_dir. Con el cifrado activo el volcado va
+// cifrado
+func HandleAdminExport(config *configuration.Config, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	format, ok := dumpFormat(ctx)
+	if !ok {
+		return
+	}
+	path, ok := dumpPath(config, ctx)
+	if !ok {
+		return
+	}
+	pattern := string(ctx.QueryArgs().Peek("pattern"))
+
+	var entries []internal.CacheEntry
+	for _, entry := range cache.GetAll(false) {
+		if strings.Contains(entry.Key, pattern) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if path == "" && security.EncryptionEnabled() {
+		content, err := persistence.SealedDump(format, entries)
+		if err != nil {
+			writeDumpError(ctx, fasthttp.StatusInternalServerError, fmt.Sprintf("No se pudo generar el volcado: %v", err))
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("application/octet-stream")
+		ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="phoenix-dump.%s.enc"`, format))
+		ctx.SetBody(content)
+		return
+	}
+
+	if path == "" {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType(persistence.ContentType(format))
+		ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="phoenix-dump.%s"`, format))
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := persistence.WriteDump(w, format, entries); err != nil {
+				log.Printf("⚠️ Error enviando el volcado: %v", err)
+			}
+		})
+		return
+	}
+
+	size, err := persistence.WriteDumpFile(path, format, entries)
+	if err != nil {
+		writeDumpError(ctx, fasthttp.StatusInternalServerError, fmt.Sprintf("No se pudo escribir %s: %v", path, err))
+		return
+	}
+	log.Printf("📤 Volcado de %d claves en %s", len(entries), path)
+	writeJSON(ctx, ExportReport{Format: format, Path: path, Keys: len(entries), Bytes: size})
+}
+
+// HandleAdminImport carga un volcado JSONL o CSV del cuerpo de la petición o del
+// fichero 'path' de dump_dir. Cada clave se trata como una escritura: gana la versión
+// más reciente, pasa por su origen si es write-through, se aplica el modo de
+// replicación de su prefijo, se encola para los sinks y se propaga a los peers salvo
+// con 'replicate=false'. Como el resto de escrituras, el router la rechaza en los
+// observers, en minoría y mientras el nodo arranca
+func HandleAdminImport(config *configuration.Config, peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	format, ok := dumpFormat(ctx)
+	if !ok {
+		return
+	}
+	path, ok := dumpPath(config, ctx)
+	if !ok {
+		return
+	}
+	pattern := string(ctx.QueryArgs().Peek("pattern"))
+	replicateChanges := string(ctx.QueryArgs().Peek("replicate")) != "false"
+
+	var entries []persistence.DumpEntry
+	var invalid []string
+	var err error
+	if path != "" {
+		entries, invalid, err = persistence.ReadDumpFile(path, format)
+	} else {
+		entries, invalid, err = persistence.ReadDumpData(ctx.PostBody(), format)
+	}
+	if err != nil {
+		writeDumpError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("No se pudo leer el volcado: %v", err))
+		return
+	}
+
+	report := ImportReport{Format: format, Path: path, Invalid: len(invalid), Replicated: replicateChanges}
+	if len(invalid) > maxImportErrors {
+		invalid = invalid[:maxImportErrors]
+	}
+	report.Errors = invalid
+
+	now := time.Now()
+	for _, entry := range entries {
+		if !strings.Contains(entry.Key, pattern) {
+			report.Filtered++
+			continue
+		}
+		if cache.IsProtected(entry.Key) {
+			report.Strong++
+			continue
+		}
+		if !entry.ExpiresAt.After(now) {
+			report.Expired++
+			continue
+		}
+
+		value, _ := entry.DecodedValue()
+		if entry.Version != 0 && entry.Version < cache.Version(entry.Key) {
+			report.Stale++
+			continue
+		}
+		if err := persistence.WriteThrough(entry.Key, []byte(value), time.Until(entry.ExpiresAt)); err != nil {
+			report.Rejected++
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", entry.Key, err))
+			}
+			continue
+		}
+		if !cache.SetVersioned(entry.Key, value, entry.ExpiresAt, entry.Version) {
+			report.Stale++
+			continue
+		}
+		report.Imported++
+
+		mode, _ := distributed.ResolveReplication(entry.Key, "")
+		cache.SetLocal(entry.Key, mode == distributed.ReplicationLocal)
+		msg := distributed.SyncMessage{
+			Action:    "set",
+			Key:       entry.Key,
+			Value:     value,
+			TTL:       time.Until(entry.ExpiresAt),
+			ExpiresAt: entry.ExpiresAt.UnixMilli(),
+			Version:   cache.Version(entry.Key),
+		}
+		persistence.WriteBehind(msg)
+		if !replicateChanges || mode == distributed.ReplicationLocal {
+			continue
+		}
+		distributed.PropagateChange(msg, peerManager)
+	}
+
+	log.Printf("📥 Importadas %d claves (%d caducadas, %d más antiguas que las locales, %d líneas no válidas)",
+		report.Imported, report.Expired, report.Stale, report.Invalid)
+	writeJSON(ctx, report)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log"
+	"phoenixcache/configuration"
+	"phoenixcache/distributed"
+
+	"github.com/valyala/fasthttp"
+)
+
+// routeWrite decide si una escritura de un cliente la atiende este nodo o se reenvía
+// al propietario de la clave, al primario o, en un observer, a un member. Devuelve
+// true si la petición ya está respondida
+func routeWrite(config *configuration.Config, peerManager *distributed.PeerManager, path string, ctx *fasthttp.RequestCtx) bool {
+	forwardable := isForwardableWrite(path)
+	observer := distributed.IsObserver()
+	if observer {
+		ctx.Response.Header.Set("X-Phoenix-Role", distributed.RoleObserver)
+		if !forwardable || !config.Observer.ForwardWrites || distributed.IsForwarded(ctx) {
+			ctx.SetStatusCode(fasthttp.StatusForbidden)
+			ctx.SetContentType("application/json")
+			ctx.SetBody([]byte(`{"error": "❌ Nodo de solo lectura (observer): las escrituras se envían a un member"}`))
+			return true
+		}
+	}
+
+	// Una escritura reenviada se atiende siempre aquí para no dar vueltas entre nodos
+	if !forwardable || !distributed.ForwardsWrites() || distributed.IsForwarded(ctx) {
+		return false
+	}
+
+	// Solo /set y /remove son de una clave concreta
+	var key string
+	if path == "/set" || path == "/remove" {
+		key = string(ctx.QueryArgs().Peek("key"))
+	}
+
+	owner, err := distributed.WriteOwner(peerManager, key)
+	if err != nil {
+		log.Printf("⚠️ No hay nodo al que reenviar la escritura: %v", err)
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetContentType("application/json")
+		ctx.SetBody([]byte(`{"error": "❌ No hay ningún nodo disponible para atender la escritura"}`))
+		return true
+	}
+
+	if owner == config.AdvertiseAddress || distributed.IsSelf(owner) {
+		ctx.Response.Header.Set(distributed.HeaderOwner, owner)
+		return false
+	}
+
+	if err := distributed.ForwardWrite(owner, ctx); err != nil {
+		log.Printf("⚠️ No se pudo reenviar la escritura a %s: %v", owner, err)
+		ctx.Response.Reset()
+		ctx.Response.Header.Set(distributed.HeaderOwner, owner)
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.SetContentType("application/json")
+		ctx.SetBody([]byte(`{"error": "❌ No se pudo reenviar la escritura al nodo propietario"}`))
+	}
+	return true
+}
@@ -2,11 +2,15 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"phoenixcache/distributed"
 	"phoenixcache/internal"
+	"phoenixcache/persistence"
 
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -36,14 +40,147 @@ func HandleSet(peerManager *distributed.PeerManager, cache *internal.Cache, ctx
 		ctx.SetBodyString(`{"error": "❌ TTL debe ser un número válido"}`)
 		return
 	}
+	if ttl <= 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ TTL debe ser mayor que 0"}`)
+		return
+	}
+
+	// Número de réplicas (incluida la local) que deben confirmar la escritura
+	w, ok := parseReplicas(ctx, "w")
+	if !ok {
+		return
+	}
+
+	mode, ok := parseReplication(ctx, key)
+	if !ok {
+		return
+	}
+	if mode == distributed.ReplicationLocal && w > 1 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Una clave solo local no admite 'w'"}`)
+		return
+	}
 
 	value := ctx.PostBody()
 	timeTtl := time.Duration(ttl) * time.Second
-	cache.Set(key, string(value), timeTtl)
-	distributed.PropagateChange(distributed.SyncMessage{Action: "set", Key: key, Value: string(value), TTL: timeTtl}, peerManager)
+
+	// En modo write-through la clave solo entra en la caché si el origen la acepta
+	if err := persistence.WriteThrough(key, value, timeTtl); err != nil {
+		respondOriginError(ctx, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(timeTtl)
+	version := cache.SetUntil(key, string(value), expiresAt)
+	cache.SetLocal(key, mode == distributed.ReplicationLocal)
+	msg := distributed.SyncMessage{Action: "set", Key: key, Value: string(value), TTL: timeTtl, ExpiresAt: expiresAt.UnixMilli(), Version: version}
+
+	if w <= 1 {
+		replicate(msg, peerManager, mode, ctx)
+		return
+	}
+
+	persistence.WriteBehind(msg)
+	acks := distributed.ReplicateQuorum(msg, peerManager, w-1) + 1
+	ctx.Response.Header.Set("X-Phoenix-Acks", strconv.Itoa(acks))
+	if acks < w {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(fmt.Sprintf(`{"error": "❌ Solo %d de %d réplicas confirmaron la escritura"}`, acks, w))
+		return
+	}
 	ctx.SetStatusCode(fasthttp.StatusOK)
 }
 
+// respondOriginError devuelve al cliente el rechazo del origen tal cual, o 502 si
+// el origen no respondió
+func respondOriginError(ctx *fasthttp.RequestCtx, err error) {
+	log.Printf("⚠️ Escritura rechazada: %v", err)
+
+	var originErr *persistence.OriginError
+	if errors.As(err, &originErr) && originErr.Status != 0 {
+		ctx.Response.Header.Set("X-Phoenix-Origin-Status", strconv.Itoa(originErr.Status))
+		ctx.SetStatusCode(originErr.Status)
+		if originErr.ContentType != "" {
+			ctx.SetContentType(originErr.ContentType)
+		}
+		ctx.SetBody(originErr.Body)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusBadGateway)
+	ctx.SetContentType("application/json")
+	errJSON, _ := json.Marshal("❌ " + err.Error())
+	ctx.SetBodyString(fmt.Sprintf(`{"error": %s}`, errJSON))
+}
+
+// parseReplication resuelve el modo de replicación de la clave ('replication' de la
+// petición o regla del prefijo)
+func parseReplication(ctx *fasthttp.RequestCtx, key string) (distributed.ReplicationMode, bool) {
+	mode, err := distributed.ResolveReplication(key, string(ctx.QueryArgs().Peek("replication")))
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ ` + err.Error() + `"}`)
+		return "", false
+	}
+	return mode, true
+}
+
+// removeMode decide cómo se propaga el borrado de una clave: manda cómo se guardó
+// (solo local o replicada), no la regla actual de su prefijo. Si la clave no está,
+// o se pide un modo explícito, se usa el modo resuelto
+func removeMode(cache *internal.Cache, key string, mode distributed.ReplicationMode, ctx *fasthttp.RequestCtx) distributed.ReplicationMode {
+	switch {
+	case cache.IsLocal(key):
+		return distributed.ReplicationLocal
+	case mode == distributed.ReplicationLocal && cache.Version(key) != 0 && !ctx.QueryArgs().Has("replication"):
+		return distributed.ReplicationAsync
+	}
+	return mode
+}
+
+// replicate propaga el cambio según el modo y, en modo sync, responde 503 si no lo
+// han confirmado todos los peers activos. El cambio también se encola para los sinks,
+// salvo un flush solo local: vaciaría el sink aunque el resto del cluster conserva
+// las claves. Devuelve false si ya se respondió con error
+func replicate(msg distributed.SyncMessage, peerManager *distributed.PeerManager, mode distributed.ReplicationMode, ctx *fasthttp.RequestCtx) bool {
+	if msg.Action != "flush" || mode != distributed.ReplicationLocal {
+		persistence.WriteBehind(msg)
+	}
+	acks, needed := distributed.Replicate(msg, peerManager, mode)
+	if mode == distributed.ReplicationSync {
+		ctx.Response.Header.Set("X-Phoenix-Acks", strconv.Itoa(acks+1))
+	}
+	if acks < needed {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(fmt.Sprintf(`{"error": "❌ Solo %d de %d peers confirmaron el cambio"}`, acks, needed))
+		return false
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	return true
+}
+
+// parseReplicas lee el número de réplicas de un parámetro (w / r). Por defecto 1
+func parseReplicas(ctx *fasthttp.RequestCtx, param string) (int, bool) {
+	if !ctx.QueryArgs().Has(param) {
+		return 1, true
+	}
+
+	replicas, err := strconv.Atoi(string(ctx.QueryArgs().Peek(param)))
+	if err != nil || replicas < 1 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(fmt.Sprintf(`{"error": "❌ '%s' debe ser un número mayor que 0"}`, param))
+		return 0, false
+	}
+	return replicas, true
+}
+
 // handleGet obtiene un valor de la caché
 func HandleGet(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 	key := string(ctx.QueryArgs().Peek("key"))
@@ -54,17 +191,73 @@ func HandleGet(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	value, found := cache.Get(key)
+	// Un valor comprimido con un códec que el cliente acepta se envía tal cual. Snappy
+	// no es una Content-Encoding de HTTP (el formato que se guarda es el de bloque,
+	// no el enmarcado), así que esos valores se envían siempre descomprimidos
+	value, codec, tier, found := cache.GetEncoded(key, func(codec string) bool {
+		return codec != internal.CodecSnappy && acceptsEncoding(ctx, codec)
+	})
 	if !found {
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		return
 	}
 
+	ctx.Response.Header.Set("X-Phoenix-Tier", tier)
+	ctx.Response.Header.Set(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+	if codec != "" {
+		ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, codec)
+	}
 	ctx.SetContentType("application/json")
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetBodyString(value.(string))
 }
 
+// acceptsEncoding indica si el cliente acepta la codificación en Accept-Encoding
+func acceptsEncoding(ctx *fasthttp.RequestCtx, encoding string) bool {
+	for _, accepted := range strings.Split(string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(accepted), ";")
+		if strings.EqualFold(strings.TrimSpace(name), encoding) {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// HandleQuorumGet obtiene un valor preguntando a 'r' réplicas y devuelve la versión más reciente
+func HandleQuorumGet(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	key := string(ctx.QueryArgs().Peek("key"))
+
+	if key == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error": "❌ Parámetro 'key' requerido"}`)
+		return
+	}
+
+	r, ok := parseReplicas(ctx, "r")
+	if !ok {
+		return
+	}
+
+	result, err := distributed.ReadQuorum(key, cache, peerManager, r)
+	ctx.Response.Header.Set("X-Phoenix-Replicas", strconv.Itoa(result.Responses))
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(fmt.Sprintf(`{"error": "❌ Solo respondieron %d de %d réplicas"}`, result.Responses, r))
+		return
+	}
+
+	if !result.Found {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	ctx.Response.Header.Set("X-Phoenix-Version", strconv.FormatUint(result.Version, 10))
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprint(result.Value))
+}
+
 // handleList devuelve un listado de la caché
 func HandleList(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 
@@ -86,9 +279,13 @@ func HandleList(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 
 // handleFlushAll borra toda la caché
 func HandleFlushAll(peerManager *distributed.PeerManager, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	mode, ok := parseReplication(ctx, "")
+	if !ok {
+		return
+	}
+
 	cache.FlushAll()
-	distributed.PropagateChange(distributed.SyncMessage{Action: "flush", Key: "", Value: nil, TTL: 0}, peerManager)
-	ctx.SetStatusCode(fasthttp.StatusOK)
+	replicate(distributed.SyncMessage{Action: "flush", Key: "", Value: nil, TTL: 0}, peerManager, mode, ctx)
 }
 
 // handle que elimina una Key concreta
@@ -101,9 +298,14 @@ func HandleRemoveKey(peerManager *distributed.PeerManager, cache *internal.Cache
 		return
 	}
 
+	mode, ok := parseReplication(ctx, key)
+	if !ok {
+		return
+	}
+	mode = removeMode(cache, key, mode, ctx)
+
 	cache.RemoveKey(key)
-	distributed.PropagateChange(distributed.SyncMessage{Action: "remove", Key: key, Value: nil, TTL: 0}, peerManager)
-	ctx.SetStatusCode(fasthttp.StatusOK)
+	replicate(distributed.SyncMessage{Action: "remove", Key: key, Value: nil, TTL: 0}, peerManager, mode, ctx)
 }
 
 // handle que liminakeys de la cache en funcion de un parametro
@@ -116,9 +318,16 @@ func HandleDeleteByPattern(peerManager *distributed.PeerManager, cache *internal
 		return
 	}
 
+	mode, ok := parseReplication(ctx, pattern)
+	if !ok {
+		return
+	}
+
 	deletedKeys := cache.RemovePatternKey(pattern)
 
-	distributed.PropagateChange(distributed.SyncMessage{Action: "removePattern", Key: pattern, Value: nil, TTL: 0}, peerManager)
+	if !replicate(distributed.SyncMessage{Action: "removePattern", Key: pattern, Value: nil, TTL: 0}, peerManager, mode, ctx) {
+		return
+	}
 
 	jsonResponse, _ := json.Marshal(deletedKeys)
 
@@ -177,28 +386,21 @@ func HandleGetKeys(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	// Respuesta con valores encontrados
-	response := make(map[string]struct {
-		Value      interface{} `json:"value"`
-		Expiration time.Time   `json:"expiration"`
-	})
+	// Respuesta con valores encontrados. A otros nodos (que mandan la cabecera de
+	// protocolo) no se les dan las claves solo locales
+	response := make(map[string]distributed.KeyValue)
+	fromNode := len(ctx.Request.Header.Peek(distributed.HeaderProtocol)) > 0
 
 	internal.CacheMutex.Lock()
 	defer internal.CacheMutex.Unlock()
 
 	for _, key := range keys {
-		val, expTime, found := cache.GetWithExpiry(key)
-		if expTime == nil {
-			continue
-		}
-		timeLeft := expTime.(time.Time)
-		if found {
-			response[key] = struct {
-				Value      interface{} `json:"value"`
-				Expiration time.Time   `json:"expiration"`
-			}{
+		val, expTime, version, found := cache.GetVersioned(key)
+		if found && !(fromNode && cache.IsLocal(key)) {
+			response[key] = distributed.KeyValue{
 				Value:      val,
-				Expiration: timeLeft,
+				Expiration: expTime,
+				Version:    version,
 			}
 		}
 	}
@@ -211,6 +413,7 @@ func HandleGetKeys(cache *internal.Cache, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	distributed.SetProtocolHeaders(ctx)
 	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetContentType("application/json")
 	ctx.SetBody(data)
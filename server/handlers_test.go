@@ -0,0 +1,88 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestHandleSetRejectsNonPositiveTTL(t *testing.T) {
+	for _, ttl := range []string{"0", "-5"} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/set?key=a&ttl=" + ttl)
+		ctx.Request.SetBodyString("v")
+
+		HandleSet(nil, nil, &ctx)
+
+		if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+			t.Fatalf("ttl=%s: código %d, se esperaba 400", ttl, ctx.Response.StatusCode())
+		}
+		if got := string(ctx.Response.Body()); got != `{"error": "❌ TTL debe ser mayor que 0"}` {
+			t.Fatalf("ttl=%s: cuerpo %s", ttl, got)
+		}
+	}
+}
+
+func TestHandleStrongSetRejectsNonPositiveTTL(t *testing.T) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/set?key=a&ttl=0")
+
+	HandleStrongSet(nil, &ctx)
+
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("código %d, se esperaba 400", ctx.Response.StatusCode())
+	}
+}
+
+func TestRemoveModeFollowsTheStoredKey(t *testing.T) {
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.Set("solo", "v", time.Minute)
+	cache.SetLocal("solo", true)
+	cache.Set("compartida", "v", time.Minute)
+
+	cases := []struct {
+		uri  string
+		key  string
+		mode distributed.ReplicationMode
+		want distributed.ReplicationMode
+	}{
+		{"/remove?key=solo", "solo", distributed.ReplicationAsync, distributed.ReplicationLocal},
+		{"/remove?key=solo&replication=sync", "solo", distributed.ReplicationSync, distributed.ReplicationLocal},
+		{"/remove?key=compartida", "compartida", distributed.ReplicationLocal, distributed.ReplicationAsync},
+		{"/remove?key=compartida&replication=local", "compartida", distributed.ReplicationLocal, distributed.ReplicationLocal},
+		{"/remove?key=nueva", "nueva", distributed.ReplicationLocal, distributed.ReplicationLocal},
+	}
+	for _, c := range cases {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(c.uri)
+		if got := removeMode(cache, c.key, c.mode, &ctx); got != c.want {
+			t.Fatalf("%s: modo %s, se esperaba %s", c.uri, got, c.want)
+		}
+	}
+}
+
+func TestHandleGetDecompressesSnappyValues(t *testing.T) {
+	cache := internal.NewCache(1000, 1<<20, 64)
+	cache.EnableCompression(internal.CompressionOptions{Codec: internal.CodecSnappy, MinSize: 16})
+	value := strings.Repeat("abc", 1000)
+	cache.Set("k", value, time.Minute)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/get?key=k")
+	ctx.Request.Header.Set(fasthttp.HeaderAcceptEncoding, "snappy, gzip")
+
+	HandleGet(cache, &ctx)
+
+	if encoding := ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding); len(encoding) != 0 {
+		t.Fatalf("Content-Encoding = %s, snappy se debe enviar descomprimido", encoding)
+	}
+	if string(ctx.Response.Body()) != value {
+		t.Fatalf("el cuerpo no es el valor original")
+	}
+}
@@ -2,27 +2,66 @@ package server
 
 import (
 	"phoenixcache/configuration"
+	"phoenixcache/consensus"
 	"phoenixcache/distributed"
 	"phoenixcache/internal"
+	"phoenixcache/persistence"
 	"phoenixcache/security"
 
 	"github.com/valyala/fasthttp"
 )
 
 // SetupRouter configura las rutas del servidor
-func SetupRouter(config *configuration.Config, peerManager *distributed.PeerManager, cache *internal.Cache) fasthttp.RequestHandler {
+func SetupRouter(config *configuration.Config, peerManager *distributed.PeerManager, raftNode *consensus.Node, cache *internal.Cache) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 
 		if !isAllowedNode(config, ctx) {
 			return
 		}
 
-		switch string(ctx.Path()) {
+		path := string(ctx.Path())
+		if isClientTraffic(path) && !distributed.IsReady() {
+			ctx.Response.Header.Set("Retry-After", "1")
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			ctx.SetContentType("application/json")
+			ctx.SetBody([]byte(`{"error": "❌ Nodo arrancando: todavía no se atiende a los clientes (ver /ready)"}`))
+			return
+		}
+		if isClientWrite(path) && !(isPeerWrite(path) && distributed.FromMember(peerManager, ctx)) {
+			if peerManager.Partition().RejectWrites() && !distributed.IsObserver() {
+				ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+				ctx.SetContentType("application/json")
+				ctx.SetBody([]byte(`{"error": "❌ Nodo en minoría: no se aceptan escrituras hasta que se recupere la partición"}`))
+				return
+			}
+			// Las claves con consistencia fuerte ya redirigen al líder de Raft
+			if (distributed.IsObserver() || !isStrongKey(raftNode, ctx)) && routeWrite(config, peerManager, path, ctx) {
+				return
+			}
+		}
+
+		switch path {
 		case "/set":
+			if isStrongKey(raftNode, ctx) {
+				HandleStrongSet(raftNode, ctx)
+				return
+			}
 			HandleSet(peerManager, cache, ctx)
 		case "/get":
+			if isStrongKey(raftNode, ctx) {
+				HandleStrongGet(raftNode, cache, ctx)
+				return
+			}
+			if ctx.QueryArgs().Has("r") {
+				HandleQuorumGet(peerManager, cache, ctx)
+				return
+			}
 			HandleGet(cache, ctx)
 		case "/trygetwithexpire":
+			if isStrongKey(raftNode, ctx) {
+				HandleStrongTryGetWithExpire(raftNode, cache, ctx)
+				return
+			}
 			HandleTryGetWithExpire(cache, ctx)
 		case "/getKeys":
 			HandleGetKeys(cache, ctx)
@@ -33,23 +72,140 @@ func SetupRouter(config *configuration.Config, peerManager *distributed.PeerMana
 		case "/removeallkeys":
 			HandleDeleteByPattern(peerManager, cache, ctx)
 		case "/remove":
+			if isStrongKey(raftNode, ctx) {
+				HandleStrongRemove(raftNode, ctx)
+				return
+			}
 			HandleRemoveKey(peerManager, cache, ctx)
 		case "/sync":
 			distributed.SyncHandler(cache, ctx)
+		case "/sync_bin":
+			distributed.HandleSyncBinary(cache, ctx)
 		case "/ping":
-			distributed.HandlePing(ctx)
+			distributed.HandlePing(peerManager, ctx)
+		case "/stats":
+			HandleStats(peerManager, cache, ctx)
+		case "/keys":
+			HandleKeys(cache, ctx)
+		case "/cluster/list":
+			HandleClusterList(peerManager, cache, ctx)
+		case "/cluster/keys":
+			HandleClusterKeys(peerManager, cache, ctx)
+		case "/cluster/stats":
+			HandleClusterStats(peerManager, cache, ctx)
+		case "/cluster/flush":
+			HandleClusterFlush(peerManager, cache, ctx)
+		case "/hello":
+			distributed.HandleHello(peerManager, ctx)
+		case "/info":
+			distributed.HandleInfo(cache, ctx)
 		case "/export":
-			distributed.HandleExportCache(cache, ctx)
+			distributed.HandleExportCache(peerManager, cache, ctx)
 		case "/diff":
 			distributed.HandleDiff(cache, ctx)
 		case "/set_batch":
 			distributed.HandleSetBatch(peerManager, cache)
+		case "/replace":
+			distributed.HandleReplace(cache, ctx)
+		case "/sync_region":
+			distributed.HandleSyncRegion(peerManager, cache, ctx)
+		case "/admin/regions":
+			distributed.HandleRegionStatus(peerManager, ctx)
+		case "/admin/peers":
+			HandleAdminListPeers(peerManager, ctx)
+		case "/admin/peers/add":
+			HandleAdminAddPeer(config, peerManager, ctx)
+		case "/admin/peers/remove":
+			HandleAdminRemovePeer(config, peerManager, ctx)
+		case "/admin/bootstrap":
+			distributed.HandleBootstrapStatus(ctx)
+		case "/admin/warmup":
+			distributed.HandleWarmupStatus(ctx)
+		case "/ready":
+			distributed.HandleReady(ctx)
+		case "/admin/partition":
+			distributed.HandlePartitionStatus(peerManager, ctx)
+		case "/admin/snapshot":
+			persistence.HandleSnapshot(ctx)
+		case "/admin/backups":
+			persistence.HandleBackups(ctx)
+		case "/admin/restore":
+			persistence.HandleRestore(peerManager, ctx)
+		case "/admin/export":
+			HandleAdminExport(config, cache, ctx)
+		case "/admin/import":
+			HandleAdminImport(config, peerManager, cache, ctx)
+		case "/admin/sinks":
+			persistence.HandleSinks(ctx)
+		case "/admin/wal":
+			persistence.HandleWAL(ctx)
+		case "/admin/hints":
+			HandleAdminHints(peerManager, ctx)
+		case "/admin/peers/resync":
+			HandleAdminResync(peerManager, cache, ctx)
+		case "/raft/vote":
+			consensus.HandleRequestVote(raftNode, ctx)
+		case "/raft/append":
+			consensus.HandleAppendEntries(raftNode, ctx)
+		case "/raft/snapshot":
+			consensus.HandleInstallSnapshot(raftNode, ctx)
+		case "/raft/status":
+			consensus.HandleStatus(raftNode, ctx)
+		case "/gossip/join":
+			distributed.HandleGossipJoin(peerManager, ctx)
+		case "/gossip/ping":
+			distributed.HandleGossipPing(peerManager, ctx)
+		case "/gossip/ping-req":
+			distributed.HandleGossipPingReq(peerManager, ctx)
+		case "/gossip/members":
+			distributed.HandleGossipMembers(peerManager, ctx)
 		default:
 			ctx.SetStatusCode(fasthttp.StatusNotFound)
 		}
 	}
 }
 
+// isClientWrite indica si la ruta es una escritura de cliente, que se rechaza en los
+// observers y en el lado minoritario de una partición. La replicación entre nodos
+// (/sync) se sigue aceptando
+func isClientWrite(path string) bool {
+	switch path {
+	case "/set_batch", "/replace", "/admin/restore", "/admin/import":
+		return true
+	}
+	return isForwardableWrite(path)
+}
+
+// isForwardableWrite indica si la escritura se puede atender en otro nodo. Las que
+// recargan o sustituyen la caché de este nodo solo se atienden aquí
+func isForwardableWrite(path string) bool {
+	switch path {
+	case "/set", "/remove", "/removeallkeys", "/flush", "/cluster/flush":
+		return true
+	}
+	return false
+}
+
+// isPeerWrite indica si la escritura la lanza también un member sobre el resto de
+// nodos (diff al volver un peer, restauración en todo el cluster). Viniendo de un
+// member se acepta igual que /sync
+func isPeerWrite(path string) bool {
+	return path == "/set_batch" || path == "/replace"
+}
+
+// isClientTraffic indica si la ruta es una lectura o escritura de cliente, que no se
+// atiende hasta que el nodo está listo. Las rutas entre nodos funcionan desde el arranque.
+// /getKeys y /keys también las usan los peers, pero un nodo que arranca aún no tiene
+// todas las claves y así prueban con el siguiente
+func isClientTraffic(path string) bool {
+	switch path {
+	case "/get", "/trygetwithexpire", "/getKeys", "/list", "/keys",
+		"/cluster/list", "/cluster/keys", "/cluster/stats":
+		return true
+	}
+	return isClientWrite(path)
+}
+
 func isAllowedNode(config *configuration.Config, ctx *fasthttp.RequestCtx) bool {
 
 	ip := ctx.RemoteIP().String()
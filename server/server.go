@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"phoenixcache/configuration"
+	"phoenixcache/consensus"
 	"phoenixcache/distributed"
 	"phoenixcache/internal"
 
@@ -12,10 +13,10 @@ import (
 )
 
 // StartServer inicia el servidor
-func StartServer(config *configuration.Config, peerManager *distributed.PeerManager, cache *internal.Cache) {
+func StartServer(config *configuration.Config, peerManager *distributed.PeerManager, raftNode *consensus.Node, cache *internal.Cache) {
 
 	server := &fasthttp.Server{
-		Handler:            SetupRouter(config, peerManager, cache),
+		Handler:            SetupRouter(config, peerManager, raftNode, cache),
 		Name:               "UltraFastServer",
 		ReadTimeout:        time.Duration(config.ReadTimeout) * time.Second,
 		WriteTimeout:       time.Duration(config.WriteTimeout) * time.Second,
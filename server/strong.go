@@ -0,0 +1,139 @@
+package server
+
+import (
+	"log"
+	"phoenixcache/consensus"
+	"phoenixcache/distributed"
+	"phoenixcache/internal"
+	"phoenixcache/persistence"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+//********************************************************************
+// Handlers para las claves con consistencia fuerte (Raft)
+//********************************************************************
+
+// isStrongKey indica si la clave de la petición la gestiona Raft
+func isStrongKey(raftNode *consensus.Node, ctx *fasthttp.RequestCtx) bool {
+	return raftNode.Owns(string(ctx.QueryArgs().Peek("key")))
+}
+
+// HandleStrongSet replica el valor por el log de Raft y solo responde cuando lo ha
+// confirmado una mayoría. Si la clave es write-through, el líder la envía antes a su
+// origen y solo la propone si la acepta. Los sinks solo reciben el cambio del nodo que
+// lo propuso
+func HandleStrongSet(raftNode *consensus.Node, ctx *fasthttp.RequestCtx) {
+	key := string(ctx.QueryArgs().Peek("key"))
+	ttlStr := string(ctx.QueryArgs().Peek("ttl"))
+
+	if key == "" || ttlStr == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ Parámetros 'key' y 'ttl' son requeridos"}`)
+		return
+	}
+
+	ttl, err := strconv.Atoi(ttlStr)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ TTL debe ser un número válido"}`)
+		return
+	}
+	if ttl <= 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"error": "❌ TTL debe ser mayor que 0"}`)
+		return
+	}
+
+	// Solo el líder escribe en el origen: un seguidor redirige sin tocarlo
+	if !raftNode.IsLeader() {
+		handleRaftError(raftNode, consensus.ErrNotLeader, ctx)
+		return
+	}
+	timeTtl := time.Duration(ttl) * time.Second
+	if err := persistence.WriteThrough(key, ctx.PostBody(), timeTtl); err != nil {
+		respondOriginError(ctx, err)
+		return
+	}
+
+	cmd := consensus.Command{
+		Op:        "set",
+		Key:       key,
+		Value:     string(ctx.PostBody()),
+		ExpiresAt: time.Now().Add(timeTtl).UnixMilli(),
+	}
+	if !proposeOrRedirect(raftNode, cmd, ctx) {
+		return
+	}
+	persistence.WriteBehind(distributed.SyncMessage{Action: "set", Key: key, Value: cmd.Value, TTL: timeTtl, ExpiresAt: cmd.ExpiresAt})
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleStrongRemove elimina la clave a través del log de Raft
+func HandleStrongRemove(raftNode *consensus.Node, ctx *fasthttp.RequestCtx) {
+	key := string(ctx.QueryArgs().Peek("key"))
+
+	if !proposeOrRedirect(raftNode, consensus.Command{Op: "remove", Key: key}, ctx) {
+		return
+	}
+	persistence.WriteBehind(distributed.SyncMessage{Action: "remove", Key: key})
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleStrongGet hace una lectura linealizable en el líder
+func HandleStrongGet(raftNode *consensus.Node, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	if !readBarrierOrRedirect(raftNode, ctx) {
+		return
+	}
+	HandleGet(cache, ctx)
+}
+
+// HandleStrongTryGetWithExpire hace una lectura linealizable (con expiración) en el líder
+func HandleStrongTryGetWithExpire(raftNode *consensus.Node, cache *internal.Cache, ctx *fasthttp.RequestCtx) {
+	if !readBarrierOrRedirect(raftNode, ctx) {
+		return
+	}
+	HandleTryGetWithExpire(cache, ctx)
+}
+
+func proposeOrRedirect(raftNode *consensus.Node, cmd consensus.Command, ctx *fasthttp.RequestCtx) bool {
+	err := raftNode.Propose(cmd, raftNode.RequestTimeout())
+	return handleRaftError(raftNode, err, ctx)
+}
+
+func readBarrierOrRedirect(raftNode *consensus.Node, ctx *fasthttp.RequestCtx) bool {
+	err := raftNode.ReadBarrier(raftNode.RequestTimeout())
+	return handleRaftError(raftNode, err, ctx)
+}
+
+// handleRaftError redirige al líder si el nodo no lo es, o devuelve el error
+func handleRaftError(raftNode *consensus.Node, err error, ctx *fasthttp.RequestCtx) bool {
+	if err == nil {
+		return true
+	}
+
+	if err == consensus.ErrNotLeader {
+		leader := raftNode.Leader()
+		if leader == "" {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			ctx.SetContentType("application/json")
+			ctx.SetBodyString(`{"error": "❌ No hay líder Raft disponible"}`)
+			return false
+		}
+
+		ctx.Response.Header.Set("X-Phoenix-Leader", leader)
+		ctx.Redirect(leader+string(ctx.RequestURI()), fasthttp.StatusTemporaryRedirect)
+		return false
+	}
+
+	log.Printf("⚠️ Error en operación Raft: %v", err)
+	ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(`{"error": "❌ ` + err.Error() + `"}`)
+	return false
+}